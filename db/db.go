@@ -3,16 +3,40 @@ package db
 import (
 	"database/sql"
 	_ "embed"
-
-	_ "github.com/mattn/go-sqlite3"
+	"fmt"
+	"strings"
 )
 
 //go:embed schema.sql
 var schema string
 
+// columns lists the columns added after a table was first released.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so they are
+// added to databases created by an older schema here.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{table: "todos", name: "version", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "todos", name: "group_name", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "todos", name: "parent_id", definition: "INTEGER"},
+	{table: "todos", name: "caldav_name", definition: "TEXT"},
+	{table: "todos", name: "caldav_uid", definition: "TEXT"},
+	{table: "todo_events", name: "prior_data", definition: "TEXT"},
+}
+
 // NewDB returns go-sqlite3 driver based *sql.DB.
 func NewDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	// transactions take the write lock when they begin, because SQLite fails
+	// at once without waiting for busy_timeout when a transaction which has
+	// read upgrades to write while another one is writing. Read-only ones are
+	// deferred by the driver.
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open(driverName, path+sep+"_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -21,5 +45,56 @@ func NewDB(path string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
+
+// migrate adds the missing columns to the existing tables.
+func migrate(db *sql.DB) error {
+	for _, c := range columns {
+		exists, err := hasColumn(db, c.table, c.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		alter := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.name, c.definition)
+		if _, err := db.Exec(alter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			typ       string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
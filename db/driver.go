@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the name of the go-sqlite3 driver which begins read-only
+// transactions deferred.
+const driverName = "sqlite3_deferred_read"
+
+func init() {
+	sql.Register(driverName, &readDeferringDriver{})
+}
+
+// A readDeferringDriver opens go-sqlite3 connections whose read-only
+// transactions begin deferred, whatever the _txlock of the DSN is. They take
+// no lock until they read, and then only the shared one, so that readers
+// neither wait for nor block each other.
+type readDeferringDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *readDeferringDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &readDeferringConn{SQLiteConn: c.(*sqlite3.SQLiteConn)}, nil
+}
+
+type readDeferringConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *readDeferringConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if !opts.ReadOnly {
+		return c.SQLiteConn.BeginTx(ctx, opts)
+	}
+	if _, err := c.ExecContext(ctx, "BEGIN DEFERRED", nil); err != nil {
+		return nil, err
+	}
+	return &readTx{c: c.SQLiteConn}, nil
+}
+
+// A readTx is a read-only transaction, whose commit does not wait for the
+// write lock.
+type readTx struct {
+	c *sqlite3.SQLiteConn
+}
+
+func (tx *readTx) Commit() error {
+	_, err := tx.c.Exec("COMMIT", nil)
+	return err
+}
+
+func (tx *readTx) Rollback() error {
+	_, err := tx.c.Exec("ROLLBACK", nil)
+	return err
+}
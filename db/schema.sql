@@ -2,6 +2,11 @@ CREATE TABLE IF NOT EXISTS todos (
   id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
   subject     TEXT     NOT NULL,
   description TEXT     NOT NULL DEFAULT '',
+  version     INTEGER  NOT NULL DEFAULT 0,
+  group_name  TEXT     NOT NULL DEFAULT '',
+  parent_id   INTEGER,
+  caldav_name TEXT,
+  caldav_uid  TEXT,
   created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
   updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
   CHECK(subject <> '')
@@ -11,3 +16,73 @@ CREATE TRIGGER IF NOT EXISTS trigger_todos_updated_at AFTER UPDATE ON todos
 BEGIN
   UPDATE todos SET updated_at = DATETIME('now') WHERE id == NEW.id;
 END;
+
+CREATE TABLE IF NOT EXISTS todos_deleted (
+  id         INTEGER  NOT NULL PRIMARY KEY CHECK(id = 1),
+  deleted_at DATETIME NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS trigger_todos_deleted_at AFTER DELETE ON todos
+BEGIN
+  INSERT OR REPLACE INTO todos_deleted(id, deleted_at) VALUES (1, DATETIME('now'));
+END;
+
+CREATE TABLE IF NOT EXISTS todo_events (
+  id         INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  type       TEXT     NOT NULL,
+  todo_id    INTEGER  NOT NULL,
+  data       TEXT,
+  prior_data TEXT,
+  created_at DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_todo_events_created_at ON todo_events(created_at);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  url         TEXT     NOT NULL,
+  event_types TEXT     NOT NULL DEFAULT '',
+  secret      TEXT     NOT NULL,
+  active      INTEGER  NOT NULL DEFAULT 1,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id              INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  webhook_id      INTEGER  NOT NULL,
+  event_id        INTEGER  NOT NULL,
+  event_type      TEXT     NOT NULL,
+  payload         TEXT     NOT NULL,
+  status          TEXT     NOT NULL DEFAULT 'pending',
+  attempts        INTEGER  NOT NULL DEFAULT 0,
+  next_attempt_at DATETIME,
+  redelivery_of   INTEGER,
+  created_at      DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_webhook_deliveries_next_attempt_at ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS index_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  delivery_id INTEGER  NOT NULL,
+  status_code INTEGER  NOT NULL DEFAULT 0,
+  error       TEXT     NOT NULL DEFAULT '',
+  duration_ms INTEGER  NOT NULL DEFAULT 0,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_webhook_attempts_delivery_id ON webhook_attempts(delivery_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key         TEXT     NOT NULL PRIMARY KEY,
+  fingerprint TEXT     NOT NULL,
+  status      INTEGER  NOT NULL DEFAULT 0,
+  header      TEXT     NOT NULL DEFAULT '{}',
+  body        BLOB     NOT NULL DEFAULT '',
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  expires_at  DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS index_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -3,8 +3,16 @@ module github.com/TechBowl-japan/go-stations
 go 1.16
 
 require (
-	github.com/google/go-cmp v0.5.6 // indirect
+	github.com/google/go-cmp v0.5.6
+	github.com/gorilla/websocket v1.5.3
+	github.com/graph-gophers/graphql-go v1.3.0
 	github.com/jstemmer/go-junit-report v0.9.1 // indirect
 	github.com/mattn/go-sqlite3 v1.14.7
-	github.com/mileusna/useragent v1.0.2 // indirect
+	github.com/mileusna/useragent v1.0.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/text v0.3.6
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.47.0
+	google.golang.org/protobuf v1.28.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A BackupHandler implements the admin endpoint that streams the backup of
+// every table as a JSON document.
+type BackupHandler struct {
+	svc *service.BackupService
+}
+
+// NewBackupHandler returns BackupHandler based http.Handler.
+func NewBackupHandler(svc *service.BackupService) *BackupHandler {
+	return &BackupHandler{
+		svc: svc,
+	}
+}
+
+func (h *BackupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		filename := fmt.Sprintf("todos-backup-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+		// errors after the status is sent cut the document short
+		sw := &sentWriter{w: w}
+		if err := h.svc.Dump(r.Context(), sw); err != nil {
+			if sw.sent {
+				log.Println(err)
+				return
+			}
+			w.Header().Del("Content-Disposition")
+			WriteError(w, r, err)
+		}
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// A sentWriter records whether anything was written to w.
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (w *sentWriter) Write(p []byte) (int, error) {
+	w.sent = true
+	return w.w.Write(p)
+}
+
+// A RestoreHandler implements the admin endpoint that restores a backup made
+// by BackupHandler.
+type RestoreHandler struct {
+	svc *service.BackupService
+}
+
+// NewRestoreHandler returns RestoreHandler based http.Handler.
+func NewRestoreHandler(svc *service.BackupService) *RestoreHandler {
+	return &RestoreHandler{
+		svc: svc,
+	}
+}
+
+// Restore handles the endpoint that restores the backup read from body.
+func (h *RestoreHandler) Restore(ctx context.Context, req *model.RestoreRequest, body io.Reader) (*model.RestoreResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+	if req.OnConflict == "" {
+		req.OnConflict = model.RestoreSkip
+	}
+	return h.svc.Restore(ctx, body, req.OnConflict)
+}
+
+func (h *RestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Add("Vary", "Accept")
+		c, err := negotiate(r.Header.Get("Accept"), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != "application/json" {
+				WriteError(w, r, model.ErrUnsupportedMediaType{})
+				return
+			}
+		}
+
+		request := &model.RestoreRequest{OnConflict: r.URL.Query().Get("on_conflict")}
+		response, err := h.Restore(r.Context(), request, r.Body)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		writeResponse(w, c, http.StatusOK, response)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
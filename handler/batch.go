@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+var (
+	// errBatchFailed rolls back the transaction of an atomic batch request.
+	errBatchFailed = errors.New("batch request failed")
+
+	// referencePattern matches @{ref.path} in the body of an operation.
+	referencePattern = regexp.MustCompile(`@\{([^.{}]+)((?:\.[^.{}]+)*)\}`)
+)
+
+// A dependencyError is returned by an operation that refers to a failed one.
+type dependencyError struct {
+	ref string
+}
+
+func (e dependencyError) Error() string {
+	return fmt.Sprintf("operation %q failed", e.ref)
+}
+
+// A BatchHandler implements handling the batch endpoint.
+type BatchHandler struct {
+	todo *TODOHandler
+}
+
+// NewBatchHandler returns BatchHandler based http.Handler.
+func NewBatchHandler(svc *service.TODOService) *BatchHandler {
+	return &BatchHandler{
+		todo: NewTODOHandler(svc),
+	}
+}
+
+// Batch handles the endpoint that runs the operations in order. Atomic batches
+// run in one transaction and stop at the first operation that fails.
+func (h *BatchHandler) Batch(ctx context.Context, req *model.BatchRequest) (*model.BatchResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+	refs := make(map[string]bool, len(req.Operations))
+	for i, op := range req.Operations {
+		if op == nil {
+			return nil, model.ErrValidation{Message: fmt.Sprintf("operations[%d] is required", i)}
+		}
+		if op.Ref == "" {
+			continue
+		}
+		if refs[op.Ref] {
+			return nil, model.ErrValidation{Message: fmt.Sprintf("duplicate ref %q", op.Ref)}
+		}
+		refs[op.Ref] = true
+	}
+
+	var (
+		results = make([]*model.BatchResult, len(req.Operations))
+		bodies  = make(map[string]interface{})
+		failed  = make(map[string]bool)
+	)
+	run := func(ctx context.Context) error {
+		for i, op := range req.Operations {
+			result := &model.BatchResult{Ref: op.Ref}
+			results[i] = result
+
+			if req.Atomic && len(failed) > 0 {
+				result.Status = http.StatusFailedDependency
+				continue
+			}
+
+			body, err := h.dispatch(ctx, op, func(ref string) (interface{}, error) {
+				if failed[ref] {
+					return nil, dependencyError{ref: ref}
+				}
+				body, ok := bodies[ref]
+				if !ok {
+					return nil, model.ErrValidation{Message: fmt.Sprintf("unknown ref %q", ref)}
+				}
+				return body, nil
+			})
+			if err != nil {
+				result.Error = NewProblem(err)
+				result.Status = result.Error.Status
+				failed[op.Ref] = true
+				continue
+			}
+
+			result.Status = http.StatusOK
+			result.Body = body
+			if op.Ref != "" {
+				bodies[op.Ref] = body
+			}
+		}
+
+		if req.Atomic && len(failed) > 0 {
+			return errBatchFailed
+		}
+		return nil
+	}
+
+	var err error
+	if req.Atomic {
+		err = h.todo.svc.RunInTx(ctx, run)
+	} else {
+		err = run(ctx)
+	}
+
+	response := &model.BatchResponse{Results: results}
+	switch err {
+	case nil:
+		break
+	case errBatchFailed:
+		response.RolledBack = true
+		for _, result := range results {
+			if result.Status == http.StatusOK {
+				result.Status = http.StatusFailedDependency
+				result.Body = nil
+			}
+		}
+	default:
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// dispatch runs the operation with the TODOHandler and returns its response
+// body decoded into maps, so that later operations can refer to it.
+func (h *BatchHandler) dispatch(ctx context.Context, op *model.BatchOperation, lookup func(ref string) (interface{}, error)) (interface{}, error) {
+	body, err := resolveReferences(op.Body, lookup)
+	if err != nil {
+		return nil, err
+	}
+	ifMatch, err := substituteReferences(op.IfMatch, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var response interface{}
+	switch strings.ToUpper(op.Method) {
+	case http.MethodGet:
+		request := model.ReadTODORequest{Size: defaultReadSize}
+		if err := unmarshalRequest(body, &request); err != nil {
+			return nil, err
+		}
+		response, err = h.todo.Read(ctx, &request)
+
+	case http.MethodPost:
+		var request model.CreateTODORequest
+		if err := unmarshalRequest(body, &request); err != nil {
+			return nil, err
+		}
+		response, err = h.todo.Create(ctx, &request)
+
+	case http.MethodPut:
+		var request model.UpdateTODORequest
+		if err := unmarshalRequest(body, &request); err != nil {
+			return nil, err
+		}
+		if err := setUpdateIfMatch(&request, ifMatch); err != nil {
+			return nil, err
+		}
+		response, err = h.todo.Update(ctx, &request)
+
+	case http.MethodDelete:
+		var request model.DeleteTODORequest
+		if err := unmarshalRequest(body, &request); err != nil {
+			return nil, err
+		}
+		setDeleteIfMatch(&request, ifMatch)
+		response, err = h.todo.Delete(ctx, &request)
+
+	default:
+		return nil, model.ErrValidation{Message: fmt.Sprintf("unknown method %q", op.Method)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(b)
+}
+
+// resolveReferences replaces @{ref.path} in the strings of body. A string that
+// consists only of a reference is replaced with the referred value itself, so
+// that numbers such as ids keep their type.
+func resolveReferences(body json.RawMessage, lookup func(ref string) (interface{}, error)) ([]byte, error) {
+	if len(body) == 0 {
+		return []byte("{}"), nil
+	}
+
+	v, err := decodeJSON(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolve func(v interface{}) (interface{}, error)
+	resolve = func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				r, err := resolve(e)
+				if err != nil {
+					return nil, err
+				}
+				v[k] = r
+			}
+			return v, nil
+
+		case []interface{}:
+			for i, e := range v {
+				r, err := resolve(e)
+				if err != nil {
+					return nil, err
+				}
+				v[i] = r
+			}
+			return v, nil
+
+		case string:
+			if m := referencePattern.FindStringSubmatch(v); m != nil && m[0] == v {
+				return lookupPath(m[1], m[2], lookup)
+			}
+
+			return substituteReferences(v, lookup)
+
+		default:
+			return v, nil
+		}
+	}
+
+	resolved, err := resolve(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resolved)
+}
+
+// substituteReferences replaces @{ref.path} in s with the text of the referred values.
+func substituteReferences(s string, lookup func(ref string) (interface{}, error)) (string, error) {
+	var rerr error
+	s = referencePattern.ReplaceAllStringFunc(s, func(s string) string {
+		m := referencePattern.FindStringSubmatch(s)
+		r, err := lookupPath(m[1], m[2], lookup)
+		if err != nil {
+			rerr = err
+			return s
+		}
+		if str, ok := r.(string); ok {
+			return str
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			rerr = err
+			return s
+		}
+		return string(b)
+	})
+	return s, rerr
+}
+
+// lookupPath returns the value at the dot separated path in the body of ref.
+func lookupPath(ref, path string, lookup func(ref string) (interface{}, error)) (interface{}, error) {
+	v, err := lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		if key == "" {
+			continue
+		}
+		switch c := v.(type) {
+		case map[string]interface{}:
+			e, ok := c[key]
+			if !ok {
+				return nil, model.ErrValidation{Message: fmt.Sprintf("@{%s%s} not found", ref, path)}
+			}
+			v = e
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, model.ErrValidation{Message: fmt.Sprintf("@{%s%s} not found", ref, path)}
+			}
+			v = c[i]
+		default:
+			return nil, model.ErrValidation{Message: fmt.Sprintf("@{%s%s} not found", ref, path)}
+		}
+	}
+
+	return v, nil
+}
+
+// decodeJSON decodes b keeping numbers as json.Number to preserve large ids.
+func decodeJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	var request model.BatchRequest
+	if err := decodeRequest(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	response, err := h.Batch(r.Context(), &request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if response.RolledBack {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
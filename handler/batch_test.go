@@ -0,0 +1,123 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestBatchReferences(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		request  string
+		statuses []int
+		// subject is the subject of the TODO in the body of the last result
+		subject    string
+		rolledBack bool
+		// count is the number of the TODOs after the batch
+		count int64
+		err   bool
+	}{
+		"Reference to an id and a subject": {
+			request: `{"operations":[
+{"ref":"a","method":"POST","body":{"subject":"first"}},
+{"method":"PUT","body":{"id":"@{a.todo.id}","subject":"updated @{a.todo.subject}"}}]}`,
+			statuses: []int{http.StatusOK, http.StatusOK},
+			subject:  "updated first",
+			count:    1,
+		},
+		"Reference to an earlier result in an array": {
+			request: `{"operations":[
+{"ref":"a","method":"POST","body":{"subject":"first"}},
+{"ref":"b","method":"POST","body":{"subject":"second"}},
+{"method":"DELETE","body":{"ids":["@{a.todo.id}","@{b.todo.id}"]}}]}`,
+			statuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			count:    0,
+		},
+		"Unknown reference": {
+			request: `{"operations":[
+{"method":"PUT","body":{"id":"@{a.todo.id}","subject":"x"}}]}`,
+			statuses: []int{http.StatusBadRequest},
+		},
+		"Reference to a failed operation": {
+			request: `{"operations":[
+{"ref":"a","method":"POST","body":{"subject":""}},
+{"method":"PUT","body":{"id":"@{a.todo.id}","subject":"x"}},
+{"method":"POST","body":{"subject":"independent"}}]}`,
+			statuses: []int{http.StatusBadRequest, http.StatusFailedDependency, http.StatusOK},
+			subject:  "independent",
+			count:    1,
+		},
+		"Atomic": {
+			request: `{"atomic":true,"operations":[
+{"ref":"a","method":"POST","body":{"subject":"first"}},
+{"method":"PUT","body":{"id":"@{a.todo.id}","subject":""}},
+{"method":"POST","body":{"subject":"skipped"}}]}`,
+			statuses:   []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusFailedDependency},
+			rolledBack: true,
+		},
+		"Duplicate ref": {
+			request: `{"operations":[
+{"ref":"a","method":"POST","body":{"subject":"first"}},
+{"ref":"a","method":"POST","body":{"subject":"second"}}]}`,
+			err: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var req model.BatchRequest
+			if err := json.Unmarshal([]byte(c.request), &req); err != nil {
+				t.Fatal("failed to decode request, err =", err)
+			}
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			response, err := handler.NewBatchHandler(svc).Batch(ctx, &req)
+			if c.err {
+				if err == nil {
+					t.Error("unexpected value, given = nil, expected = error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to run batch, err =", err)
+			}
+
+			if len(response.Results) != len(c.statuses) {
+				t.Fatalf("unexpected results, given = %d, expected = %d", len(response.Results), len(c.statuses))
+			}
+			for i, result := range response.Results {
+				if result.Status != c.statuses[i] {
+					t.Errorf("unexpected status of operation %d, given = %d, expected = %d", i, result.Status, c.statuses[i])
+				}
+			}
+			if response.RolledBack != c.rolledBack {
+				t.Errorf("unexpected rolled_back, given = %t, expected = %t", response.RolledBack, c.rolledBack)
+			}
+			if c.subject != "" {
+				body, _ := response.Results[len(response.Results)-1].Body.(map[string]interface{})
+				todo, _ := body["todo"].(map[string]interface{})
+				if todo["subject"] != c.subject {
+					t.Errorf("unexpected subject, given = %v, expected = %s", todo["subject"], c.subject)
+				}
+			}
+
+			todos, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if count := int64(len(todos)); count != c.count {
+				t.Errorf("unexpected count, given = %d, expected = %d", count, c.count)
+			}
+		})
+	}
+}
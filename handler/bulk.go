@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+var (
+	// errBulkFailed rolls back the transaction of an atomic bulk request.
+	errBulkFailed = errors.New("bulk request failed")
+
+	errBulkItemRequired = model.ErrValidation{Message: "todo is required"}
+)
+
+// A TODOBulkHandler implements handling bulk REST endpoints.
+type TODOBulkHandler struct {
+	todo *TODOHandler
+}
+
+// NewTODOBulkHandler returns TODOBulkHandler based http.Handler.
+func NewTODOBulkHandler(svc *service.TODOService) *TODOBulkHandler {
+	return &TODOBulkHandler{
+		todo: NewTODOHandler(svc),
+	}
+}
+
+// Create handles the endpoint that creates the TODOs in one transaction.
+func (h *TODOBulkHandler) Create(ctx context.Context, req *model.BulkCreateTODORequest) (*model.BulkTODOResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+	return h.run(ctx, req.Mode, len(req.TODOs), func(ctx context.Context, i int) (*model.TODO, error) {
+		if req.TODOs[i] == nil {
+			return nil, errBulkItemRequired
+		}
+		if err := model.Validate(req.TODOs[i]); err != nil {
+			return nil, err
+		}
+		res, err := h.todo.Create(ctx, req.TODOs[i])
+		if err != nil {
+			return nil, err
+		}
+		return res.TODO, nil
+	})
+}
+
+// Update handles the endpoint that updates the TODOs in one transaction.
+func (h *TODOBulkHandler) Update(ctx context.Context, req *model.BulkUpdateTODORequest) (*model.BulkTODOResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+	return h.run(ctx, req.Mode, len(req.TODOs), func(ctx context.Context, i int) (*model.TODO, error) {
+		if req.TODOs[i] == nil {
+			return nil, errBulkItemRequired
+		}
+		if err := model.Validate(req.TODOs[i]); err != nil {
+			return nil, err
+		}
+		res, err := h.todo.Update(ctx, req.TODOs[i])
+		if err != nil {
+			return nil, err
+		}
+		return res.TODO, nil
+	})
+}
+
+// run applies fn to every item in a savepoint of one transaction. In the atomic
+// mode the transaction is rolled back when any item fails, and the items that
+// succeeded are reported as failed dependencies.
+func (h *TODOBulkHandler) run(ctx context.Context, mode string, n int, fn func(ctx context.Context, i int) (*model.TODO, error)) (*model.BulkTODOResponse, error) {
+	if mode == "" {
+		mode = model.BulkModeAtomic
+	}
+
+	results := make([]*model.BulkTODOResult, n)
+	err := h.todo.svc.RunInTx(ctx, func(ctx context.Context) error {
+		failed := false
+		for i := 0; i < n; i++ {
+			result := &model.BulkTODOResult{Index: i, Status: http.StatusOK}
+			err := h.todo.svc.RunInSavepoint(ctx, func(ctx context.Context) error {
+				t, err := fn(ctx, i)
+				result.TODO = t
+				return err
+			})
+			if err != nil {
+				failed = true
+				result.Error = NewProblem(err)
+				result.Status = result.Error.Status
+			}
+			results[i] = result
+		}
+
+		if failed && mode == model.BulkModeAtomic {
+			return errBulkFailed
+		}
+		return nil
+	})
+
+	response := &model.BulkTODOResponse{Results: results}
+	switch err {
+	case nil:
+		break
+	case errBulkFailed:
+		response.RolledBack = true
+		for _, result := range results {
+			if result.Error == nil {
+				result.Status = http.StatusFailedDependency
+				result.TODO = nil
+			}
+		}
+	default:
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (h *TODOBulkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	var (
+		response *model.BulkTODOResponse
+		err      error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		var request model.BulkCreateTODORequest
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		response, err = h.Create(ctx, &request)
+
+	case http.MethodPut:
+		var request model.BulkUpdateTODORequest
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		response, err = h.Update(ctx, &request)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if response.RolledBack {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
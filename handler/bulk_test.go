@@ -0,0 +1,142 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestTODOBulk(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		method, body string
+		status       int
+		statuses     []int
+		rolledBack   bool
+		// subjects are the ones of the TODOs after the request, in the
+		// order of ids
+		subjects []string
+	}{
+		"Create": {
+			method:   http.MethodPost,
+			body:     `{"todos": [{"subject": "b"}, {"subject": "c"}]}`,
+			status:   http.StatusOK,
+			statuses: []int{http.StatusOK, http.StatusOK},
+			subjects: []string{"a", "b", "c"},
+		},
+		"Create rolled back": {
+			method:     http.MethodPost,
+			body:       `{"todos": [{"subject": "b"}, {"subject": ""}, {"subject": "c"}]}`,
+			status:     http.StatusUnprocessableEntity,
+			statuses:   []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusFailedDependency},
+			rolledBack: true,
+			subjects:   []string{"a"},
+		},
+		"Create partially": {
+			method:   http.MethodPost,
+			body:     `{"todos": [{"subject": "b"}, {"subject": ""}, {"subject": "c"}], "mode": "partial"}`,
+			status:   http.StatusOK,
+			statuses: []int{http.StatusOK, http.StatusBadRequest, http.StatusOK},
+			subjects: []string{"a", "b", "c"},
+		},
+		"Update": {
+			method:   http.MethodPut,
+			body:     `{"todos": [{"id": 1, "subject": "b"}]}`,
+			status:   http.StatusOK,
+			statuses: []int{http.StatusOK},
+			subjects: []string{"b"},
+		},
+		"Update rolled back": {
+			method:     http.MethodPut,
+			body:       `{"todos": [{"id": 1, "subject": "b"}, {"id": 99, "subject": "c"}], "mode": "atomic"}`,
+			status:     http.StatusUnprocessableEntity,
+			statuses:   []int{http.StatusFailedDependency, http.StatusNotFound},
+			rolledBack: true,
+			subjects:   []string{"a"},
+		},
+		"Update partially": {
+			method:   http.MethodPut,
+			body:     `{"todos": [{"id": 99, "subject": "c"}, {"id": 1, "subject": "b"}], "mode": "partial"}`,
+			status:   http.StatusOK,
+			statuses: []int{http.StatusNotFound, http.StatusOK},
+			subjects: []string{"b"},
+		},
+		"Null item": {
+			method:     http.MethodPost,
+			body:       `{"todos": [null]}`,
+			status:     http.StatusUnprocessableEntity,
+			statuses:   []int{http.StatusBadRequest},
+			rolledBack: true,
+			subjects:   []string{"a"},
+		},
+		"Method not allowed": {
+			method:   http.MethodGet,
+			status:   http.StatusMethodNotAllowed,
+			subjects: []string{"a"},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			w := httptest.NewRecorder()
+			handler.NewTODOBulkHandler(svc).ServeHTTP(w, httptest.NewRequest(c.method, "/todos/bulk", strings.NewReader(c.body)))
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+
+			if c.statuses != nil {
+				var response model.BulkTODOResponse
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatal("failed to decode response, err =", err)
+				}
+				if response.RolledBack != c.rolledBack {
+					t.Errorf("unexpected rolled_back, given = %t, expected = %t", response.RolledBack, c.rolledBack)
+				}
+				if len(response.Results) != len(c.statuses) {
+					t.Fatalf("unexpected results, given = %d, expected = %d", len(response.Results), len(c.statuses))
+				}
+				for i, result := range response.Results {
+					if result.Index != i || result.Status != c.statuses[i] {
+						t.Errorf("unexpected result, given = %d %d, expected = %d %d", result.Index, result.Status, i, c.statuses[i])
+					}
+					if (result.Status == http.StatusOK) != (result.TODO != nil) {
+						t.Errorf("unexpected todo of status %d, given = %v", result.Status, result.TODO)
+					}
+					if (result.Status == http.StatusOK || result.Status == http.StatusFailedDependency) != (result.Error == nil) {
+						t.Errorf("unexpected error of status %d, given = %v", result.Status, result.Error)
+					}
+				}
+			}
+
+			todos, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if len(todos) != len(c.subjects) {
+				t.Fatalf("unexpected todos, given = %d, expected = %d", len(todos), len(c.subjects))
+			}
+			for i, td := range todos {
+				// ReadTODO reads them in the descending order of ids
+				if want := c.subjects[len(todos)-1-i]; td.Subject != want {
+					t.Errorf("unexpected subject, given = %q, expected = %q", td.Subject, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,752 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// CalDAVRoot is the path of the principal and calendar home of CalDAV
+	// clients, and CalDAVCollection the calendar collection of the TODOs.
+	CalDAVRoot       = "/caldav/"
+	CalDAVCollection = CalDAVRoot + "todos/"
+
+	davNS            = "DAV:"
+	calDAVNS         = "urn:ietf:params:xml:ns:caldav"
+	calendarServerNS = "http://calendarserver.org/ns/"
+
+	// calDAVObjectContentType is the media type of the calendar objects.
+	calDAVObjectContentType = ICalendarContentType + "; charset=utf-8; component=vtodo"
+)
+
+// davPrefixes are the prefixes of the namespaces declared by multistatus
+// responses.
+var davPrefixes = map[string]string{
+	davNS:            "d",
+	calDAVNS:         "c",
+	calendarServerNS: "cs",
+}
+
+var calendarDataName = xml.Name{Space: calDAVNS, Local: "calendar-data"}
+
+// A CalDAVHandler implements the RFC 4791 CalDAV access to the TODOs as a
+// collection of VTODO calendar objects, which clients sync by the getctag of
+// the collection and the getetag of the objects.
+type CalDAVHandler struct {
+	svc *service.TODOService
+}
+
+// NewCalDAVHandler returns CalDAVHandler based http.Handler.
+func NewCalDAVHandler(svc *service.TODOService) *CalDAVHandler {
+	return &CalDAVHandler{
+		svc: svc,
+	}
+}
+
+// A davProp expresses a WebDAV property and its content in XML.
+type davProp struct {
+	name  xml.Name
+	inner string
+}
+
+// A davResource expresses a resource in a multistatus response. It is not
+// found when props is nil.
+type davResource struct {
+	href  string
+	props []davProp
+}
+
+// A davPropfind expresses the body of PROPFIND and the properties requested
+// by REPORT.
+type davPropfind struct {
+	AllProp  *struct{}    `xml:"DAV: allprop"`
+	PropName *struct{}    `xml:"DAV: propname"`
+	Prop     *davPropList `xml:"DAV: prop"`
+}
+
+type davPropList struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// A davReport expresses the body of the calendar-query and calendar-multiget
+// reports.
+type davReport struct {
+	XMLName xml.Name
+	davPropfind
+	Hrefs  []string       `xml:"DAV: href"`
+	Filter *davCompFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+}
+
+type davCompFilter struct {
+	Name         string          `xml:"name,attr"`
+	IsNotDefined *struct{}       `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *struct{}       `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	Filters      []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters  []davPropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+type davPropFilter struct {
+	Name         string        `xml:"name,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *struct{}     `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	TextMatch    *davTextMatch `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+	ParamFilters []struct{}    `xml:"urn:ietf:params:xml:ns:caldav param-filter"`
+}
+
+type davTextMatch struct {
+	Collation       string `xml:"collation,attr"`
+	NegateCondition string `xml:"negate-condition,attr"`
+	Text            string `xml:",chardata"`
+}
+
+// A davPreconditionError is the failure of a precondition of a DAV request,
+// which is answered by its element in DAV:error.
+type davPreconditionError struct {
+	name    xml.Name
+	message string
+}
+
+func (e davPreconditionError) Error() string {
+	return e.message
+}
+
+func (h *CalDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch path := r.URL.Path; {
+	case path == CalDAVRoot:
+		if r.Method != "PROPFIND" {
+			WriteError(w, r, model.ErrMethodNotAllowed{})
+			return
+		}
+		h.propfind(w, r, func(depth bool) ([]*davResource, error) {
+			resources := []*davResource{h.root()}
+			if depth {
+				collection, err := h.collection(ctx)
+				if err != nil {
+					return nil, err
+				}
+				resources = append(resources, collection)
+			}
+			return resources, nil
+		})
+
+	case path == CalDAVCollection:
+		switch r.Method {
+		case "PROPFIND":
+			h.propfind(w, r, func(depth bool) ([]*davResource, error) {
+				collection, err := h.collection(ctx)
+				if err != nil {
+					return nil, err
+				}
+				resources := []*davResource{collection}
+				if depth {
+					objects, err := h.objects(ctx)
+					if err != nil {
+						return nil, err
+					}
+					resources = append(resources, objects...)
+				}
+				return resources, nil
+			})
+		case "REPORT":
+			h.report(w, r)
+		default:
+			WriteError(w, r, model.ErrMethodNotAllowed{})
+		}
+
+	case strings.HasPrefix(path, CalDAVCollection) && !strings.Contains(path[len(CalDAVCollection):], "/"):
+		name := path[len(CalDAVCollection):]
+		switch r.Method {
+		case http.MethodGet:
+			h.get(w, r, name)
+		case http.MethodPut:
+			h.put(w, r, name)
+		case http.MethodDelete:
+			h.delete(w, r, name)
+		case "PROPFIND":
+			h.propfind(w, r, func(bool) ([]*davResource, error) {
+				obj, err := h.svc.GetCalDAVObject(ctx, name)
+				if err != nil {
+					return nil, err
+				}
+				return []*davResource{calDAVObjectResource(obj)}, nil
+			})
+		default:
+			WriteError(w, r, model.ErrMethodNotAllowed{})
+		}
+
+	default:
+		WriteError(w, r, model.ErrNotFound{})
+	}
+}
+
+// propfind writes the properties requested by r of the resources, which
+// include the members of a collection when depth is true.
+func (h *CalDAVHandler) propfind(w http.ResponseWriter, r *http.Request, resources func(depth bool) ([]*davResource, error)) {
+	var request davPropfind
+	if err := decodeDAVRequest(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	res, err := resources(r.Header.Get("Depth") != "0")
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeMultistatus(w, &request, res)
+}
+
+// report writes the objects matching a calendar-query, or the ones listed by
+// a calendar-multiget.
+func (h *CalDAVHandler) report(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var request davReport
+	if err := decodeDAVRequest(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	var resources []*davResource
+	switch request.XMLName {
+	case xml.Name{Space: calDAVNS, Local: "calendar-query"}:
+		match, err := newVTODOFilter(request.Filter)
+		if err != nil {
+			writeDAVError(w, r, err)
+			return
+		}
+		objects, err := h.svc.ReadCalDAVObjects(ctx)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		for _, obj := range objects {
+			if match(obj) {
+				resources = append(resources, calDAVObjectResource(obj))
+			}
+		}
+
+	case xml.Name{Space: calDAVNS, Local: "calendar-multiget"}:
+		for _, href := range request.Hrefs {
+			resource := &davResource{href: href}
+			if name, ok := calDAVObjectName(href); ok {
+				obj, err := h.svc.GetCalDAVObject(ctx, name)
+				switch {
+				case err == nil:
+					resource = calDAVObjectResource(obj)
+				case !errors.As(err, &model.ErrNotFound{}):
+					WriteError(w, r, err)
+					return
+				}
+			}
+			resources = append(resources, resource)
+		}
+
+	default:
+		WriteError(w, r, model.ErrValidation{Message: fmt.Sprintf("REPORT %s is not supported", request.XMLName.Local)})
+		return
+	}
+
+	writeMultistatus(w, &request.davPropfind, resources)
+}
+
+func (h *CalDAVHandler) get(w http.ResponseWriter, r *http.Request, name string) {
+	obj, err := h.svc.GetCalDAVObject(r.Context(), name)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if checkNotModified(w, r, todoETag(obj.TODO.ID, obj.Version), obj.TODO.UpdatedAt) {
+		return
+	}
+	w.Header().Set("Content-Type", calDAVObjectContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(encodeCalDAVObject(obj)); err != nil {
+		log.Println(err)
+	}
+}
+
+// put stores the VTODO of the calendar object in the request body. Only its
+// SUMMARY and DESCRIPTION are kept, so the response has no ETag and clients
+// read the object back.
+func (h *CalDAVHandler) put(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != ICalendarContentType {
+			WriteError(w, r, model.ErrUnsupportedMediaType{})
+			return
+		}
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, r, model.ErrValidation{Message: err.Error()})
+		return
+	}
+	todos, err := parseVTODOs(data)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if len(todos) == 0 {
+		WriteError(w, r, model.ErrValidation{Message: "calendar object must have a VTODO"})
+		return
+	}
+	t, reason := newImportedTODO(todos[0])
+	if reason != "" {
+		WriteError(w, r, model.ErrValidation{Message: reason})
+		return
+	}
+	uid := iCalendarUnescaper.Replace(todos[0]["UID"].value)
+	if uid == "" {
+		WriteError(w, r, model.ErrValidation{Message: "UID is required"})
+		return
+	}
+
+	existing, err := h.getExisting(ctx, name)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if existing != nil && existing.UID != uid {
+		WriteError(w, r, model.ErrConflict{Message: "UID of the resource cannot be changed"})
+		return
+	}
+	ifMatch, err := calDAVIfMatch(r.Header.Get("If-Match"), existing)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	ifNoneMatch := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if _, wildcard := parseETags(inm); wildcard {
+			ifNoneMatch = true
+		} else if existing != nil && noneMatch(inm, todoETag(existing.TODO.ID, existing.Version)) {
+			WriteError(w, r, model.ErrPreconditionFailed{})
+			return
+		}
+	}
+
+	_, created, err := h.svc.PutCalDAVObject(ctx, name, uid, t.Subject, t.Description, ifMatch, ifNoneMatch)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *CalDAVHandler) delete(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+
+	existing, err := h.getExisting(ctx, name)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	ifMatch, err := calDAVIfMatch(r.Header.Get("If-Match"), existing)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if err := h.svc.DeleteCalDAVObject(ctx, name, ifMatch); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getExisting returns the object named name, or nil when it does not exist.
+func (h *CalDAVHandler) getExisting(ctx context.Context, name string) (*model.CalDAVObject, error) {
+	obj, err := h.svc.GetCalDAVObject(ctx, name)
+	if errors.As(err, &model.ErrNotFound{}) {
+		return nil, nil
+	}
+	return obj, err
+}
+
+// calDAVIfMatch returns the version of existing required by If-Match, nil
+// when the header is not set.
+func calDAVIfMatch(header string, existing *model.CalDAVObject) (*int64, error) {
+	if header == "" {
+		return nil, nil
+	}
+	if existing == nil {
+		return nil, model.ErrPreconditionFailed{}
+	}
+	version := existing.Version
+	versions, wildcard := ifMatchVersions(header)
+	if !wildcard {
+		v, ok := versions[existing.TODO.ID]
+		if !ok {
+			return nil, model.ErrPreconditionFailed{}
+		}
+		version = v
+	}
+	return &version, nil
+}
+
+func (h *CalDAVHandler) root() *davResource {
+	return &davResource{
+		href: CalDAVRoot,
+		props: []davProp{
+			{name: xml.Name{Space: davNS, Local: "resourcetype"}, inner: "<d:collection/><d:principal/>"},
+			{name: xml.Name{Space: davNS, Local: "displayname"}, inner: "TODO"},
+			{name: xml.Name{Space: davNS, Local: "current-user-principal"}, inner: davHref(CalDAVRoot)},
+			{name: xml.Name{Space: davNS, Local: "principal-URL"}, inner: davHref(CalDAVRoot)},
+			{name: xml.Name{Space: calDAVNS, Local: "calendar-home-set"}, inner: davHref(CalDAVRoot)},
+		},
+	}
+}
+
+// collection returns the calendar collection, whose getctag is the id of the
+// last event of the TODOs.
+func (h *CalDAVHandler) collection(ctx context.Context) (*davResource, error) {
+	_, last, err := h.svc.EventLogBounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var privileges strings.Builder
+	for _, p := range []string{"read", "write", "write-content", "bind", "unbind", "read-current-user-privilege-set"} {
+		fmt.Fprintf(&privileges, "<d:privilege><d:%s/></d:privilege>", p)
+	}
+	return &davResource{
+		href: CalDAVCollection,
+		props: []davProp{
+			{name: xml.Name{Space: davNS, Local: "resourcetype"}, inner: "<d:collection/><c:calendar/>"},
+			{name: xml.Name{Space: davNS, Local: "displayname"}, inner: "TODOs"},
+			{name: xml.Name{Space: davNS, Local: "current-user-principal"}, inner: davHref(CalDAVRoot)},
+			{name: xml.Name{Space: davNS, Local: "current-user-privilege-set"}, inner: privileges.String()},
+			{name: xml.Name{Space: davNS, Local: "supported-report-set"}, inner: "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>"},
+			{name: xml.Name{Space: calDAVNS, Local: "supported-calendar-component-set"}, inner: `<c:comp name="VTODO"/>`},
+			{name: xml.Name{Space: calDAVNS, Local: "supported-calendar-data"}, inner: `<c:calendar-data content-type="text/calendar" version="2.0"/>`},
+			{name: xml.Name{Space: calendarServerNS, Local: "getctag"}, inner: strconv.FormatInt(last, 10)},
+		},
+	}, nil
+}
+
+func (h *CalDAVHandler) objects(ctx context.Context) ([]*davResource, error) {
+	objects, err := h.svc.ReadCalDAVObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]*davResource, len(objects))
+	for i, obj := range objects {
+		resources[i] = calDAVObjectResource(obj)
+	}
+	return resources, nil
+}
+
+func calDAVObjectResource(obj *model.CalDAVObject) *davResource {
+	data := encodeCalDAVObject(obj)
+	return &davResource{
+		href: CalDAVCollection + url.PathEscape(obj.Name),
+		props: []davProp{
+			{name: xml.Name{Space: davNS, Local: "resourcetype"}},
+			{name: xml.Name{Space: davNS, Local: "getetag"}, inner: xmlText(todoETag(obj.TODO.ID, obj.Version))},
+			{name: xml.Name{Space: davNS, Local: "getcontenttype"}, inner: calDAVObjectContentType},
+			{name: xml.Name{Space: davNS, Local: "getcontentlength"}, inner: strconv.Itoa(len(data))},
+			{name: xml.Name{Space: davNS, Local: "getlastmodified"}, inner: obj.TODO.UpdatedAt.UTC().Format(http.TimeFormat)},
+			{name: calendarDataName, inner: xmlText(string(data))},
+		},
+	}
+}
+
+// calDAVObjectName returns the name of the object of href in the collection.
+func calDAVObjectName(href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || !strings.HasPrefix(u.Path, CalDAVCollection) {
+		return "", false
+	}
+	name := u.Path[len(CalDAVCollection):]
+	return name, name != "" && !strings.Contains(name, "/")
+}
+
+// newVTODOFilter returns the filter of a calendar-query on the objects, which
+// are all VCALENDARs of a VTODO. The prop-filters of the VTODO are evaluated
+// on the properties of the objects, and the filters which cannot be evaluated,
+// such as time-range, fail with CALDAV:supported-filter.
+func newVTODOFilter(f *davCompFilter) (func(obj *model.CalDAVObject) bool, error) {
+	none := func(*model.CalDAVObject) bool { return false }
+	if f == nil {
+		return func(*model.CalDAVObject) bool { return true }, nil
+	}
+	if err := f.checkSupported(0); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(f.Name, "VCALENDAR") || f.IsNotDefined != nil {
+		return none, nil
+	}
+
+	var props []davPropFilter
+	for _, sub := range f.Filters {
+		switch {
+		case !strings.EqualFold(sub.Name, "VTODO"):
+			// the objects have no other components
+			if sub.IsNotDefined == nil {
+				return none, nil
+			}
+		case sub.IsNotDefined != nil:
+			return none, nil
+		default:
+			props = append(props, sub.PropFilters...)
+		}
+	}
+
+	return func(obj *model.CalDAVObject) bool {
+		todos, err := parseVTODOs(encodeCalDAVObject(obj))
+		if err != nil || len(todos) == 0 {
+			return false
+		}
+		for _, pf := range props {
+			if !pf.match(todos[0]) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// checkSupported returns the error of the filters in the comp-filter at the
+// depth, 0 being VCALENDAR, which cannot be evaluated.
+func (f *davCompFilter) checkSupported(depth int) error {
+	unsupported := func(message string) error {
+		return davPreconditionError{name: xml.Name{Space: calDAVNS, Local: "supported-filter"}, message: message}
+	}
+	switch {
+	case f.TimeRange != nil:
+		return unsupported("time-range is not supported")
+	case depth == 0 && len(f.PropFilters) > 0:
+		return unsupported("prop-filter of VCALENDAR is not supported")
+	case depth > 0 && len(f.Filters) > 0:
+		return unsupported("comp-filter of the components in " + f.Name + " is not supported")
+	}
+	for _, pf := range f.PropFilters {
+		switch {
+		case pf.TimeRange != nil:
+			return unsupported("time-range is not supported")
+		case len(pf.ParamFilters) > 0:
+			return unsupported("param-filter is not supported")
+		case pf.TextMatch != nil && !supportedCollation(pf.TextMatch.Collation):
+			return davPreconditionError{
+				name:    xml.Name{Space: calDAVNS, Local: "supported-collation"},
+				message: fmt.Sprintf("collation %q is not supported", pf.TextMatch.Collation),
+			}
+		}
+	}
+	for i := range f.Filters {
+		if err := f.Filters[i].checkSupported(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match reports whether the properties of a component match the prop-filter.
+// The properties not defined match no text-match.
+func (pf *davPropFilter) match(props map[string]iCalendarProperty) bool {
+	prop, ok := props[strings.ToUpper(pf.Name)]
+	switch {
+	case pf.IsNotDefined != nil:
+		return !ok
+	case !ok:
+		return false
+	case pf.TextMatch == nil:
+		return true
+	}
+
+	value, text := iCalendarUnescaper.Replace(prop.value), pf.TextMatch.Text
+	if pf.TextMatch.Collation != "i;octet" {
+		value, text = asciiLower(value), asciiLower(text)
+	}
+	return strings.Contains(value, text) != (pf.TextMatch.NegateCondition == "yes")
+}
+
+// supportedCollation reports whether the collation of text-match is supported.
+// The default is i;ascii-casemap.
+func supportedCollation(collation string) bool {
+	return collation == "" || collation == "i;ascii-casemap" || collation == "i;octet"
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+func encodeCalDAVObject(obj *model.CalDAVObject) []byte {
+	return encodeICalendar(func(b *bytes.Buffer) {
+		writeVTODO(b, obj.TODO, obj.Version, obj.UID)
+	})
+}
+
+// decodeDAVRequest decodes the XML request body into v. An empty body is an
+// allprop request.
+func decodeDAVRequest(r *http.Request, v interface{}) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return model.ErrValidation{Message: err.Error()}
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		if pf, ok := v.(*davPropfind); ok {
+			pf.AllProp = &struct{}{}
+		}
+		return nil
+	}
+	if err := xml.Unmarshal(b, v); err != nil {
+		return model.ErrValidation{Message: "request body is not valid XML: " + err.Error()}
+	}
+	return nil
+}
+
+// writeMultistatus writes the properties of the resources requested by pf as
+// a 207 Multi-Status response. The properties requested which a resource does
+// not have are reported as not found, and calendar-data is only written when
+// it is requested by name.
+func writeMultistatus(w http.ResponseWriter, pf *davPropfind, resources []*davResource) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<d:multistatus xmlns:d="%s" xmlns:c="%s" xmlns:cs="%s">`, davNS, calDAVNS, calendarServerNS)
+	for _, res := range resources {
+		b.WriteString("<d:response>")
+		writeDAVElement(&b, xml.Name{Space: davNS, Local: "href"}, xmlText(res.href))
+		if res.props == nil {
+			writeDAVElement(&b, xml.Name{Space: davNS, Local: "status"}, "HTTP/1.1 404 Not Found")
+			b.WriteString("</d:response>")
+			continue
+		}
+
+		var found, missing []davProp
+		switch {
+		case pf.Prop != nil:
+			for _, n := range pf.Prop.Names {
+				prop, ok := findDAVProp(res.props, n.XMLName)
+				if ok {
+					found = append(found, prop)
+				} else {
+					missing = append(missing, davProp{name: n.XMLName})
+				}
+			}
+		case pf.PropName != nil:
+			for _, prop := range res.props {
+				found = append(found, davProp{name: prop.name})
+			}
+		default:
+			for _, prop := range res.props {
+				if prop.name != calendarDataName {
+					found = append(found, prop)
+				}
+			}
+		}
+		writeDAVPropstat(&b, found, "HTTP/1.1 200 OK")
+		writeDAVPropstat(&b, missing, "HTTP/1.1 404 Not Found")
+		b.WriteString("</d:response>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeDAVError writes the DAV:error of the failed precondition as a 403
+// Forbidden response, or the problem of the other errors.
+func writeDAVError(w http.ResponseWriter, r *http.Request, err error) {
+	var precondition davPreconditionError
+	if !errors.As(err, &precondition) {
+		WriteError(w, r, err)
+		return
+	}
+	log.Println(err)
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<d:error xmlns:d="%s" xmlns:c="%s">`, davNS, calDAVNS)
+	writeDAVElement(&b, precondition.name, "")
+	b.WriteString("</d:error>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
+
+func findDAVProp(props []davProp, name xml.Name) (davProp, bool) {
+	for _, prop := range props {
+		if prop.name == name {
+			return prop, true
+		}
+	}
+	return davProp{}, false
+}
+
+func writeDAVPropstat(b *bytes.Buffer, props []davProp, status string) {
+	if len(props) == 0 {
+		return
+	}
+	b.WriteString("<d:propstat><d:prop>")
+	for _, prop := range props {
+		writeDAVElement(b, prop.name, prop.inner)
+	}
+	b.WriteString("</d:prop>")
+	writeDAVElement(b, xml.Name{Space: davNS, Local: "status"}, status)
+	b.WriteString("</d:propstat>")
+}
+
+// writeDAVElement writes the element of name with the content inner. The
+// namespaces other than the ones of davPrefixes are declared on it.
+func writeDAVElement(b *bytes.Buffer, name xml.Name, inner string) {
+	tag, attr := name.Local, ""
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, attr = "x:"+name.Local, ` xmlns:x="`+xmlText(name.Space)+`"`
+	}
+	if inner == "" {
+		fmt.Fprintf(b, "<%s%s/>", tag, attr)
+		return
+	}
+	fmt.Fprintf(b, "<%s%s>%s</%s>", tag, attr, inner, tag)
+}
+
+func davHref(href string) string {
+	return "<d:href>" + xmlText(href) + "</d:href>"
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		log.Println(err)
+	}
+	return b.String()
+}
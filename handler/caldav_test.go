@@ -0,0 +1,649 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+)
+
+// A davMultistatus expresses a multistatus response of the props of which the
+// content is kept as XML.
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Status    string `xml:"DAV: status"`
+		Propstats []struct {
+			Prop struct {
+				Any []struct {
+					XMLName xml.Name
+					Inner   string `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// props returns the content of the props found for each href, and the names
+// of the props not found keyed by "<href> missing".
+func (ms *davMultistatus) props() map[string]map[string]string {
+	props := make(map[string]map[string]string)
+	for _, res := range ms.Responses {
+		found := make(map[string]string)
+		for _, ps := range res.Propstats {
+			for _, p := range ps.Prop.Any {
+				if strings.Contains(ps.Status, "200") {
+					found[p.XMLName.Local] = p.Inner
+				} else {
+					found[p.XMLName.Local+" missing"] = ""
+				}
+			}
+		}
+		props[res.Href] = found
+	}
+	return props
+}
+
+func TestCalDAVPropfind(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		path   string
+		depth  string
+		body   string
+		status int
+		hrefs  []string
+		// props are the names of the props of every resource, with
+		// " missing" for the ones not found
+		props []string
+	}{
+		"Root of depth 0": {
+			path:   "/caldav/",
+			depth:  "0",
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/"},
+			props:  []string{"calendar-home-set", "current-user-principal", "displayname", "principal-URL", "resourcetype"},
+		},
+		"Root of depth 1": {
+			path:   "/caldav/",
+			depth:  "1",
+			body:   `<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/", "/caldav/todos/"},
+			props:  []string{"resourcetype"},
+		},
+		"Collection of depth 0": {
+			path:   "/caldav/todos/",
+			depth:  "0",
+			body:   `<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:prop><cs:getctag/><d:getetag/></d:prop></d:propfind>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/"},
+			props:  []string{"getctag", "getetag missing"},
+		},
+		"Collection of depth 1": {
+			path:   "/caldav/todos/",
+			depth:  "1",
+			body:   `<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/", "/caldav/todos/todo-1.ics", "/caldav/todos/todo-2.ics"},
+		},
+		"Object": {
+			path:   "/caldav/todos/todo-1.ics",
+			depth:  "0",
+			body:   `<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/><c:calendar-data/></d:prop></d:propfind>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics"},
+			props:  []string{"calendar-data", "getetag"},
+		},
+		"Prop names": {
+			path:   "/caldav/todos/todo-1.ics",
+			depth:  "0",
+			body:   `<d:propfind xmlns:d="DAV:"><d:propname/></d:propfind>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics"},
+			props:  []string{"calendar-data", "getcontentlength", "getcontenttype", "getetag", "getlastmodified", "resourcetype"},
+		},
+		"Missing object": {
+			path:   "/caldav/todos/todo-3.ics",
+			depth:  "0",
+			status: http.StatusNotFound,
+		},
+		"Invalid XML": {
+			path:   "/caldav/todos/",
+			depth:  "0",
+			body:   `<d:propfind xmlns:d="DAV:">`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestCalDAVHandler(t)
+			w := serveDAV(h, "PROPFIND", c.path, c.body, map[string]string{"Depth": c.depth})
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.status != http.StatusMultiStatus {
+				return
+			}
+
+			props := decodeMultistatus(t, w).props()
+			var hrefs []string
+			for href := range props {
+				hrefs = append(hrefs, href)
+			}
+			sort.Strings(hrefs)
+			if strings.Join(hrefs, ",") != strings.Join(c.hrefs, ",") {
+				t.Fatalf("unexpected hrefs, given = %v, expected = %v", hrefs, c.hrefs)
+			}
+			if c.props == nil {
+				return
+			}
+			for href, found := range props {
+				var names []string
+				for name := range found {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				if strings.Join(names, ",") != strings.Join(c.props, ",") {
+					t.Errorf("unexpected props of %s, given = %v, expected = %v", href, names, c.props)
+				}
+			}
+		})
+	}
+}
+
+func TestCalDAVReport(t *testing.T) {
+	t.Parallel()
+
+	const (
+		queryFmt = `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+			`<d:prop><d:getetag/></d:prop><c:filter>%s</c:filter></c:calendar-query>`
+		vtodoFmt = `<c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO">%s</c:comp-filter></c:comp-filter>`
+	)
+	query := func(filter string) string {
+		return strings.Replace(queryFmt, "%s", filter, 1)
+	}
+	vtodo := func(filter string) string {
+		return query(strings.Replace(vtodoFmt, "%s", filter, 1))
+	}
+
+	cases := map[string]struct {
+		body   string
+		status int
+		// hrefs are the hrefs of the objects found, and the ones not found
+		// with " missing"
+		hrefs []string
+		// precondition is the element in DAV:error
+		precondition string
+	}{
+		"Query without filter": {
+			body:   `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop></c:calendar-query>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics", "/caldav/todos/todo-2.ics"},
+		},
+		"Query of VTODO": {
+			body:   vtodo(""),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics", "/caldav/todos/todo-2.ics"},
+		},
+		"Query of VCALENDAR": {
+			body:   query(`<c:comp-filter name="VCALENDAR"/>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics", "/caldav/todos/todo-2.ics"},
+		},
+		"Query of VEVENT": {
+			body:   query(`<c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT"/></c:comp-filter>`),
+			status: http.StatusMultiStatus,
+		},
+		"Query of VTODO without VEVENT": {
+			body:   query(`<c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO"/><c:comp-filter name="VEVENT"><c:is-not-defined/></c:comp-filter></c:comp-filter>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics", "/caldav/todos/todo-2.ics"},
+		},
+		"Query of text": {
+			body:   vtodo(`<c:prop-filter name="SUMMARY"><c:text-match>ALPHA</c:text-match></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics"},
+		},
+		"Query of negated text": {
+			body:   vtodo(`<c:prop-filter name="summary"><c:text-match negate-condition="yes">alpha</c:text-match></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-2.ics"},
+		},
+		"Query of octets": {
+			body:   vtodo(`<c:prop-filter name="SUMMARY"><c:text-match collation="i;octet">ALPHA</c:text-match></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+		},
+		"Query of escaped text": {
+			body:   vtodo(`<c:prop-filter name="DESCRIPTION"><c:text-match>first, second</c:text-match></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics"},
+		},
+		"Query of a property defined": {
+			body:   vtodo(`<c:prop-filter name="DESCRIPTION"/>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-1.ics"},
+		},
+		"Query of a property not defined": {
+			body:   vtodo(`<c:prop-filter name="COMPLETED"><c:is-not-defined/></c:prop-filter><c:prop-filter name="DESCRIPTION"><c:is-not-defined/></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-2.ics"},
+		},
+		"Query of text of a property not defined": {
+			body:   vtodo(`<c:prop-filter name="DESCRIPTION"><c:text-match negate-condition="yes">first</c:text-match></c:prop-filter>`),
+			status: http.StatusMultiStatus,
+		},
+		"Query of time range": {
+			body:         vtodo(`<c:time-range start="20210101T000000Z"/>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-filter",
+		},
+		"Query of time range of a property": {
+			body:         vtodo(`<c:prop-filter name="DUE"><c:time-range start="20210101T000000Z"/></c:prop-filter>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-filter",
+		},
+		"Query of parameters": {
+			body:         vtodo(`<c:prop-filter name="SUMMARY"><c:param-filter name="LANGUAGE"><c:is-not-defined/></c:param-filter></c:prop-filter>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-filter",
+		},
+		"Query of properties of VCALENDAR": {
+			body:         query(`<c:comp-filter name="VCALENDAR"><c:prop-filter name="VERSION"/></c:comp-filter>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-filter",
+		},
+		"Query of components in VTODO": {
+			body:         vtodo(`<c:comp-filter name="VALARM"/>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-filter",
+		},
+		"Query of unknown collation": {
+			body:         vtodo(`<c:prop-filter name="SUMMARY"><c:text-match collation="i;unicode-casemap">alpha</c:text-match></c:prop-filter>`),
+			status:       http.StatusForbidden,
+			precondition: "supported-collation",
+		},
+		"Multiget": {
+			body: `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop>` +
+				`<d:href>/caldav/todos/todo-2.ics</d:href><d:href>/caldav/todos/todo-3.ics</d:href><d:href>/todos</d:href></c:calendar-multiget>`,
+			status: http.StatusMultiStatus,
+			hrefs:  []string{"/caldav/todos/todo-2.ics", "/caldav/todos/todo-3.ics missing", "/todos missing"},
+		},
+		"Unknown report": {
+			body:   `<c:free-busy-query xmlns:c="urn:ietf:params:xml:ns:caldav"/>`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestCalDAVHandler(t)
+			w := serveDAV(h, "REPORT", "/caldav/todos/", c.body, map[string]string{"Depth": "1"})
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+
+			switch c.status {
+			case http.StatusForbidden:
+				var davError struct {
+					XMLName xml.Name `xml:"DAV: error"`
+					Any     []struct {
+						XMLName xml.Name
+					} `xml:",any"`
+				}
+				if err := xml.Unmarshal(w.Body.Bytes(), &davError); err != nil {
+					t.Fatal("failed to decode error, err =", err)
+				}
+				expected := xml.Name{Space: "urn:ietf:params:xml:ns:caldav", Local: c.precondition}
+				if len(davError.Any) != 1 || davError.Any[0].XMLName != expected {
+					t.Errorf("unexpected error, given = %s, expected = %v", w.Body, expected)
+				}
+
+			case http.StatusMultiStatus:
+				var hrefs []string
+				for _, res := range decodeMultistatus(t, w).Responses {
+					href := res.Href
+					if strings.Contains(res.Status, "404") {
+						href += " missing"
+					}
+					hrefs = append(hrefs, href)
+				}
+				if strings.Join(hrefs, ",") != strings.Join(c.hrefs, ",") {
+					t.Errorf("unexpected hrefs, given = %v, expected = %v", hrefs, c.hrefs)
+				}
+			}
+		})
+	}
+}
+
+func TestCalDAVPut(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		path    string
+		headers func(current, stale string) map[string]string
+		body    string
+		status  int
+		// summary is the SUMMARY of the object after the request
+		summary string
+	}{
+		"Create": {
+			path:    "/caldav/todos/new.ics",
+			body:    newTestVTODO("new", "created"),
+			status:  http.StatusCreated,
+			summary: "created",
+		},
+		"Create with If-None-Match": {
+			path:    "/caldav/todos/new.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-None-Match": "*"} },
+			body:    newTestVTODO("new", "created"),
+			status:  http.StatusCreated,
+			summary: "created",
+		},
+		"Create with If-Match": {
+			path:    "/caldav/todos/new.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-Match": "*"} },
+			body:    newTestVTODO("new", "created"),
+			status:  http.StatusPreconditionFailed,
+		},
+		"Update": {
+			path:    "/caldav/todos/a.ics",
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusNoContent,
+			summary: "updated",
+		},
+		"Update with If-Match": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-Match": current} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusNoContent,
+			summary: "updated",
+		},
+		"Update with If-Match of any": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-Match": "*"} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusNoContent,
+			summary: "updated",
+		},
+		"Update with a stale If-Match": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-Match": stale} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusPreconditionFailed,
+			summary: "a2",
+		},
+		"Update with If-None-Match": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-None-Match": "*"} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusPreconditionFailed,
+			summary: "a2",
+		},
+		"Update with If-None-Match of the current": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-None-Match": current} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusPreconditionFailed,
+			summary: "a2",
+		},
+		"Update with If-None-Match of a stale": {
+			path:    "/caldav/todos/a.ics",
+			headers: func(current, stale string) map[string]string { return map[string]string{"If-None-Match": stale} },
+			body:    newTestVTODO("a", "updated"),
+			status:  http.StatusNoContent,
+			summary: "updated",
+		},
+		"Update of UID": {
+			path:    "/caldav/todos/a.ics",
+			body:    newTestVTODO("b", "updated"),
+			status:  http.StatusConflict,
+			summary: "a2",
+		},
+		"Without VTODO": {
+			path:   "/caldav/todos/new.ics",
+			body:   "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n",
+			status: http.StatusBadRequest,
+		},
+		"Without UID": {
+			path:   "/caldav/todos/new.ics",
+			body:   newTestVTODO("", "created"),
+			status: http.StatusBadRequest,
+		},
+		"Not iCalendar": {
+			path: "/caldav/todos/new.ics",
+			headers: func(current, stale string) map[string]string {
+				return map[string]string{"Content-Type": "application/json"}
+			},
+			body:   `{"subject": "created"}`,
+			status: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestCalDAVHandler(t)
+			stale, current := putTestVTODO(t, h)
+
+			headers := map[string]string{"Content-Type": "text/calendar; charset=utf-8"}
+			if c.headers != nil {
+				for k, v := range c.headers(current, stale) {
+					headers[k] = v
+				}
+			}
+			w := serveDAV(h, http.MethodPut, c.path, c.body, headers)
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+
+			w = serveDAV(h, http.MethodGet, c.path, "", nil)
+			if c.summary == "" {
+				if w.Code != http.StatusNotFound {
+					t.Errorf("unexpected status, given = %d, expected = %d", w.Code, http.StatusNotFound)
+				}
+				return
+			}
+			if !strings.Contains(w.Body.String(), "SUMMARY:"+c.summary+"\r\n") {
+				t.Errorf("unexpected object, given = %s, expected SUMMARY:%s", w.Body, c.summary)
+			}
+			if etag := w.Header().Get("ETag"); c.summary != "a2" && (etag == current || etag == stale) {
+				t.Errorf("unexpected etag, given = %s, expected a new one", etag)
+			}
+		})
+	}
+}
+
+func TestCalDAVDelete(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		path    string
+		ifMatch func(current, stale string) string
+		status  int
+	}{
+		"Delete": {
+			path:   "/caldav/todos/a.ics",
+			status: http.StatusNoContent,
+		},
+		"Delete with If-Match": {
+			path:    "/caldav/todos/a.ics",
+			ifMatch: func(current, stale string) string { return current },
+			status:  http.StatusNoContent,
+		},
+		"Delete with a stale If-Match": {
+			path:    "/caldav/todos/a.ics",
+			ifMatch: func(current, stale string) string { return stale },
+			status:  http.StatusPreconditionFailed,
+		},
+		"Delete missing": {
+			path:   "/caldav/todos/b.ics",
+			status: http.StatusNotFound,
+		},
+		"Delete missing with If-Match": {
+			path:    "/caldav/todos/b.ics",
+			ifMatch: func(current, stale string) string { return "*" },
+			status:  http.StatusPreconditionFailed,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestCalDAVHandler(t)
+			stale, current := putTestVTODO(t, h)
+
+			var headers map[string]string
+			if c.ifMatch != nil {
+				headers = map[string]string{"If-Match": c.ifMatch(current, stale)}
+			}
+			w := serveDAV(h, http.MethodDelete, c.path, "", headers)
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+
+			expected := http.StatusOK
+			if c.status == http.StatusNoContent {
+				expected = http.StatusNotFound
+			}
+			if w := serveDAV(h, http.MethodGet, "/caldav/todos/a.ics", "", nil); w.Code != expected {
+				t.Errorf("unexpected status, given = %d, expected = %d", w.Code, expected)
+			}
+		})
+	}
+}
+
+func TestCalDAVTags(t *testing.T) {
+	t.Parallel()
+
+	h := newTestCalDAVHandler(t)
+	const body = `<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:prop><cs:getctag/><d:getetag/></d:prop></d:propfind>`
+	tags := func() (string, map[string]string) {
+		w := serveDAV(h, "PROPFIND", "/caldav/todos/", body, map[string]string{"Depth": "1"})
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("unexpected status, given = %d, expected = %d", w.Code, http.StatusMultiStatus)
+		}
+		props := decodeMultistatus(t, w).props()
+		etags := make(map[string]string)
+		for href, p := range props {
+			if href != "/caldav/todos/" {
+				etags[href] = p["getetag"]
+			}
+		}
+		return props["/caldav/todos/"]["getctag"], etags
+	}
+
+	ctag, etags := tags()
+	writes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodPut, path: "/caldav/todos/a.ics", body: newTestVTODO("a", "a")},
+		{method: http.MethodPut, path: "/caldav/todos/todo-1.ics", body: newTestVTODO("todo-1@go-stations", "alpha2")},
+		{method: http.MethodDelete, path: "/caldav/todos/todo-2.ics"},
+	}
+	for _, write := range writes {
+		headers := map[string]string{"Content-Type": "text/calendar"}
+		if w := serveDAV(h, write.method, write.path, write.body, headers); w.Code >= 300 {
+			t.Fatalf("unexpected status of %s %s, given = %d, body = %s", write.method, write.path, w.Code, w.Body)
+		}
+
+		next, nextETags := tags()
+		if next == ctag {
+			t.Errorf("unexpected getctag after %s %s, given = %s, expected a new one", write.method, write.path, next)
+		}
+		for href, etag := range nextETags {
+			if old, ok := etags[href]; ok && (old == etag) == (href == write.path) {
+				t.Errorf("unexpected getetag of %s after %s %s, given = %s, old = %s", href, write.method, write.path, etag, old)
+			}
+		}
+		if _, ok := nextETags["/caldav/todos/todo-2.ics"]; ok == (write.method == http.MethodDelete) {
+			t.Errorf("unexpected objects after %s %s, given = %v", write.method, write.path, nextETags)
+		}
+		ctag, etags = next, nextETags
+	}
+}
+
+// newTestCalDAVHandler returns the CalDAVHandler of the TODOs "alpha" and
+// "beta", named todo-1.ics and todo-2.ics.
+func newTestCalDAVHandler(t *testing.T) *handler.CalDAVHandler {
+	t.Helper()
+
+	ctx := context.Background()
+	svc := newTestService(t)
+	if _, err := svc.CreateTODO(ctx, "alpha", "first, second"); err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	if _, err := svc.CreateTODO(ctx, "beta", ""); err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	return handler.NewCalDAVHandler(svc)
+}
+
+// putTestVTODO creates the object a.ics of the UID "a" and updates it to the
+// SUMMARY "a2", and returns its ETags before and after the update.
+func putTestVTODO(t *testing.T, h *handler.CalDAVHandler) (string, string) {
+	t.Helper()
+
+	var etags []string
+	for _, summary := range []string{"a1", "a2"} {
+		w := serveDAV(h, http.MethodPut, "/caldav/todos/a.ics", newTestVTODO("a", summary), map[string]string{"Content-Type": "text/calendar"})
+		if w.Code != http.StatusCreated && w.Code != http.StatusNoContent {
+			t.Fatalf("unexpected status, given = %d, body = %s", w.Code, w.Body)
+		}
+		w = serveDAV(h, http.MethodGet, "/caldav/todos/a.ics", "", nil)
+		etags = append(etags, w.Header().Get("ETag"))
+	}
+	if etags[0] == "" || etags[0] == etags[1] {
+		t.Fatalf("unexpected etags, given = %v, expected two different ones", etags)
+	}
+	return etags[0], etags[1]
+}
+
+func newTestVTODO(uid, summary string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTODO\r\n")
+	if uid != "" {
+		b.WriteString("UID:" + uid + "\r\n")
+	}
+	b.WriteString("SUMMARY:" + summary + "\r\nEND:VTODO\r\nEND:VCALENDAR\r\n")
+	return b.String()
+}
+
+func serveDAV(h http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeMultistatus(t *testing.T, w *httptest.ResponseRecorder) *davMultistatus {
+	t.Helper()
+
+	var ms davMultistatus
+	if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatalf("failed to decode multistatus, err = %v, body = %s", err, w.Body)
+	}
+	return &ms
+}
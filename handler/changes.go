@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A ChangesHandler implements the change feed, which pages the event log
+// written with every change of TODOs in its transaction.
+type ChangesHandler struct {
+	svc *service.TODOService
+}
+
+// NewChangesHandler returns ChangesHandler based http.Handler.
+func NewChangesHandler(svc *service.TODOService) *ChangesHandler {
+	return &ChangesHandler{
+		svc: svc,
+	}
+}
+
+// ReadChanges reads the changes after req.After. It fails with ErrGone when
+// some of them are no longer kept, so that consumers do not miss them silently.
+func (h *ChangesHandler) ReadChanges(ctx context.Context, req *model.ReadChangesRequest) (*model.ReadChangesResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+
+	var events []*model.TODOEvent
+	err := h.svc.RunInReadTx(ctx, func(ctx context.Context) error {
+		first, last, err := h.svc.EventLogBounds(ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case req.After+1 < first:
+			return model.ErrGone{Message: fmt.Sprintf("changes after %d are no longer kept, the oldest one is %d", req.After, first)}
+		case req.After > last:
+			return model.ErrValidation{
+				Message:       "request has invalid fields",
+				InvalidParams: []*model.InvalidParam{{Name: "after", Reason: "is ahead of the change feed"}},
+			}
+		}
+
+		// one more is read to tell whether the page is the last one
+		events, err = h.svc.ReadEvents(ctx, &model.ReadEventsRequest{}, req.After, req.Size+1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ReadChangesResponse{
+		Changes:   make([]*model.Change, 0, len(events)),
+		NextAfter: req.After,
+	}
+	if int64(len(events)) > req.Size {
+		events, response.HasMore = events[:req.Size], true
+	}
+	for _, e := range events {
+		response.Changes = append(response.Changes, &model.Change{
+			Seq:       e.ID,
+			Type:      e.Type,
+			TODOID:    e.TODOID,
+			Before:    e.Prior,
+			After:     e.TODO,
+			CreatedAt: e.CreatedAt,
+		})
+		response.NextAfter = e.ID
+	}
+	return response, nil
+}
+
+func (h *ChangesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	request, err := parseReadChangesRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	response, err := h.ReadChanges(r.Context(), request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
+
+// parseReadChangesRequest returns the ReadChangesRequest in the query of r.
+func parseReadChangesRequest(r *http.Request) (*model.ReadChangesRequest, error) {
+	var err error
+	q := r.URL.Query()
+
+	request := &model.ReadChangesRequest{Size: eventPageSize}
+	if after := q.Get("after"); after != "" {
+		if request.After, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return nil, model.ErrValidation{Message: "after must be an integer"}
+		}
+	}
+	if size := q.Get("size"); size != "" {
+		if request.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, model.ErrValidation{Message: "size must be an integer"}
+		}
+	}
+	return request, nil
+}
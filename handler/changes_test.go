@@ -0,0 +1,169 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestChanges(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query string
+		// dropped is the number of the oldest events no longer kept
+		dropped   int
+		status    int
+		code      string
+		seqs      []int64
+		nextAfter int64
+		hasMore   bool
+	}{
+		"All": {
+			status:    http.StatusOK,
+			seqs:      []int64{1, 2, 3, 4},
+			nextAfter: 4,
+		},
+		"First page": {
+			query:     "?size=3",
+			status:    http.StatusOK,
+			seqs:      []int64{1, 2, 3},
+			nextAfter: 3,
+			hasMore:   true,
+		},
+		"Last page": {
+			query:     "?after=3&size=3",
+			status:    http.StatusOK,
+			seqs:      []int64{4},
+			nextAfter: 4,
+		},
+		"Page of the size": {
+			query:     "?after=2&size=2",
+			status:    http.StatusOK,
+			seqs:      []int64{3, 4},
+			nextAfter: 4,
+		},
+		"Up to date": {
+			query:     "?after=4",
+			status:    http.StatusOK,
+			seqs:      []int64{},
+			nextAfter: 4,
+		},
+		"After the last one dropped": {
+			query:     "?after=2",
+			dropped:   2,
+			status:    http.StatusOK,
+			seqs:      []int64{3, 4},
+			nextAfter: 4,
+		},
+		"Dropped": {
+			query:   "?after=1",
+			dropped: 2,
+			status:  http.StatusGone,
+			code:    "gone",
+		},
+		"Dropped from the start": {
+			dropped: 1,
+			status:  http.StatusGone,
+			code:    "gone",
+		},
+		"Ahead": {
+			query:  "?after=5",
+			status: http.StatusBadRequest,
+			code:   "validation_failed",
+		},
+		"Invalid size": {
+			query:  "?size=0",
+			status: http.StatusBadRequest,
+			code:   "validation_failed",
+		},
+		"Invalid after": {
+			query:  "?after=a",
+			status: http.StatusBadRequest,
+			code:   "validation_failed",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			todoDB := newTestDB(t)
+			svc := service.NewTODOService(todoDB)
+
+			// 4 events: a created and updated, b created and deleted
+			a, err := svc.CreateTODO(ctx, "a", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := svc.UpdateTODO(ctx, a.ID, "a2", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			b, err := svc.CreateTODO(ctx, "b", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if err := svc.DeleteTODO(ctx, []int64{b.ID}); err != nil {
+				t.Fatal("failed to delete todo, err =", err)
+			}
+			if _, err := todoDB.Exec(`DELETE FROM todo_events WHERE id <= ?`, c.dropped); err != nil {
+				t.Fatal("failed to delete events, err =", err)
+			}
+
+			w := httptest.NewRecorder()
+			handler.NewChangesHandler(svc).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/changes"+c.query, nil))
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+
+			if c.status != http.StatusOK {
+				var problem model.Problem
+				if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+					t.Fatal("failed to decode problem, err =", err)
+				}
+				if problem.Code != c.code {
+					t.Errorf("unexpected code, given = %s, expected = %s", problem.Code, c.code)
+				}
+				return
+			}
+
+			var response model.ReadChangesResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal("failed to decode response, err =", err)
+			}
+			if response.NextAfter != c.nextAfter || response.HasMore != c.hasMore {
+				t.Errorf("unexpected page, given = %d %t, expected = %d %t", response.NextAfter, response.HasMore, c.nextAfter, c.hasMore)
+			}
+			if len(response.Changes) != len(c.seqs) {
+				t.Fatalf("unexpected changes, given = %d, expected = %d", len(response.Changes), len(c.seqs))
+			}
+			for i, change := range response.Changes {
+				if change.Seq != c.seqs[i] {
+					t.Errorf("unexpected seq, given = %d, expected = %d", change.Seq, c.seqs[i])
+				}
+				switch change.Type {
+				case model.EventCreated:
+					if change.Before != nil || change.After == nil {
+						t.Errorf("unexpected change, given = %+v, expected the TODO after it", change)
+					}
+				case model.EventUpdated:
+					if change.Before == nil || change.Before.Subject != "a" || change.After == nil || change.After.Subject != "a2" {
+						t.Errorf("unexpected change, given = %+v, expected the TODO before and after it", change)
+					}
+				case model.EventDeleted:
+					if change.Before == nil || change.After != nil {
+						t.Errorf("unexpected change, given = %+v, expected the TODO before it", change)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v2"
+)
+
+// A codec encodes responses into a media type and decodes requests from it.
+type codec struct {
+	// mediaTypes are the media type and its aliases. The first one is sent in
+	// Content-Type.
+	mediaTypes []string
+	// listOnly is true when the codec encodes only ReadTODOResponse.
+	listOnly bool
+	encode   func(w io.Writer, v interface{}) error
+	// decode converts a request body into JSON, nil when the codec does not
+	// accept requests.
+	decode func(b []byte) ([]byte, error)
+}
+
+// codecs is the registry of codecs in the order of preference when the Accept
+// header allows several of them.
+var codecs = []*codec{
+	{
+		mediaTypes: []string{"application/json"},
+		encode:     encodeJSON,
+		decode:     func(b []byte) ([]byte, error) { return b, nil },
+	},
+	{
+		mediaTypes: []string{"application/x-ndjson", "application/ndjson"},
+		listOnly:   true,
+		encode:     encodeNDJSON,
+	},
+	{
+		mediaTypes: []string{"text/csv"},
+		listOnly:   true,
+		encode:     encodeCSV,
+	},
+	{
+		mediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"},
+		encode:     encodeYAML,
+	},
+	{
+		mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:     encodeMsgpack,
+		decode:     decodeMsgpack,
+	},
+}
+
+// negotiate returns the codec preferred by the Accept header. list is true when
+// the response is ReadTODOResponse. An empty header accepts JSON.
+func negotiate(accept string, list bool) (*codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], nil
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, s := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(s)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	var (
+		best  *codec
+		bestQ float64
+	)
+	for _, c := range codecs {
+		if c.listOnly && !list {
+			continue
+		}
+
+		// the most specific range matching the codec decides its quality
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			for _, t := range c.mediaTypes {
+				s := -1
+				switch {
+				case r.mediaType == t:
+					s = 2
+				case r.mediaType == t[:strings.Index(t, "/")]+"/*":
+					s = 1
+				case r.mediaType == "*/*":
+					s = 0
+				}
+				if s > specificity {
+					q, specificity = r.q, s
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+
+	if best == nil {
+		return nil, model.ErrNotAcceptable{}
+	}
+	return best, nil
+}
+
+// requestCodec returns the codec of the Content-Type of r, which is JSON when
+// the header is missing.
+func requestCodec(r *http.Request) (*codec, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[0], nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, model.ErrUnsupportedMediaType{}
+	}
+	for _, c := range codecs {
+		if c.decode == nil {
+			continue
+		}
+		for _, t := range c.mediaTypes {
+			if t == mediaType {
+				return c, nil
+			}
+		}
+	}
+	return nil, model.ErrUnsupportedMediaType{}
+}
+
+// writeResponse writes v encoded by c with the status code.
+func writeResponse(w http.ResponseWriter, c *codec, status int, v interface{}) {
+	contentType := c.mediaTypes[0]
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	if err := c.encode(w, v); err != nil {
+		log.Println(err)
+	}
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// encodeNDJSON writes the TODOs one per line.
+func encodeNDJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		TODOs []json.RawMessage `json:"todos"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return err
+	}
+
+	for _, t := range response.TODOs {
+		if _, err := fmt.Fprintf(w, "%s\n", t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeCSV writes the selected fields of the TODOs with a header line.
+func encodeCSV(w io.Writer, v interface{}) error {
+	response, ok := v.(*model.ReadTODOResponse)
+	if !ok {
+		return fmt.Errorf("csv: cannot encode %T", v)
+	}
+	fields := response.Fields
+	if len(fields) == 0 {
+		fields = model.TODOFields
+	}
+
+	g, err := generic(response)
+	if err != nil {
+		return err
+	}
+	todos, _ := g.(map[string]interface{})["todos"].([]interface{})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	for _, t := range todos {
+		record := make([]string, len(fields))
+		for i, f := range fields {
+			record[i] = fmt.Sprint(t.(map[string]interface{})[f])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func encodeYAML(w io.Writer, v interface{}) error {
+	g, err := generic(v)
+	if err != nil {
+		return err
+	}
+	e := yaml.NewEncoder(w)
+	if err := e.Encode(g); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	g, err := generic(v)
+	if err != nil {
+		return err
+	}
+	e := msgpack.NewEncoder(w)
+	e.SetSortMapKeys(true)
+	return e.Encode(g)
+}
+
+func decodeMsgpack(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// generic converts v into maps, slices and scalars through its JSON encoding,
+// so that the other codecs follow the JSON names and the selected fields.
+func generic(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	g, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var convert func(v interface{}) interface{}
+	convert = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				v[k] = convert(e)
+			}
+		case []interface{}:
+			for i, e := range v {
+				v[i] = convert(e)
+			}
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				return n
+			}
+			f, _ := v.Float64()
+			return f
+		}
+		return v
+	}
+	return convert(g), nil
+}
+
+// decodeBody reads the body of r and converts it into JSON by its Content-Type.
+func decodeBody(r *http.Request) ([]byte, error) {
+	c, err := requestCodec(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, model.ErrValidation{Message: err.Error()}
+	}
+	if b, err = c.decode(b); err != nil {
+		return nil, model.ErrValidation{Message: "request body is not valid " + c.mediaTypes[0] + ": " + err.Error()}
+	}
+	return b, nil
+}
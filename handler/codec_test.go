@@ -0,0 +1,115 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestTODOContentNegotiation(t *testing.T) {
+	t.Parallel()
+
+	msgpackBody, err := msgpack.Marshal(map[string]string{"subject": "packed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		method      string
+		accept      string
+		contentType string
+		body        []byte
+		status      int
+		// mediaType is the media type of the response, and body its beginning
+		mediaType string
+		prefix    string
+	}{
+		"No Accept":         {method: http.MethodGet, status: http.StatusOK, mediaType: "application/json", prefix: `{"todos":[`},
+		"Any":               {method: http.MethodGet, accept: "*/*", status: http.StatusOK, mediaType: "application/json"},
+		"NDJSON":            {method: http.MethodGet, accept: "application/x-ndjson", status: http.StatusOK, mediaType: "application/x-ndjson", prefix: `{"id":2,`},
+		"CSV":               {method: http.MethodGet, accept: "text/csv", status: http.StatusOK, mediaType: "text/csv", prefix: "id,subject,description,created_at,updated_at\n2,b,"},
+		"YAML by alias":     {method: http.MethodGet, accept: "text/yaml", status: http.StatusOK, mediaType: "application/yaml", prefix: "todos:\n"},
+		"Quality":           {method: http.MethodGet, accept: "application/json;q=0.5, text/csv", status: http.StatusOK, mediaType: "text/csv"},
+		"Specific range":    {method: http.MethodGet, accept: "text/*, text/csv;q=0.1", status: http.StatusOK, mediaType: "application/yaml"},
+		"Not acceptable":    {method: http.MethodGet, accept: "image/png", status: http.StatusNotAcceptable},
+		"List only":         {method: http.MethodPost, accept: "text/csv", body: []byte(`{"subject":"a"}`), status: http.StatusNotAcceptable},
+		"MessagePack":       {method: http.MethodPost, accept: "application/msgpack", contentType: "application/msgpack", body: msgpackBody, status: http.StatusOK, mediaType: "application/msgpack"},
+		"Unsupported input": {method: http.MethodPost, contentType: "text/csv", body: []byte("subject\na\n"), status: http.StatusUnsupportedMediaType},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := newTestService(t)
+			for _, subject := range []string{"a", "b"} {
+				if _, err := svc.CreateTODO(context.Background(), subject, ""); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+
+			r := httptest.NewRequest(c.method, "/todos", bytes.NewReader(c.body))
+			if c.accept != "" {
+				r.Header.Set("Accept", c.accept)
+			}
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.NewTODOHandler(svc).ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.mediaType != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), c.mediaType) {
+				t.Errorf("unexpected Content-Type, given = %s, expected = %s", w.Header().Get("Content-Type"), c.mediaType)
+			}
+			if !strings.HasPrefix(w.Body.String(), c.prefix) {
+				t.Errorf("unexpected body, given = %q, expected prefix = %q", w.Body.String(), c.prefix)
+			}
+		})
+	}
+}
+
+func TestTODOMessagePackResponse(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestService(t)
+	r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"subject":"packed"}`))
+	r.Header.Set("Accept", "application/vnd.msgpack")
+	w := httptest.NewRecorder()
+	handler.NewTODOHandler(svc).ServeHTTP(w, r)
+
+	var response struct {
+		TODO struct {
+			ID      int64  `msgpack:"id"`
+			Subject string `msgpack:"subject"`
+		} `msgpack:"todo"`
+	}
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("failed to decode response, err =", err)
+	}
+	if response.TODO.Subject != "packed" || response.TODO.ID == 0 {
+		t.Errorf("unexpected todo, given = %+v", response.TODO)
+	}
+
+	// the JSON and MessagePack encodings carry the same fields
+	var fields map[string]interface{}
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal("failed to decode response, err =", err)
+	}
+	b, _ := json.Marshal(fields["todo"])
+	for _, f := range []string{`"id"`, `"subject"`, `"description"`, `"created_at"`, `"updated_at"`} {
+		if !strings.Contains(string(b), f) {
+			t.Errorf("unexpected todo, given = %s, expected field = %s", b, f)
+		}
+	}
+}
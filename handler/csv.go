@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// CSVContentType is the media type of CSV files.
+	CSVContentType = "text/csv"
+
+	// csvImportMaxRows is the number of rows a CSV import accepts at once.
+	csvImportMaxRows = 10000
+)
+
+var (
+	// csvImportFields are the fields of TODO a CSV import reads.
+	csvImportFields = []string{"subject", "description", "created_at", "updated_at"}
+
+	// csvTimeLayouts are the layouts of the times in CSV rows, which are in the
+	// local time unless they have an offset.
+	csvTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+)
+
+// A CSVHandler implements importing TODOs from CSV files such as the ones
+// exported by spreadsheets.
+type CSVHandler struct {
+	svc *service.TODOService
+}
+
+// NewCSVHandler returns CSVHandler based http.Handler.
+func NewCSVHandler(svc *service.TODOService) *CSVHandler {
+	return &CSVHandler{
+		svc: svc,
+	}
+}
+
+// A csvRow expresses a valid row of a CSV import.
+type csvRow struct {
+	subject, description string
+	createdAt, updatedAt time.Time
+}
+
+// Import handles the endpoint that validates every row of the CSV data, and
+// unless it is a dry run, creates their TODOs in one transaction when all of
+// them are valid.
+func (h *CSVHandler) Import(ctx context.Context, req *model.ImportCSVRequest, data []byte) (*model.ImportCSVResponse, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	// missing cells of short rows are empty
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, model.ErrValidation{Message: "request body has no header row"}
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	columns, err := mapCSVColumns(header, req.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ImportCSVResponse{
+		DryRun:   req.DryRun,
+		Imported: make([]*model.TODO, 0),
+		Errors:   make([]*model.CSVRowError, 0),
+	}
+	var rows []*csvRow
+	for n := 2; ; n++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		response.Rows++
+		if response.Rows > csvImportMaxRows {
+			return nil, model.ErrValidation{Message: fmt.Sprintf("request body has more than %d rows", csvImportMaxRows)}
+		}
+
+		row, params := parseCSVRow(record, columns)
+		if len(params) > 0 {
+			response.Errors = append(response.Errors, &model.CSVRowError{Row: n, InvalidParams: params})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	response.Valid = len(rows)
+
+	if req.DryRun || len(response.Errors) > 0 {
+		return response, nil
+	}
+
+	err = h.svc.RunInTx(ctx, func(ctx context.Context) error {
+		for _, row := range rows {
+			t, err := h.svc.CreateTODOAt(ctx, row.subject, row.description, row.createdAt, row.updatedAt)
+			if err != nil {
+				return err
+			}
+			response.Imported = append(response.Imported, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response.Committed = true
+	return response, nil
+}
+
+func (h *CSVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Add("Vary", "Accept")
+		c, err := negotiate(r.Header.Get("Accept"), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != CSVContentType {
+				WriteError(w, r, model.ErrUnsupportedMediaType{})
+				return
+			}
+		}
+
+		request, err := parseImportCSVRequest(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, model.ErrValidation{Message: err.Error()})
+			return
+		}
+
+		response, err := h.Import(ctx, request, data)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		status := http.StatusOK
+		if !response.DryRun && !response.Committed {
+			status = http.StatusUnprocessableEntity
+		}
+		writeResponse(w, c, status, response)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// parseImportCSVRequest reads the dry_run and columns query parameters. columns
+// is a list of field:header pairs.
+func parseImportCSVRequest(r *http.Request) (*model.ImportCSVRequest, error) {
+	q := r.URL.Query()
+	request := &model.ImportCSVRequest{Columns: make(map[string]string)}
+
+	if v := q.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, model.ErrValidation{Message: "dry_run must be a boolean"}
+		}
+		request.DryRun = dryRun
+	}
+
+	var params []*model.InvalidParam
+	for _, pair := range splitList(q.Get("columns")) {
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			params = append(params, &model.InvalidParam{Name: "columns", Reason: fmt.Sprintf("%q is not a field:header pair", pair)})
+			continue
+		}
+		field := strings.TrimSpace(pair[:i])
+		known := false
+		for _, f := range csvImportFields {
+			known = known || f == field
+		}
+		if !known {
+			params = append(params, &model.InvalidParam{Name: "columns", Reason: fmt.Sprintf("%q is not a field of TODO", field)})
+			continue
+		}
+		request.Columns[field] = strings.TrimSpace(pair[i+1:])
+	}
+	if len(params) > 0 {
+		return nil, model.ErrValidation{Message: "request has invalid fields", InvalidParams: params}
+	}
+
+	return request, nil
+}
+
+// mapCSVColumns returns the indexes of the columns of the fields in the header.
+// Headers are matched ignoring case.
+func mapCSVColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	find := func(name string) int {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var (
+		columns = make(map[string]int)
+		params  []*model.InvalidParam
+	)
+	for _, field := range csvImportFields {
+		name, mapped := mapping[field]
+		if !mapped {
+			name = field
+		}
+		i := find(name)
+		switch {
+		case i >= 0:
+			columns[field] = i
+		case mapped:
+			params = append(params, &model.InvalidParam{Name: "columns", Reason: fmt.Sprintf("header %q of %s is not found", name, field)})
+		case field == "subject":
+			params = append(params, &model.InvalidParam{Name: "columns", Reason: "subject column is required"})
+		}
+	}
+	if len(params) > 0 {
+		return nil, model.ErrValidation{Message: "request has invalid fields", InvalidParams: params}
+	}
+	return columns, nil
+}
+
+// parseCSVRow returns the row of the record, or the fields that are invalid.
+func parseCSVRow(record []string, columns map[string]int) (*csvRow, []*model.InvalidParam) {
+	cell := func(field string) string {
+		if i, ok := columns[field]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var params []*model.InvalidParam
+	req := &model.CreateTODORequest{Subject: cell("subject"), Description: cell("description")}
+	if err := model.Validate(req); err != nil {
+		params = append(params, err.(model.ErrValidation).InvalidParams...)
+	}
+
+	now := time.Now()
+	row := &csvRow{subject: req.Subject, description: req.Description, createdAt: now}
+	if v := strings.TrimSpace(cell("created_at")); v != "" {
+		t, ok := parseCSVTime(v)
+		if !ok {
+			params = append(params, &model.InvalidParam{Name: "created_at", Reason: "must be a date or a time"})
+		}
+		row.createdAt = t
+	}
+	row.updatedAt = row.createdAt
+	if v := strings.TrimSpace(cell("updated_at")); v != "" {
+		t, ok := parseCSVTime(v)
+		if !ok {
+			params = append(params, &model.InvalidParam{Name: "updated_at", Reason: "must be a date or a time"})
+		}
+		row.updatedAt = t
+	}
+
+	if len(params) > 0 {
+		return nil, params
+	}
+	return row, nil
+}
+
+func parseCSVTime(s string) (time.Time, bool) {
+	for _, layout := range csvTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// csvError returns the error of malformed CSV data as ErrValidation.
+func csvError(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return model.ErrValidation{Message: fmt.Sprintf("CSV line %d is invalid: %v", pe.Line, pe.Err)}
+	}
+	return model.ErrValidation{Message: err.Error()}
+}
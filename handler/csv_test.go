@@ -0,0 +1,141 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestCSVImport(t *testing.T) {
+	t.Parallel()
+
+	type imported struct {
+		subject, description string
+		createdAt, updatedAt time.Time
+	}
+	cases := map[string]struct {
+		data      string
+		columns   map[string]string
+		dryRun    bool
+		imported  []imported
+		errorRows []int
+		valid     int
+		err       bool
+	}{
+		"Headers": {
+			data:     "Subject,Description,Created_At\na,d,2020-01-02\nb,,2020-01-02 03:04:05\n",
+			imported: []imported{{subject: "a", description: "d", createdAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)}, {subject: "b", createdAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)}},
+			valid:    2,
+		},
+		"Columns": {
+			data:     "Title,Notes,Priority\na,d,high\n",
+			columns:  map[string]string{"subject": "title", "description": "Notes"},
+			imported: []imported{{subject: "a", description: "d"}},
+			valid:    1,
+		},
+		"BOM, short and blank rows": {
+			data:     "\ufeffsubject,description\na\n,\nb,d\n",
+			imported: []imported{{subject: "a"}, {subject: "b", description: "d"}},
+			valid:    2,
+		},
+		"updated_at": {
+			data: "subject,created_at,updated_at\na,2000-01-01,2000-01-02\nb,2000-01-01,\n",
+			imported: []imported{
+				{subject: "a", createdAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local), updatedAt: time.Date(2000, 1, 2, 0, 0, 0, 0, time.Local)},
+				{subject: "b", createdAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local), updatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)},
+			},
+			valid: 2,
+		},
+		"Dry run": {
+			data:   "subject\na\nb\n",
+			dryRun: true,
+			valid:  2,
+		},
+		"Invalid rows": {
+			data:      "subject,created_at\na,2020-01-02\n,2020-01-02\nb,yesterday\n",
+			errorRows: []int{3, 4},
+			valid:     1,
+		},
+		"Missing subject column": {
+			data: "title\na\n",
+			err:  true,
+		},
+		"Missing mapped column": {
+			data:    "subject\na\n",
+			columns: map[string]string{"description": "notes"},
+			err:     true,
+		},
+		"No header": {
+			data: "",
+			err:  true,
+		},
+		"Malformed": {
+			data: "subject\n\"a\n",
+			err:  true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			response, err := handler.NewCSVHandler(svc).Import(ctx, &model.ImportCSVRequest{Columns: c.columns, DryRun: c.dryRun}, []byte(c.data))
+			if c.err {
+				if !errors.As(err, &model.ErrValidation{}) {
+					t.Fatalf("unexpected error, given = %v, expected = ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+
+			if response.Valid != c.valid {
+				t.Errorf("unexpected valid, given = %d, expected = %d", response.Valid, c.valid)
+			}
+			if len(response.Errors) != len(c.errorRows) {
+				t.Fatalf("unexpected errors, given = %d, expected = %d", len(response.Errors), len(c.errorRows))
+			}
+			for i, e := range response.Errors {
+				if e.Row != c.errorRows[i] {
+					t.Errorf("unexpected error row, given = %d, expected = %d", e.Row, c.errorRows[i])
+				}
+			}
+			committed := !c.dryRun && len(c.errorRows) == 0
+			if response.Committed != committed {
+				t.Errorf("unexpected committed, given = %t, expected = %t", response.Committed, committed)
+			}
+
+			if len(response.Imported) != len(c.imported) {
+				t.Fatalf("unexpected imported, given = %d, expected = %d", len(response.Imported), len(c.imported))
+			}
+			for i, got := range response.Imported {
+				want := c.imported[i]
+				if got.Subject != want.subject || got.Description != want.description {
+					t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, want.subject, want.description)
+				}
+				if !want.createdAt.IsZero() && !got.CreatedAt.Equal(want.createdAt) {
+					t.Errorf("unexpected created_at, given = %s, expected = %s", got.CreatedAt, want.createdAt)
+				}
+				if !want.updatedAt.IsZero() && !got.UpdatedAt.Equal(want.updatedAt) {
+					t.Errorf("unexpected updated_at, given = %s, expected = %s", got.UpdatedAt, want.updatedAt)
+				}
+			}
+
+			count, err := svc.CountTODOs(ctx)
+			if err != nil {
+				t.Fatal("failed to count todos, err =", err)
+			}
+			if count != int64(len(c.imported)) {
+				t.Errorf("unexpected count, given = %d, expected = %d", count, len(c.imported))
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// todoETag returns the entity tag of the TODO at the version.
+func todoETag(id, version int64) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// listETag returns the entity tag of the TODOs and the count returned in one
+// response encoded in the media type. The fields and the relations included
+// are part of it, because they change the representation.
+func listETag(response *model.ReadTODOResponse, mediaType string) string {
+	sorted := func(list []string) string {
+		list = append([]string(nil), list...)
+		sort.Strings(list)
+		return strings.Join(list, ",")
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s,fields=%s,include=%s,", mediaType, sorted(response.Fields), sorted(response.Include))
+	for _, t := range response.TODOs {
+		fmt.Fprintf(h, "%d-%d,", t.ID, response.Versions[t.ID])
+	}
+	if response.Count != nil {
+		fmt.Fprintf(h, "count=%d", *response.Count)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// parseETags splits the value of If-Match or If-None-Match into entity tags.
+// wildcard is true when the value is "*".
+func parseETags(header string) (tags []string, wildcard bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if tag == "*" {
+			return nil, true
+		}
+		tags = append(tags, tag)
+	}
+	return tags, false
+}
+
+// noneMatch reports whether If-None-Match matches etag by the weak comparison.
+func noneMatch(header, etag string) bool {
+	tags, wildcard := parseETags(header)
+	if wildcard {
+		return true
+	}
+	for _, tag := range tags {
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the conditional GET request r can be answered by
+// 304 Not Modified. If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return noneMatch(inm, etag)
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
+
+// checkNotModified sets the validators and Cache-Control of a list response,
+// and writes 304 Not Modified and returns true when r is satisfied by them.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	// responses are per user behind Basic auth and revalidated on every use
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if !notModified(r, etag, lastModified) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifMatchVersions parses If-Match into the versions required for each TODO id.
+// Weak tags are ignored because If-Match uses the strong comparison.
+func ifMatchVersions(header string) (versions map[int64]int64, wildcard bool) {
+	tags, wildcard := parseETags(header)
+	if wildcard {
+		return nil, true
+	}
+
+	versions = make(map[int64]int64, len(tags))
+	for _, tag := range tags {
+		var id, version int64
+		if _, err := fmt.Sscanf(tag, `"%d-%d"`, &id, &version); err != nil {
+			continue
+		}
+		versions[id] = version
+	}
+	return versions, false
+}
+
+// setUpdateIfMatch sets the version required by If-Match to the request.
+func setUpdateIfMatch(req *model.UpdateTODORequest, header string) error {
+	if header == "" {
+		return nil
+	}
+	versions, wildcard := ifMatchVersions(header)
+	if wildcard {
+		return nil
+	}
+	version, ok := versions[int64(req.ID)]
+	if !ok {
+		return model.ErrPreconditionFailed{}
+	}
+	req.Version = &version
+	return nil
+}
+
+// setDeleteIfMatch sets the versions required by If-Match to the request.
+func setDeleteIfMatch(req *model.DeleteTODORequest, header string) {
+	if header == "" {
+		return
+	}
+	if versions, wildcard := ifMatchVersions(header); !wildcard {
+		req.Versions = versions
+	}
+}
@@ -0,0 +1,163 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestTODOConditionalGet(t *testing.T) {
+	t.Parallel()
+
+	anHourAgo := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	old := "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nSUMMARY:old\r\nCREATED:20200101T000000Z\r\nLAST-MODIFIED:20200101T000000Z\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+
+	cases := map[string]struct {
+		// empty is true when no TODO exists before the first response
+		empty bool
+		// change changes the TODOs after the first response
+		change func(ctx context.Context, svc *service.TODOService) error
+		// ifNoneMatch and ifModifiedSince return the headers of the second
+		// request from the validators of the first response
+		ifNoneMatch     func(etag string) string
+		ifModifiedSince func(lastModified string) string
+		status          int
+	}{
+		"If-None-Match": {
+			ifNoneMatch: func(etag string) string { return etag },
+			status:      http.StatusNotModified,
+		},
+		"If-None-Match in a list": {
+			ifNoneMatch: func(etag string) string { return `"other", ` + etag },
+			status:      http.StatusNotModified,
+		},
+		"If-None-Match after an update": {
+			change: func(ctx context.Context, svc *service.TODOService) error {
+				_, err := svc.UpdateTODO(ctx, 1, "updated", "")
+				return err
+			},
+			ifNoneMatch: func(etag string) string { return etag },
+			status:      http.StatusOK,
+		},
+		"If-Modified-Since": {
+			ifModifiedSince: func(lastModified string) string { return lastModified },
+			status:          http.StatusNotModified,
+		},
+		"If-Modified-Since before": {
+			ifModifiedSince: func(string) string { return anHourAgo },
+			status:          http.StatusOK,
+		},
+		"If-None-Match before If-Modified-Since": {
+			ifNoneMatch:     func(string) string { return `"other"` },
+			ifModifiedSince: func(lastModified string) string { return lastModified },
+			status:          http.StatusOK,
+		},
+		"If-Modified-Since after an import of old TODOs": {
+			empty: true,
+			change: func(ctx context.Context, svc *service.TODOService) error {
+				_, err := handler.NewICalendarHandler(svc).Import(ctx, []byte(old))
+				return err
+			},
+			ifModifiedSince: func(string) string { return anHourAgo },
+			status:          http.StatusOK,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			h := handler.NewTODOHandler(svc)
+			if !c.empty {
+				if _, err := svc.CreateTODO(ctx, "subject", ""); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status, given = %d, expected = %d", w.Code, http.StatusOK)
+			}
+			etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+
+			if c.change != nil {
+				if err := c.change(ctx, svc); err != nil {
+					t.Fatal("failed to change todos, err =", err)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if c.ifNoneMatch != nil {
+				r.Header.Set("If-None-Match", c.ifNoneMatch(etag))
+			}
+			if c.ifModifiedSince != nil {
+				r.Header.Set("If-Modified-Since", c.ifModifiedSince(lastModified))
+			}
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Errorf("unexpected status, given = %d, expected = %d", w.Code, c.status)
+			}
+			if c.status == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Errorf("unexpected body of 304, given = %q", w.Body)
+			}
+		})
+	}
+}
+
+func TestTODOListETagOfRepresentations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc := newTestService(t)
+	if _, err := svc.CreateTODO(ctx, "subject", ""); err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	h := handler.NewTODOHandler(svc)
+
+	etag := func(query, ifNoneMatch string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, "/todos"+query, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code, w.Header().Get("ETag")
+	}
+
+	queries := []string{"", "?fields=id", "?fields=id,subject", "?include=count", "?include=etags", "?include=count,etags"}
+	etags := make(map[string]string)
+	for _, query := range queries {
+		_, tag := etag(query, "")
+		if other, ok := etags[tag]; ok {
+			t.Errorf("unexpected etag, given = the same for %q and %q", query, other)
+		}
+		etags[tag] = query
+
+		for _, other := range queries {
+			if other == query {
+				continue
+			}
+			if status, _ := etag(other, tag); status != http.StatusOK {
+				t.Errorf("unexpected status of %q with the etag of %q, given = %d, expected = %d", other, query, status, http.StatusOK)
+			}
+		}
+	}
+
+	// the order of the lists does not change the representation
+	_, a := etag("?fields=subject,id&include=etags,count", "")
+	_, b := etag("?fields=id,subject&include=count,etags", "")
+	if a != b {
+		t.Errorf("unexpected etag, given = %s, expected = %s", a, b)
+	}
+}
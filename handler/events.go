@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// EventStreamContentType is the media type of Server-Sent Events.
+	EventStreamContentType = "text/event-stream"
+
+	// defaultHeartbeat is the interval of the comments keeping streams alive
+	// through proxies closing idle connections.
+	defaultHeartbeat = 15 * time.Second
+
+	// eventPageSize is the number of events read from the event log at once.
+	eventPageSize = 100
+)
+
+// An EventsHandler implements streaming the events of TODOs as Server-Sent
+// Events. Streams resume after the Last-Event-ID header, or the last_event_id
+// query parameter, from the event log.
+type EventsHandler struct {
+	svc       *service.TODOService
+	heartbeat time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEventsHandler returns EventsHandler based http.Handler.
+func NewEventsHandler(svc *service.TODOService) *EventsHandler {
+	return &EventsHandler{
+		svc:       svc,
+		heartbeat: defaultHeartbeat,
+		done:      make(chan struct{}),
+	}
+}
+
+// Close ends the streams being served, which http.Server.Shutdown waits for.
+// It is registered by http.Server.RegisterOnShutdown.
+func (h *EventsHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, r, fmt.Errorf("events: %T does not support flushing", w))
+		return
+	}
+
+	request, err := parseReadEventsRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	// subscribe before reading the log so that no event is missed in between
+	notified, cancel := h.svc.SubscribeEvents()
+	defer cancel()
+
+	ctx := r.Context()
+	first, last, err := h.svc.EventLogBounds(ctx)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", EventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	cursor := request.LastEventID
+	if _, resumed := resumeFrom(r); !resumed {
+		cursor = last
+	} else if cursor+1 < first {
+		// the events after the cursor are no longer kept
+		writeEvent(w, "", "reset", map[string]string{"reason": "events after the last event id are no longer kept"})
+		cursor = last
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		for {
+			events, err := h.svc.ReadEvents(ctx, request, cursor, eventPageSize)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			for _, e := range events {
+				if err := writeEvent(w, strconv.FormatInt(e.ID, 10), e.Type, e); err != nil {
+					log.Println(err)
+					return
+				}
+				cursor = e.ID
+			}
+			flusher.Flush()
+			if len(events) < eventPageSize {
+				break
+			}
+		}
+
+		select {
+		case <-notified:
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// resumeFrom returns the id of the last event received by the client from the
+// Last-Event-ID header, which EventSource sends on reconnection, or the
+// last_event_id query parameter.
+func resumeFrom(r *http.Request) (string, bool) {
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		return v, true
+	}
+	if v := r.URL.Query().Get("last_event_id"); v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+func parseReadEventsRequest(r *http.Request) (*model.ReadEventsRequest, error) {
+	q := r.URL.Query()
+	request := &model.ReadEventsRequest{Types: splitList(q.Get("types"))}
+
+	if v, ok := resumeFrom(r); ok {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, model.ErrValidation{Message: "last event id must be an integer"}
+		}
+		request.LastEventID = id
+	}
+
+	for _, v := range splitList(q.Get("todo_ids")) {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, model.ErrValidation{Message: "todo_ids must be integers"}
+		}
+		request.TODOIDs = append(request.TODOIDs, id)
+	}
+
+	if err := model.Validate(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// writeEvent writes the event of the JSON of v. The data fits in a line as
+// encoding/json escapes newlines.
+func writeEvent(w http.ResponseWriter, id, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,205 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query       string
+		lastEventID string
+		// dropped is the number of the oldest events no longer kept
+		dropped int
+		status  int
+		// events are the events received as "<id> <event> <todo id>"
+		events []string
+	}{
+		"Live": {
+			status: http.StatusOK,
+			events: []string{"4 created 3", "5 deleted 2"},
+		},
+		"Resumed": {
+			lastEventID: "1",
+			status:      http.StatusOK,
+			events:      []string{"2 updated 1", "3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Resumed by the query": {
+			query:  "?last_event_id=2",
+			status: http.StatusOK,
+			events: []string{"3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Types": {
+			query:       "?types=created",
+			lastEventID: "0",
+			status:      http.StatusOK,
+			events:      []string{"1 created 1", "3 created 2", "4 created 3"},
+		},
+		"TODO ids": {
+			query:       "?todo_ids=2",
+			lastEventID: "0",
+			status:      http.StatusOK,
+			events:      []string{"3 created 2", "5 deleted 2"},
+		},
+		"Resumed after the last one dropped": {
+			lastEventID: "2",
+			dropped:     2,
+			status:      http.StatusOK,
+			events:      []string{"3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Resumed from one dropped": {
+			lastEventID: "1",
+			dropped:     2,
+			status:      http.StatusOK,
+			events:      []string{" reset 0", "4 created 3", "5 deleted 2"},
+		},
+		"Invalid last event id": {
+			lastEventID: "a",
+			status:      http.StatusBadRequest,
+		},
+		"Invalid type": {
+			query:  "?types=renamed",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			todoDB := newTestDB(t)
+			svc := service.NewTODOService(todoDB)
+
+			// 3 events: a created and updated, b created
+			a, err := svc.CreateTODO(ctx, "a", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := svc.UpdateTODO(ctx, a.ID, "a2", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			b, err := svc.CreateTODO(ctx, "b", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := todoDB.Exec(`DELETE FROM todo_events WHERE id <= ?`, c.dropped); err != nil {
+				t.Fatal("failed to delete events, err =", err)
+			}
+
+			res := getEvents(ctx, t, handler.NewEventsHandler(svc), c.query, c.lastEventID)
+			if res.StatusCode != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d", res.StatusCode, c.status)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+			if ct := res.Header.Get("Content-Type"); ct != handler.EventStreamContentType {
+				t.Errorf("unexpected content type, given = %s, expected = %s", ct, handler.EventStreamContentType)
+			}
+
+			// 2 events while streaming: c created, b deleted
+			if _, err := svc.CreateTODO(ctx, "c", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if err := svc.DeleteTODO(ctx, []int64{b.ID}); err != nil {
+				t.Fatal("failed to delete todo, err =", err)
+			}
+
+			events := readStreamEvents(t, bufio.NewReader(res.Body), len(c.events))
+			if strings.Join(events, ",") != strings.Join(c.events, ",") {
+				t.Errorf("unexpected events, given = %q, expected = %q", events, c.events)
+			}
+		})
+	}
+}
+
+func TestEventsClose(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := handler.NewEventsHandler(newTestService(t))
+
+	res := getEvents(ctx, t, h, "", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status, given = %d, expected = %d", res.StatusCode, http.StatusOK)
+	}
+
+	h.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		t.Errorf("unexpected error, given = %v, expected the stream to end", err)
+	}
+}
+
+// getEvents starts streaming the events from h, which ends with the test.
+func getEvents(ctx context.Context, t *testing.T, h *handler.EventsHandler, query, lastEventID string) *http.Response {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Cleanup(h.Close)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal("failed to create request, err =", err)
+	}
+	if lastEventID != "" {
+		r.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal("failed to get events, err =", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// readStreamEvents reads n events of the stream as "<id> <event> <todo id>",
+// skipping comments.
+func readStreamEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+
+	events := make([]string, 0, n)
+	var id, event, data string
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event, err = %v, events = %q", err, events)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			var e model.TODOEvent
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatal("failed to decode event, err =", err)
+			}
+			events = append(events, fmt.Sprintf("%s %s %d", id, event, e.TODOID))
+			id, event, data = "", "", ""
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
@@ -0,0 +1,477 @@
+package handler
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+// graphqlMaxDepth limits the nesting of queries, which can follow parents and
+// children without end.
+const graphqlMaxDepth = 10
+
+// A GraphQLHandler implements the GraphQL endpoint over the TODOs.
+type GraphQLHandler struct {
+	schema *graphql.Schema
+}
+
+// NewGraphQLHandler returns GraphQLHandler based http.Handler.
+func NewGraphQLHandler(svc *service.TODOService) *GraphQLHandler {
+	resolver := &graphqlResolver{todo: NewTODOHandler(svc)}
+	return &GraphQLHandler{
+		schema: graphql.MustParseSchema(graphqlSchema, resolver, graphql.MaxDepth(graphqlMaxDepth)),
+	}
+}
+
+func (h *GraphQLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	// errors of the operation are in the response, only the errors of the
+	// request are problems
+	var request model.GraphQLRequest
+	if err := decodeRequest(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	response := h.schema.Exec(r.Context(), request.Query, request.OperationName, request.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
+
+// A graphqlError carries the problem of an error to the extensions of the
+// GraphQL error, so that clients branch on the same codes as the REST API.
+type graphqlError struct {
+	problem *model.Problem
+}
+
+func newGraphQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Println(err)
+	return &graphqlError{problem: NewProblem(err)}
+}
+
+func (e *graphqlError) Error() string {
+	if e.problem.Detail != "" {
+		return e.problem.Detail
+	}
+	return e.problem.Title
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.problem.Code}
+	if len(e.problem.InvalidParams) > 0 {
+		ext["invalid_params"] = e.problem.InvalidParams
+	}
+	return ext
+}
+
+// A graphqlResolver resolves the queries and mutations of the schema.
+type graphqlResolver struct {
+	todo *TODOHandler
+}
+
+func (r *graphqlResolver) TODO(ctx context.Context, args struct{ ID graphql.ID }) (*todoResolver, error) {
+	id, err := parseGraphQLID("id", args.ID)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	t, version, err := r.todo.svc.GetTODO(ctx, id)
+	if errors.As(err, &model.ErrNotFound{}) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	return newTODOBatch(r.todo.svc, []*model.TODO{t}, map[int64]int64{id: version})[0], nil
+}
+
+func (r *graphqlResolver) TODOs(ctx context.Context, args struct {
+	PrevID *graphql.ID
+	Size   int32
+	Filter *struct {
+		IDs   *[]graphql.ID
+		Query *string
+	}
+}) (*todoConnectionResolver, error) {
+	request := &model.ReadTODORequest{Size: int64(args.Size)}
+	if args.PrevID != nil {
+		id, err := parseGraphQLID("prevId", *args.PrevID)
+		if err != nil {
+			return nil, newGraphQLError(err)
+		}
+		request.PrevID = id
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	filter := &model.TODOFilter{}
+	if args.Filter != nil {
+		if args.Filter.IDs != nil {
+			for _, v := range *args.Filter.IDs {
+				id, err := parseGraphQLID("ids", v)
+				if err != nil {
+					return nil, newGraphQLError(err)
+				}
+				filter.IDs = append(filter.IDs, id)
+			}
+		}
+		if args.Filter.Query != nil {
+			filter.Query = *args.Filter.Query
+		}
+	}
+	if err := model.Validate(filter); err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	// one more TODO is read to tell whether the next page exists
+	todos, versions, err := r.todo.svc.FindTODOs(ctx, filter, request.PrevID, request.Size+1)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	conn := &todoConnectionResolver{svc: r.todo.svc, filter: filter}
+	if int64(len(todos)) > request.Size {
+		todos, conn.hasNextPage = todos[:request.Size], true
+	}
+	conn.nodes = newTODOBatch(r.todo.svc, todos, versions)
+	return conn, nil
+}
+
+func (r *graphqlResolver) CreateTODO(ctx context.Context, args struct {
+	Input struct {
+		Subject     string
+		Description *string
+	}
+}) (*todoResolver, error) {
+	request := &model.CreateTODORequest{Subject: args.Input.Subject}
+	if args.Input.Description != nil {
+		request.Description = *args.Input.Description
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	response, err := r.todo.Create(ctx, request)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	return newTODOBatch(r.todo.svc, []*model.TODO{response.TODO}, map[int64]int64{response.TODO.ID: response.Version})[0], nil
+}
+
+func (r *graphqlResolver) UpdateTODO(ctx context.Context, args struct {
+	Input struct {
+		ID          graphql.ID
+		Subject     string
+		Description *string
+		Version     *int32
+	}
+}) (*todoResolver, error) {
+	id, err := parseGraphQLID("id", args.Input.ID)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	request := &model.UpdateTODORequest{ID: int(id), Subject: args.Input.Subject}
+	if args.Input.Description != nil {
+		request.Description = *args.Input.Description
+	}
+	if args.Input.Version != nil {
+		version := int64(*args.Input.Version)
+		request.Version = &version
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	response, err := r.todo.Update(ctx, request)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	return newTODOBatch(r.todo.svc, []*model.TODO{response.TODO}, map[int64]int64{response.TODO.ID: response.Version})[0], nil
+}
+
+func (r *graphqlResolver) DeleteTODOs(ctx context.Context, args struct{ IDs []graphql.ID }) ([]graphql.ID, error) {
+	request := &model.DeleteTODORequest{}
+	for _, v := range args.IDs {
+		id, err := parseGraphQLID("ids", v)
+		if err != nil {
+			return nil, newGraphQLError(err)
+		}
+		request.IDs = append(request.IDs, id)
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	// the ids which exist are reported as deleted, in one transaction with
+	// the deletion
+	var deleted []graphql.ID
+	err := r.todo.svc.RunInTx(ctx, func(ctx context.Context) error {
+		todos, _, err := r.todo.svc.FindTODOs(ctx, &model.TODOFilter{IDs: request.IDs}, 0, int64(len(request.IDs)))
+		if err != nil {
+			return err
+		}
+		if _, err := r.todo.Delete(ctx, request); err != nil {
+			return err
+		}
+		deleted = make([]graphql.ID, len(todos))
+		for i, t := range todos {
+			deleted[i] = graphqlID(t.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	return deleted, nil
+}
+
+// A todoConnectionResolver resolves a page of TODOs.
+type todoConnectionResolver struct {
+	svc         *service.TODOService
+	filter      *model.TODOFilter
+	nodes       []*todoResolver
+	hasNextPage bool
+}
+
+func (r *todoConnectionResolver) Nodes() []*todoResolver {
+	return r.nodes
+}
+
+// TotalCount counts the TODOs only when it is selected.
+func (r *todoConnectionResolver) TotalCount(ctx context.Context) (int32, error) {
+	n, err := r.svc.CountTODOsMatching(ctx, r.filter)
+	if err != nil {
+		return 0, newGraphQLError(err)
+	}
+	return int32(n), nil
+}
+
+func (r *todoConnectionResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *todoConnectionResolver) EndCursor() *graphql.ID {
+	if len(r.nodes) == 0 {
+		return nil
+	}
+	id := graphqlID(r.nodes[len(r.nodes)-1].t.ID)
+	return &id
+}
+
+// A todoResolver resolves a TODO. Its relations are loaded for its whole
+// batch at once.
+type todoResolver struct {
+	t       *model.TODO
+	version int64
+	batch   *todoBatch
+}
+
+func (r *todoResolver) ID() graphql.ID {
+	return graphqlID(r.t.ID)
+}
+
+func (r *todoResolver) Subject() string {
+	return r.t.Subject
+}
+
+func (r *todoResolver) Description() string {
+	return r.t.Description
+}
+
+func (r *todoResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.t.CreatedAt}
+}
+
+func (r *todoResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.t.UpdatedAt}
+}
+
+func (r *todoResolver) Version() int32 {
+	return int32(r.version)
+}
+
+func (r *todoResolver) ETag() string {
+	return todoETag(r.t.ID, r.version)
+}
+
+func (r *todoResolver) Group(ctx context.Context) (string, error) {
+	outlines, err := r.batch.loadOutlines(ctx)
+	if err != nil {
+		return "", newGraphQLError(err)
+	}
+	return outlines[r.t.ID].Group, nil
+}
+
+func (r *todoResolver) Parent(ctx context.Context) (*todoResolver, error) {
+	parents, err := r.batch.loadParents(ctx)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	return parents[r.t.ID], nil
+}
+
+func (r *todoResolver) Children(ctx context.Context) ([]*todoResolver, error) {
+	children, err := r.batch.loadChildren(ctx)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+	if c := children[r.t.ID]; c != nil {
+		return c, nil
+	}
+	return []*todoResolver{}, nil
+}
+
+// A todoBatch loads the relations of the TODOs resolved together, such as the
+// nodes of a page, in one statement per relation instead of one per TODO. The
+// related TODOs form the next batch, so that nested selections are loaded a
+// level at a time.
+type todoBatch struct {
+	svc   *service.TODOService
+	todos []*todoResolver
+
+	outlinesOnce sync.Once
+	outlines     map[int64]model.TODOOutline
+	outlinesErr  error
+
+	parentsOnce sync.Once
+	parents     map[int64]*todoResolver
+	parentsErr  error
+
+	childrenOnce sync.Once
+	children     map[int64][]*todoResolver
+	childrenErr  error
+}
+
+// newTODOBatch returns the resolvers of the TODOs in a new batch.
+func newTODOBatch(svc *service.TODOService, todos []*model.TODO, versions map[int64]int64) []*todoResolver {
+	b := &todoBatch{svc: svc}
+	b.todos = make([]*todoResolver, len(todos))
+	for i, t := range todos {
+		b.todos[i] = &todoResolver{t: t, version: versions[t.ID], batch: b}
+	}
+	return b.todos
+}
+
+func (b *todoBatch) ids() []int64 {
+	ids := make([]int64, len(b.todos))
+	for i, r := range b.todos {
+		ids[i] = r.t.ID
+	}
+	return ids
+}
+
+// loadOutlines loads the outlines of the batch, which are resolved
+// concurrently.
+func (b *todoBatch) loadOutlines(ctx context.Context) (map[int64]model.TODOOutline, error) {
+	b.outlinesOnce.Do(func() {
+		b.outlines, b.outlinesErr = b.svc.ReadTODOOutlinesOf(ctx, b.ids())
+	})
+	return b.outlines, b.outlinesErr
+}
+
+// loadParents loads the parents of the batch keyed by the ids of their
+// children.
+func (b *todoBatch) loadParents(ctx context.Context) (map[int64]*todoResolver, error) {
+	b.parentsOnce.Do(func() {
+		outlines, err := b.loadOutlines(ctx)
+		if err != nil {
+			b.parentsErr = err
+			return
+		}
+
+		var parentIDs []int64
+		seen := make(map[int64]bool)
+		for _, o := range outlines {
+			if o.ParentID != 0 && !seen[o.ParentID] {
+				seen[o.ParentID] = true
+				parentIDs = append(parentIDs, o.ParentID)
+			}
+		}
+		b.parents = make(map[int64]*todoResolver)
+		if len(parentIDs) == 0 {
+			return
+		}
+
+		todos, versions, err := b.svc.FindTODOs(ctx, &model.TODOFilter{IDs: parentIDs}, 0, int64(len(parentIDs)))
+		if err != nil {
+			b.parentsErr = err
+			return
+		}
+		byID := make(map[int64]*todoResolver, len(todos))
+		for _, p := range newTODOBatch(b.svc, todos, versions) {
+			byID[p.t.ID] = p
+		}
+		for id, o := range outlines {
+			if p, ok := byID[o.ParentID]; ok {
+				b.parents[id] = p
+			}
+		}
+	})
+	return b.parents, b.parentsErr
+}
+
+// loadChildren loads the children of the batch keyed by the ids of their
+// parents.
+func (b *todoBatch) loadChildren(ctx context.Context) (map[int64][]*todoResolver, error) {
+	b.childrenOnce.Do(func() {
+		children, versions, err := b.svc.ReadTODOChildren(ctx, b.ids())
+		if err != nil {
+			b.childrenErr = err
+			return
+		}
+
+		var (
+			all      []*model.TODO
+			parentOf = make(map[int64]int64)
+		)
+		for parentID, c := range children {
+			for _, t := range c {
+				all = append(all, t)
+				parentOf[t.ID] = parentID
+			}
+		}
+		b.children = make(map[int64][]*todoResolver)
+		for _, c := range newTODOBatch(b.svc, all, versions) {
+			b.children[parentOf[c.t.ID]] = append(b.children[parentOf[c.t.ID]], c)
+		}
+	})
+	return b.children, b.childrenErr
+}
+
+func graphqlID(id int64) graphql.ID {
+	return graphql.ID(strconv.FormatInt(id, 10))
+}
+
+// parseGraphQLID returns the TODO id of the argument name.
+func parseGraphQLID(name string, v graphql.ID) (int64, error) {
+	id, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil || id < 1 {
+		return 0, model.ErrValidation{
+			Message:       "request has invalid fields",
+			InvalidParams: []*model.InvalidParam{{Name: name, Reason: "must be a positive integer"}},
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,411 @@
+package handler_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/mattn/go-sqlite3"
+)
+
+// A graphqlResponse expresses a GraphQL response with the problems of its
+// errors.
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code          string                `json:"code"`
+			InvalidParams []*model.InvalidParam `json:"invalid_params"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// codes returns the codes of the errors, followed by the names of their
+// invalid params.
+func (res *graphqlResponse) codes() []string {
+	var codes []string
+	for _, e := range res.Errors {
+		code := e.Extensions.Code
+		for _, p := range e.Extensions.InvalidParams {
+			code += " " + p.Name
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestGraphQLTODOs(t *testing.T) {
+	t.Parallel()
+
+	const query = `query($prevId: ID, $size: Int = 5, $filter: TODOFilter) {
+		todos(prevId: $prevId, size: $size, filter: $filter) {
+			nodes { id }
+			totalCount
+			hasNextPage
+			endCursor
+		}
+	}`
+
+	cases := map[string]struct {
+		variables string
+		// data is the todos expected, and codes the codes of the errors
+		data  string
+		codes []string
+	}{
+		"First page": {
+			variables: `{"size": 3}`,
+			data:      `{"nodes": [{"id": "7"}, {"id": "6"}, {"id": "5"}], "totalCount": 7, "hasNextPage": true, "endCursor": "5"}`,
+		},
+		"Next page": {
+			variables: `{"prevId": "5", "size": 3}`,
+			data:      `{"nodes": [{"id": "4"}, {"id": "3"}, {"id": "2"}], "totalCount": 7, "hasNextPage": true, "endCursor": "2"}`,
+		},
+		"Last page": {
+			variables: `{"prevId": "2", "size": 3}`,
+			data:      `{"nodes": [{"id": "1"}], "totalCount": 7, "hasNextPage": false, "endCursor": "1"}`,
+		},
+		"Last page of the size": {
+			variables: `{"prevId": "3", "size": 2}`,
+			data:      `{"nodes": [{"id": "2"}, {"id": "1"}], "totalCount": 7, "hasNextPage": false, "endCursor": "1"}`,
+		},
+		"Empty page": {
+			variables: `{"prevId": "1"}`,
+			data:      `{"nodes": [], "totalCount": 7, "hasNextPage": false, "endCursor": null}`,
+		},
+		"Default size": {
+			variables: `{}`,
+			data:      `{"nodes": [{"id": "7"}, {"id": "6"}, {"id": "5"}, {"id": "4"}, {"id": "3"}], "totalCount": 7, "hasNextPage": true, "endCursor": "3"}`,
+		},
+		"Filter": {
+			variables: `{"size": 1, "filter": {"ids": ["2", "4", "6"], "query": "EVEN"}}`,
+			data:      `{"nodes": [{"id": "6"}], "totalCount": 3, "hasNextPage": true, "endCursor": "6"}`,
+		},
+		"Invalid size": {
+			variables: `{"size": 101}`,
+			codes:     []string{"validation_failed size"},
+		},
+		"Invalid prevId": {
+			variables: `{"prevId": "a"}`,
+			codes:     []string{"validation_failed prevId"},
+		},
+		"Invalid filter": {
+			variables: `{"filter": {"ids": ["0"]}}`,
+			codes:     []string{"validation_failed ids"},
+		},
+	}
+
+	ctx := context.Background()
+	svc := newTestService(t)
+	for _, subject := range []string{"odd", "even", "odd", "even", "odd", "even", "odd"} {
+		if _, err := svc.CreateTODO(ctx, subject, ""); err != nil {
+			t.Fatal("failed to create todo, err =", err)
+		}
+	}
+	h := handler.NewGraphQLHandler(svc)
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res := execGraphQL(t, h, query, c.variables)
+			if strings.Join(res.codes(), ",") != strings.Join(c.codes, ",") {
+				t.Fatalf("unexpected errors, given = %v, expected = %v", res.codes(), c.codes)
+			}
+			if c.codes != nil {
+				return
+			}
+
+			var data struct {
+				TODOs json.RawMessage `json:"todos"`
+			}
+			if err := json.Unmarshal(res.Data, &data); err != nil {
+				t.Fatal("failed to decode data, err =", err)
+			}
+			assertJSONEqual(t, data.TODOs, c.data)
+		})
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query string
+		data  string
+		codes []string
+		// todos is the number of TODOs after the mutation
+		todos int64
+	}{
+		"Create": {
+			query: `mutation { createTODO(input: {subject: "c", description: "d"}) { id subject description version etag } }`,
+			data:  `{"createTODO": {"id": "3", "subject": "c", "description": "d", "version": 0, "etag": "\"3-0\""}}`,
+			todos: 3,
+		},
+		"Create invalid": {
+			query: `mutation { createTODO(input: {subject: " "}) { id } }`,
+			codes: []string{"validation_failed subject"},
+			todos: 2,
+		},
+		"Update": {
+			query: `mutation { updateTODO(input: {id: "1", subject: "c", version: 0}) { id subject version } }`,
+			data:  `{"updateTODO": {"id": "1", "subject": "c", "version": 1}}`,
+			todos: 2,
+		},
+		"Update without version": {
+			query: `mutation { updateTODO(input: {id: "1", subject: "c"}) { version } }`,
+			data:  `{"updateTODO": {"version": 1}}`,
+			todos: 2,
+		},
+		"Update stale": {
+			query: `mutation { updateTODO(input: {id: "1", subject: "c", version: 1}) { id } }`,
+			codes: []string{"precondition_failed"},
+			todos: 2,
+		},
+		"Update missing": {
+			query: `mutation { updateTODO(input: {id: "9", subject: "c"}) { id } }`,
+			codes: []string{"not_found"},
+			todos: 2,
+		},
+		"Update invalid id": {
+			query: `mutation { updateTODO(input: {id: "x", subject: "c"}) { id } }`,
+			codes: []string{"validation_failed id"},
+			todos: 2,
+		},
+		"Delete": {
+			query: `mutation { deleteTODOs(ids: ["1", "9"]) }`,
+			data:  `{"deleteTODOs": ["1"]}`,
+			todos: 1,
+		},
+		"Delete missing": {
+			query: `mutation { deleteTODOs(ids: ["9"]) }`,
+			codes: []string{"not_found"},
+			todos: 2,
+		},
+		"Delete without ids": {
+			query: `mutation { deleteTODOs(ids: []) }`,
+			codes: []string{"validation_failed ids"},
+			todos: 2,
+		},
+		"Read": {
+			query: `{ a: todo(id: "1") { subject } b: todo(id: "9") { subject } }`,
+			data:  `{"a": {"subject": "a"}, "b": null}`,
+			todos: 2,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			for _, subject := range []string{"a", "b"} {
+				if _, err := svc.CreateTODO(ctx, subject, ""); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+
+			res := execGraphQL(t, handler.NewGraphQLHandler(svc), c.query, "")
+			if strings.Join(res.codes(), ",") != strings.Join(c.codes, ",") {
+				t.Errorf("unexpected errors, given = %v, expected = %v", res.codes(), c.codes)
+			}
+			if c.codes == nil {
+				assertJSONEqual(t, res.Data, c.data)
+			}
+
+			n, err := svc.CountTODOs(ctx)
+			if err != nil {
+				t.Fatal("failed to count todos, err =", err)
+			}
+			if n != c.todos {
+				t.Errorf("unexpected todos, given = %d, expected = %d", n, c.todos)
+			}
+		})
+	}
+}
+
+func TestGraphQLBatches(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query string
+		data  string
+		// queries is the number of the SQL queries of the operation
+		queries int64
+	}{
+		"Nodes": {
+			query:   `{ todos(size: 10) { nodes { id } } }`,
+			data:    `{"todos": {"nodes": [{"id": "6"}, {"id": "5"}, {"id": "4"}, {"id": "3"}, {"id": "2"}, {"id": "1"}]}}`,
+			queries: 1,
+		},
+		"Groups": {
+			query:   `{ todos(size: 10) { nodes { group } } }`,
+			data:    `{"todos": {"nodes": [{"group": "g2"}, {"group": "g2"}, {"group": "g1"}, {"group": "g1"}, {"group": "g1"}, {"group": "g1"}]}}`,
+			queries: 2,
+		},
+		"Parents": {
+			query:   `{ todos(size: 10) { nodes { parent { id } } } }`,
+			data:    `{"todos": {"nodes": [{"parent": {"id": "5"}}, {"parent": null}, {"parent": {"id": "2"}}, {"parent": {"id": "1"}}, {"parent": {"id": "1"}}, {"parent": null}]}}`,
+			queries: 3,
+		},
+		"Groups and parents": {
+			query: `{ todos(size: 10) { nodes { group parent { group } } } }`,
+			data: `{"todos": {"nodes": [{"group": "g2", "parent": {"group": "g2"}}, {"group": "g2", "parent": null}, {"group": "g1", "parent": {"group": "g1"}},` +
+				` {"group": "g1", "parent": {"group": "g1"}}, {"group": "g1", "parent": {"group": "g1"}}, {"group": "g1", "parent": null}]}}`,
+			queries: 4,
+		},
+		"Children": {
+			query: `{ todos(size: 10) { nodes { children { id children { id } } } } }`,
+			data: `{"todos": {"nodes": [{"children": []}, {"children": [{"id": "6", "children": []}]}, {"children": []},` +
+				` {"children": []}, {"children": [{"id": "4", "children": []}]}, {"children": [{"id": "2", "children": [{"id": "4"}]}, {"id": "3", "children": []}]}]}}`,
+			queries: 3,
+		},
+		"Grandparents of children": {
+			query:   `{ todo(id: "1") { children { children { parent { parent { id } } } } } }`,
+			data:    `{"todo": {"children": [{"children": [{"parent": {"parent": {"id": "1"}}}]}, {"children": []}]}}`,
+			queries: 7,
+		},
+	}
+
+	ctx := context.Background()
+	svc, queries := newCountingService(t)
+	outlines := []struct {
+		subject string
+		outline model.TODOOutline
+	}{
+		{subject: "a", outline: model.TODOOutline{Group: "g1"}},
+		{subject: "b", outline: model.TODOOutline{Group: "g1", ParentID: 1}},
+		{subject: "c", outline: model.TODOOutline{Group: "g1", ParentID: 1}},
+		{subject: "d", outline: model.TODOOutline{Group: "g1", ParentID: 2}},
+		{subject: "e", outline: model.TODOOutline{Group: "g2"}},
+		{subject: "f", outline: model.TODOOutline{Group: "g2", ParentID: 5}},
+	}
+	for _, o := range outlines {
+		if _, err := svc.CreateTODOInOutline(ctx, o.subject, "", o.outline); err != nil {
+			t.Fatal("failed to create todo, err =", err)
+		}
+	}
+	h := handler.NewGraphQLHandler(svc)
+
+	// the cases run one by one to count the queries of each
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			before := atomic.LoadInt64(queries)
+			res := execGraphQL(t, h, c.query, "")
+			n := atomic.LoadInt64(queries) - before
+
+			if res.Errors != nil {
+				t.Fatalf("unexpected errors, given = %v, expected none", res.codes())
+			}
+			assertJSONEqual(t, res.Data, c.data)
+			if n != c.queries {
+				t.Errorf("unexpected queries, given = %d, expected = %d", n, c.queries)
+			}
+		})
+	}
+}
+
+func execGraphQL(t *testing.T, h http.Handler, query, variables string) *graphqlResponse {
+	t.Helper()
+
+	body := map[string]interface{}{"query": query}
+	if variables != "" {
+		body["variables"] = json.RawMessage(variables)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal("failed to encode request, err =", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(b)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var res graphqlResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal("failed to decode response, err =", err)
+	}
+	return &res
+}
+
+func assertJSONEqual(t *testing.T, given json.RawMessage, expected string) {
+	t.Helper()
+
+	var g, e interface{}
+	if err := json.Unmarshal(given, &g); err != nil {
+		t.Fatal("failed to decode given JSON, err =", err)
+	}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatal("failed to decode expected JSON, err =", err)
+	}
+	gb, _ := json.Marshal(g)
+	eb, _ := json.Marshal(e)
+	if string(gb) != string(eb) {
+		t.Errorf("unexpected JSON, given = %s, expected = %s", gb, eb)
+	}
+}
+
+// newCountingService returns the TODOService of a database which counts its
+// queries.
+func newCountingService(t *testing.T) (*service.TODOService, *int64) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	todoDB, err := db.NewDB(path)
+	if err != nil {
+		t.Fatal("failed to create db, err =", err)
+	}
+	todoDB.Close()
+
+	c := &countingConnector{dsn: path + "?_txlock=immediate"}
+	counted := sql.OpenDB(c)
+	t.Cleanup(func() { counted.Close() })
+	return service.NewTODOService(counted), &c.queries
+}
+
+// A countingConnector connects to go-sqlite3 counting the queries.
+type countingConnector struct {
+	dsn     string
+	queries int64
+}
+
+func (c *countingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), queries: &c.queries}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type countingConn struct {
+	*sqlite3.SQLiteConn
+	queries *int64
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddInt64(c.queries, 1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/pb"
+	"github.com/TechBowl-japan/go-stations/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// listPageSize is the number of TODOs read at once by ListTODOs.
+const listPageSize = 100
+
+// A TODOGRPCServer implements the gRPC service of TODOs by the handlers of the
+// REST API.
+type TODOGRPCServer struct {
+	pb.UnimplementedTODOServiceServer
+	todo *TODOHandler
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTODOGRPCServer returns TODOGRPCServer based pb.TODOServiceServer.
+func NewTODOGRPCServer(svc *service.TODOService) *TODOGRPCServer {
+	return &TODOGRPCServer{
+		todo: NewTODOHandler(svc),
+		done: make(chan struct{}),
+	}
+}
+
+// Close ends the WatchTODOs streams being served, which grpc.Server.GracefulStop
+// waits for.
+func (s *TODOGRPCServer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *TODOGRPCServer) CreateTODO(ctx context.Context, req *pb.CreateTODORequest) (*pb.CreateTODOResponse, error) {
+	request := &model.CreateTODORequest{Subject: req.Subject, Description: req.Description}
+	if err := model.Validate(request); err != nil {
+		return nil, newGRPCError(err)
+	}
+
+	response, err := s.todo.Create(ctx, request)
+	if err != nil {
+		return nil, newGRPCError(err)
+	}
+	return &pb.CreateTODOResponse{Todo: todoProto(response.TODO, response.Version)}, nil
+}
+
+func (s *TODOGRPCServer) ReadTODO(ctx context.Context, req *pb.ReadTODORequest) (*pb.ReadTODOResponse, error) {
+	request := &model.ReadTODORequest{PrevID: req.PrevId, Size: defaultReadSize}
+	if req.Size != nil {
+		request.Size = *req.Size
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGRPCError(err)
+	}
+
+	response, err := s.todo.Read(ctx, request)
+	if err != nil {
+		return nil, newGRPCError(err)
+	}
+	todos := make([]*pb.TODO, len(response.TODOs))
+	for i, t := range response.TODOs {
+		todos[i] = todoProto(t, response.Versions[t.ID])
+	}
+	return &pb.ReadTODOResponse{Todos: todos}, nil
+}
+
+func (s *TODOGRPCServer) ListTODOs(req *pb.ListTODOsRequest, stream pb.TODOService_ListTODOsServer) error {
+	filter := &model.TODOFilter{IDs: req.Ids, Query: req.Query}
+	if err := model.Validate(filter); err != nil {
+		return newGRPCError(err)
+	}
+
+	ctx := stream.Context()
+	var prevID int64
+	for {
+		todos, versions, err := s.todo.svc.FindTODOs(ctx, filter, prevID, listPageSize)
+		if err != nil {
+			return newGRPCError(err)
+		}
+		for _, t := range todos {
+			if err := stream.Send(todoProto(t, versions[t.ID])); err != nil {
+				return err
+			}
+			prevID = t.ID
+		}
+		if len(todos) < listPageSize {
+			return nil
+		}
+	}
+}
+
+func (s *TODOGRPCServer) UpdateTODO(ctx context.Context, req *pb.UpdateTODORequest) (*pb.UpdateTODOResponse, error) {
+	request := &model.UpdateTODORequest{
+		ID:          int(req.Id),
+		Subject:     req.Subject,
+		Description: req.Description,
+		Version:     req.Version,
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, newGRPCError(err)
+	}
+
+	response, err := s.todo.Update(ctx, request)
+	if err != nil {
+		return nil, newGRPCError(err)
+	}
+	return &pb.UpdateTODOResponse{Todo: todoProto(response.TODO, response.Version)}, nil
+}
+
+func (s *TODOGRPCServer) DeleteTODO(ctx context.Context, req *pb.DeleteTODORequest) (*pb.DeleteTODOResponse, error) {
+	request := &model.DeleteTODORequest{IDs: req.Ids}
+	if err := model.Validate(request); err != nil {
+		return nil, newGRPCError(err)
+	}
+
+	if _, err := s.todo.Delete(ctx, request); err != nil {
+		return nil, newGRPCError(err)
+	}
+	return &pb.DeleteTODOResponse{}, nil
+}
+
+func (s *TODOGRPCServer) WatchTODOs(req *pb.WatchTODOsRequest, stream pb.TODOService_WatchTODOsServer) error {
+	request := &model.ReadEventsRequest{Types: req.Types, TODOIDs: req.TodoIds}
+	if req.LastEventId != nil {
+		request.LastEventID = *req.LastEventId
+	}
+	if err := model.Validate(request); err != nil {
+		return newGRPCError(err)
+	}
+
+	// subscribe before reading the log so that no event is missed in between
+	notified, cancel := s.todo.svc.SubscribeEvents()
+	defer cancel()
+
+	ctx := stream.Context()
+	first, last, err := s.todo.svc.EventLogBounds(ctx)
+	if err != nil {
+		return newGRPCError(err)
+	}
+
+	cursor := request.LastEventID
+	if req.LastEventId == nil {
+		cursor = last
+	} else if cursor+1 < first {
+		return status.Error(codes.OutOfRange, "events after the last event id are no longer kept")
+	}
+
+	for {
+		for {
+			events, err := s.todo.svc.ReadEvents(ctx, request, cursor, eventPageSize)
+			if err != nil {
+				return newGRPCError(err)
+			}
+			for _, e := range events {
+				if err := stream.Send(todoEventProto(e)); err != nil {
+					return err
+				}
+				cursor = e.ID
+			}
+			if len(events) < eventPageSize {
+				break
+			}
+		}
+
+		select {
+		case <-notified:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			// clients resume from the last event received on another server
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+// newGRPCError logs err and returns the status of its problem. The code of
+// the problem is the reason of the ErrorInfo in the details, followed by the
+// invalid params as a BadRequest.
+func newGRPCError(err error) error {
+	log.Println(err)
+
+	p := NewProblem(err)
+	code := codes.Internal
+	switch p.Code {
+	case "not_found":
+		code = codes.NotFound
+	case "validation_failed":
+		code = codes.InvalidArgument
+	case "unauthorized":
+		code = codes.Unauthenticated
+	case "conflict":
+		code = codes.Aborted
+	case "precondition_failed":
+		code = codes.FailedPrecondition
+	}
+
+	msg := p.Detail
+	if msg == "" {
+		msg = p.Title
+	}
+	st := status.New(code, msg)
+
+	info := &errdetails.ErrorInfo{Reason: p.Code, Domain: "todo"}
+	var detailed *status.Status
+	if len(p.InvalidParams) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(p.InvalidParams))
+		for i, param := range p.InvalidParams {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: param.Name, Description: param.Reason}
+		}
+		detailed, err = st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	} else {
+		detailed, err = st.WithDetails(info)
+	}
+	if err != nil {
+		log.Println(err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func todoProto(t *model.TODO, version int64) *pb.TODO {
+	return &pb.TODO{
+		Id:          t.ID,
+		Subject:     t.Subject,
+		Description: t.Description,
+		CreatedAt:   timestamppb.New(t.CreatedAt),
+		UpdatedAt:   timestamppb.New(t.UpdatedAt),
+		Version:     version,
+	}
+}
+
+func todoEventProto(e *model.TODOEvent) *pb.TODOEvent {
+	event := &pb.TODOEvent{
+		Id:        e.ID,
+		Type:      e.Type,
+		TodoId:    e.TODOID,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+	if e.TODO != nil {
+		// the event log does not keep versions
+		event.Todo = todoProto(e.TODO, 0)
+	}
+	return event
+}
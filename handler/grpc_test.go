@@ -0,0 +1,482 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/pb"
+	"github.com/TechBowl-japan/go-stations/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCTODO(t *testing.T) {
+	t.Parallel()
+
+	version := func(v int64) *int64 { return &v }
+	cases := map[string]struct {
+		call func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error)
+		code codes.Code
+		// reason is the problem code in ErrorInfo, and fields the ones in
+		// BadRequest
+		reason string
+		fields []string
+		// todos are the subjects and versions of the TODOs after the call
+		todos []string
+	}{
+		"Create": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.CreateTODO(ctx, &pb.CreateTODORequest{Subject: "b"})
+			},
+			todos: []string{"b 0", "a 0"},
+		},
+		"Create invalid": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.CreateTODO(ctx, &pb.CreateTODORequest{Subject: " "})
+			},
+			code:   codes.InvalidArgument,
+			reason: "validation_failed",
+			fields: []string{"subject"},
+			todos:  []string{"a 0"},
+		},
+		"Read": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				res, err := client.ReadTODO(ctx, &pb.ReadTODORequest{Size: version(1)})
+				if err == nil && (len(res.Todos) != 1 || res.Todos[0].Subject != "a") {
+					return nil, fmt.Errorf("unexpected todos, given = %v", res.Todos)
+				}
+				return res, err
+			},
+			todos: []string{"a 0"},
+		},
+		"Read invalid": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.ReadTODO(ctx, &pb.ReadTODORequest{Size: version(101)})
+			},
+			code:   codes.InvalidArgument,
+			reason: "validation_failed",
+			fields: []string{"size"},
+			todos:  []string{"a 0"},
+		},
+		"Update": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.UpdateTODO(ctx, &pb.UpdateTODORequest{Id: 1, Subject: "b", Version: version(0)})
+			},
+			todos: []string{"b 1"},
+		},
+		"Update without version": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.UpdateTODO(ctx, &pb.UpdateTODORequest{Id: 1, Subject: "b"})
+			},
+			todos: []string{"b 1"},
+		},
+		"Update stale": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.UpdateTODO(ctx, &pb.UpdateTODORequest{Id: 1, Subject: "b", Version: version(1)})
+			},
+			code:   codes.FailedPrecondition,
+			reason: "precondition_failed",
+			todos:  []string{"a 0"},
+		},
+		"Update missing": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.UpdateTODO(ctx, &pb.UpdateTODORequest{Id: 2, Subject: "b"})
+			},
+			code:   codes.NotFound,
+			reason: "not_found",
+			todos:  []string{"a 0"},
+		},
+		"Delete": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.DeleteTODO(ctx, &pb.DeleteTODORequest{Ids: []int64{1}})
+			},
+		},
+		"Delete missing": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.DeleteTODO(ctx, &pb.DeleteTODORequest{Ids: []int64{2}})
+			},
+			code:   codes.NotFound,
+			reason: "not_found",
+			todos:  []string{"a 0"},
+		},
+		"Delete without ids": {
+			call: func(ctx context.Context, client pb.TODOServiceClient) (interface{}, error) {
+				return client.DeleteTODO(ctx, &pb.DeleteTODORequest{})
+			},
+			code:   codes.InvalidArgument,
+			reason: "validation_failed",
+			fields: []string{"ids"},
+			todos:  []string{"a 0"},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			client := dialTestGRPC(t, handler.NewTODOGRPCServer(svc))
+
+			_, err := c.call(ctx, client)
+			st := status.Convert(err)
+			if st.Code() != c.code {
+				t.Fatalf("unexpected code, given = %v, expected = %v, err = %v", st.Code(), c.code, err)
+			}
+			if c.code != codes.OK {
+				reason, fields := grpcErrorDetails(st)
+				if reason != c.reason || strings.Join(fields, ",") != strings.Join(c.fields, ",") {
+					t.Errorf("unexpected details, given = %s %v, expected = %s %v", reason, fields, c.reason, c.fields)
+				}
+			}
+
+			todos, versions, err := svc.ReadTODOWithVersions(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			var given []string
+			for _, td := range todos {
+				given = append(given, fmt.Sprintf("%s %d", td.Subject, versions[td.ID]))
+			}
+			if strings.Join(given, ",") != strings.Join(c.todos, ",") {
+				t.Errorf("unexpected todos, given = %q, expected = %q", given, c.todos)
+			}
+		})
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	t.Parallel()
+
+	user, pass := os.Getenv("BASIC_AUTH_USER_ID"), os.Getenv("BASIC_AUTH_PASSWORD")
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+
+	cases := map[string]struct {
+		authorization string
+		code          codes.Code
+	}{
+		"Authorized":          {authorization: basic(user, pass), code: codes.OK},
+		"Wrong user":          {authorization: basic(user+"x", pass), code: codes.Unauthenticated},
+		"Wrong password":      {authorization: basic(user, pass+"x"), code: codes.Unauthenticated},
+		"Not Basic":           {authorization: "Bearer " + pass, code: codes.Unauthenticated},
+		"Without credentials": {code: codes.Unauthenticated},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if c.authorization != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", c.authorization)
+			}
+			svc := newTestService(t)
+			client := dialTestGRPC(t, handler.NewTODOGRPCServer(svc), middleware.GRPCAuthLayers()...)
+
+			_, err := client.CreateTODO(ctx, &pb.CreateTODORequest{Subject: "a"})
+			if code := status.Code(err); code != c.code {
+				t.Errorf("unexpected code of a unary call, given = %v, expected = %v", code, c.code)
+			}
+
+			stream, err := client.ListTODOs(ctx, &pb.ListTODOsRequest{})
+			if err == nil {
+				for err == nil {
+					_, err = stream.Recv()
+				}
+				if err == io.EOF {
+					err = nil
+				}
+			}
+			if code := status.Code(err); code != c.code {
+				t.Errorf("unexpected code of a stream, given = %v, expected = %v", code, c.code)
+			}
+
+			n, err := svc.CountTODOs(ctx)
+			if err != nil {
+				t.Fatal("failed to count todos, err =", err)
+			}
+			var expected int64
+			if c.code == codes.OK {
+				expected = 1
+			}
+			if n != expected {
+				t.Errorf("unexpected todos, given = %d, expected = %d", n, expected)
+			}
+		})
+	}
+}
+
+func TestGRPCListTODOs(t *testing.T) {
+	t.Parallel()
+
+	const n = 150
+
+	ctx := context.Background()
+	svc := newTestService(t)
+	for i := 1; i <= n; i++ {
+		if _, err := svc.CreateTODO(ctx, fmt.Sprintf("todo %d", i), ""); err != nil {
+			t.Fatal("failed to create todo, err =", err)
+		}
+	}
+	client := dialTestGRPC(t, handler.NewTODOGRPCServer(svc))
+
+	descending := func(from, to int64) []int64 {
+		var ids []int64
+		for id := from; id >= to; id-- {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	cases := map[string]struct {
+		request *pb.ListTODOsRequest
+		code    codes.Code
+		ids     []int64
+	}{
+		"All": {
+			request: &pb.ListTODOsRequest{},
+			ids:     descending(n, 1),
+		},
+		"Query": {
+			request: &pb.ListTODOsRequest{Query: "TODO 14"},
+			ids:     append(descending(149, 140), 14),
+		},
+		"IDs": {
+			request: &pb.ListTODOsRequest{Ids: []int64{3, 1, 151}},
+			ids:     []int64{3, 1},
+		},
+		"IDs and query": {
+			request: &pb.ListTODOsRequest{Ids: []int64{101, 11, 2}, Query: "1"},
+			ids:     []int64{101, 11},
+		},
+		"None": {
+			request: &pb.ListTODOsRequest{Query: "done"},
+		},
+		"Too many IDs": {
+			request: &pb.ListTODOsRequest{Ids: descending(n, 50)},
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stream, err := client.ListTODOs(ctx, c.request)
+			if err != nil {
+				t.Fatal("failed to list todos, err =", err)
+			}
+			var ids []int64
+			for {
+				td, err := stream.Recv()
+				if err != nil {
+					if code := status.Code(err); err != io.EOF && code != c.code {
+						t.Fatalf("unexpected code, given = %v, expected = %v, err = %v", code, c.code, err)
+					}
+					break
+				}
+				ids = append(ids, td.Id)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(c.ids) {
+				t.Errorf("unexpected ids, given = %v, expected = %v", ids, c.ids)
+			}
+		})
+	}
+}
+
+func TestGRPCWatchTODOs(t *testing.T) {
+	t.Parallel()
+
+	id := func(v int64) *int64 { return &v }
+	cases := map[string]struct {
+		request *pb.WatchTODOsRequest
+		// dropped is the number of the oldest events no longer kept
+		dropped int
+		code    codes.Code
+		// events are the events received as "<id> <type> <todo id>"
+		events []string
+	}{
+		"Resumed": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(1)},
+			events:  []string{"2 updated 1", "3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Resumed from the start": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(0)},
+			events:  []string{"1 created 1", "2 updated 1", "3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Types": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(0), Types: []string{"created"}},
+			events:  []string{"1 created 1", "3 created 2", "4 created 3"},
+		},
+		"TODO ids": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(0), TodoIds: []int64{2}},
+			events:  []string{"3 created 2", "5 deleted 2"},
+		},
+		"Resumed after the last one dropped": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(2)},
+			dropped: 2,
+			events:  []string{"3 created 2", "4 created 3", "5 deleted 2"},
+		},
+		"Resumed from one dropped": {
+			request: &pb.WatchTODOsRequest{LastEventId: id(1)},
+			dropped: 2,
+			code:    codes.OutOfRange,
+		},
+		"Invalid type": {
+			request: &pb.WatchTODOsRequest{Types: []string{"renamed"}},
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			todoDB := newTestDB(t)
+			svc := service.NewTODOService(todoDB)
+
+			// 3 events: a created and updated, b created
+			a, err := svc.CreateTODO(ctx, "a", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := svc.UpdateTODO(ctx, a.ID, "a2", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			b, err := svc.CreateTODO(ctx, "b", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := todoDB.Exec(`DELETE FROM todo_events WHERE id <= ?`, c.dropped); err != nil {
+				t.Fatal("failed to delete events, err =", err)
+			}
+
+			client := dialTestGRPC(t, handler.NewTODOGRPCServer(svc))
+			stream, err := client.WatchTODOs(ctx, c.request)
+			if err != nil {
+				t.Fatal("failed to watch todos, err =", err)
+			}
+
+			// 2 events while streaming: c created, b deleted
+			if _, err := svc.CreateTODO(ctx, "c", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if err := svc.DeleteTODO(ctx, []int64{b.ID}); err != nil {
+				t.Fatal("failed to delete todo, err =", err)
+			}
+
+			var events []string
+			for len(events) < len(c.events) {
+				e, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("failed to receive event, err = %v, events = %q", err, events)
+				}
+				if (e.Todo == nil) != (e.Type == "deleted") {
+					t.Errorf("unexpected todo, given = %v, expected it unless deleted", e.Todo)
+				}
+				events = append(events, fmt.Sprintf("%d %s %d", e.Id, e.Type, e.TodoId))
+			}
+			if strings.Join(events, ",") != strings.Join(c.events, ",") {
+				t.Errorf("unexpected events, given = %q, expected = %q", events, c.events)
+			}
+			if c.code != codes.OK {
+				_, err := stream.Recv()
+				if code := status.Code(err); code != c.code {
+					t.Errorf("unexpected code, given = %v, expected = %v, err = %v", code, c.code, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCWatchTODOsClose(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc := newTestService(t)
+	if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	s := handler.NewTODOGRPCServer(svc)
+	client := dialTestGRPC(t, s)
+
+	stream, err := client.WatchTODOs(ctx, &pb.WatchTODOsRequest{LastEventId: new(int64)})
+	if err != nil {
+		t.Fatal("failed to watch todos, err =", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal("failed to receive event, err =", err)
+	}
+
+	s.Close()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("unexpected error, given = %v, expected the code %v", err, codes.Unavailable)
+	}
+}
+
+// dialTestGRPC serves s with the options on an in-memory listener, which is
+// stopped with the test, and returns the client of it.
+func dialTestGRPC(t *testing.T, s *handler.TODOGRPCServer, opts ...grpc.ServerOption) pb.TODOServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterTODOServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Cleanup(s.Close)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal("failed to dial, err =", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTODOServiceClient(conn)
+}
+
+// grpcErrorDetails returns the reason of ErrorInfo and the fields of
+// BadRequest in the details of st.
+func grpcErrorDetails(st *status.Status) (string, []string) {
+	var (
+		reason string
+		fields []string
+	)
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	return reason, fields
+}
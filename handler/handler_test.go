@@ -0,0 +1,27 @@
+package handler_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal("failed to create db, err =", err)
+	}
+	t.Cleanup(func() { todoDB.Close() })
+	return todoDB
+}
+
+func newTestService(t *testing.T) *service.TODOService {
+	t.Helper()
+
+	return service.NewTODOService(newTestDB(t))
+}
@@ -27,7 +27,7 @@ func (h *HealthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			log.Println(err)
 		}
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteError(w, r, model.ErrMethodNotAllowed{})
 		return
 	}
 }
@@ -0,0 +1,375 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// ICalendarContentType is the media type of iCalendar files.
+	ICalendarContentType = "text/calendar"
+
+	// iCalendarTimeLayout is the layout of DATE-TIME values in UTC.
+	iCalendarTimeLayout = "20060102T150405Z"
+
+	// exportPageSize is the number of TODOs read at once by the exports.
+	exportPageSize = 100
+)
+
+// An ICalendarHandler implements exporting and importing TODOs as VTODO
+// components of RFC 5545 iCalendar.
+type ICalendarHandler struct {
+	svc *service.TODOService
+}
+
+// NewICalendarHandler returns ICalendarHandler based http.Handler.
+func NewICalendarHandler(svc *service.TODOService) *ICalendarHandler {
+	return &ICalendarHandler{
+		svc: svc,
+	}
+}
+
+// Import handles the endpoint that creates a TODO for every VTODO in the
+// iCalendar data. VTODOs which are not valid TODOs are skipped and reported.
+func (h *ICalendarHandler) Import(ctx context.Context, data []byte) (*model.ImportTODOResponse, error) {
+	todos, err := parseVTODOs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ImportTODOResponse{
+		Imported: make([]*model.TODO, 0, len(todos)),
+		Skipped:  make([]*model.SkippedImport, 0),
+	}
+	err = h.svc.RunInTx(ctx, func(ctx context.Context) error {
+		for i, props := range todos {
+			t, reason := newImportedTODO(props)
+			if reason != "" {
+				response.Skipped = append(response.Skipped, &model.SkippedImport{
+					Index:  i,
+					ID:     props["UID"].value,
+					Reason: reason,
+				})
+				continue
+			}
+
+			created, err := h.svc.CreateTODOAt(ctx, t.Subject, t.Description, t.CreatedAt, t.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			response.Imported = append(response.Imported, created)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// readAll reads every TODO with its version and the time they were changed.
+func readAll(ctx context.Context, svc *service.TODOService) (*model.ReadTODOResponse, error) {
+	response := &model.ReadTODOResponse{
+		TODOs:    make([]*model.TODO, 0),
+		Versions: make(map[int64]int64),
+	}
+	err := svc.RunInReadTx(ctx, func(ctx context.Context) error {
+		var prevID int64
+		for {
+			todos, versions, err := svc.ReadTODOWithVersions(ctx, prevID, exportPageSize)
+			if err != nil {
+				return err
+			}
+			response.TODOs = append(response.TODOs, todos...)
+			for id, v := range versions {
+				response.Versions[id] = v
+			}
+			if len(todos) < exportPageSize {
+				break
+			}
+			prevID = todos[len(todos)-1].ID
+		}
+
+		lastModified, err := svc.LastModified(ctx)
+		response.LastModified = lastModified
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (h *ICalendarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		response, err := readAll(ctx, h.svc)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		if checkNotModified(w, r, listETag(response, ICalendarContentType), response.LastModified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", ICalendarContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="todos.ics"`)
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := w.Write(encodeVTODOs(response)); err != nil {
+			log.Println(err)
+		}
+
+	case http.MethodPost:
+		w.Header().Add("Vary", "Accept")
+		c, err := negotiate(r.Header.Get("Accept"), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != ICalendarContentType {
+				WriteError(w, r, model.ErrUnsupportedMediaType{})
+				return
+			}
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, model.ErrValidation{Message: err.Error()})
+			return
+		}
+
+		response, err := h.Import(ctx, data)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		writeResponse(w, c, http.StatusOK, response)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// encodeVTODOs returns the TODOs as an iCalendar object of VTODO components.
+func encodeVTODOs(response *model.ReadTODOResponse) []byte {
+	return encodeICalendar(func(b *bytes.Buffer) {
+		for _, t := range response.TODOs {
+			writeVTODO(b, t, response.Versions[t.ID], fmt.Sprintf("todo-%d@go-stations", t.ID))
+		}
+	})
+}
+
+// encodeICalendar returns the iCalendar object of the components written by
+// fn.
+func encodeICalendar(fn func(b *bytes.Buffer)) []byte {
+	var b bytes.Buffer
+	writeICalendarLine(&b, "BEGIN", "VCALENDAR")
+	writeICalendarLine(&b, "VERSION", "2.0")
+	writeICalendarLine(&b, "PRODID", "-//TechBowl-japan//go-stations//EN")
+	fn(&b)
+	writeICalendarLine(&b, "END", "VCALENDAR")
+	return b.Bytes()
+}
+
+// writeVTODO writes the VTODO component of the TODO at the version.
+func writeVTODO(b *bytes.Buffer, t *model.TODO, version int64, uid string) {
+	writeICalendarLine(b, "BEGIN", "VTODO")
+	writeICalendarLine(b, "UID", escapeICalendarText(uid))
+	// DTSTAMP equals LAST-MODIFIED in objects without METHOD
+	writeICalendarLine(b, "DTSTAMP", t.UpdatedAt.UTC().Format(iCalendarTimeLayout))
+	writeICalendarLine(b, "CREATED", t.CreatedAt.UTC().Format(iCalendarTimeLayout))
+	writeICalendarLine(b, "LAST-MODIFIED", t.UpdatedAt.UTC().Format(iCalendarTimeLayout))
+	writeICalendarLine(b, "SEQUENCE", fmt.Sprint(version))
+	writeICalendarLine(b, "SUMMARY", escapeICalendarText(t.Subject))
+	if t.Description != "" {
+		writeICalendarLine(b, "DESCRIPTION", escapeICalendarText(t.Description))
+	}
+	writeICalendarLine(b, "END", "VTODO")
+}
+
+// writeICalendarLine writes the content line folded into lines of 75 octets
+// without splitting UTF-8 sequences.
+func writeICalendarLine(b *bytes.Buffer, name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		b.WriteString(line[:i])
+		b.WriteString("\r\n ")
+		line = line[i:]
+		// the leading space of a continuation line is an octet of it
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+var (
+	iCalendarEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	iCalendarUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+func escapeICalendarText(s string) string {
+	return iCalendarEscaper.Replace(s)
+}
+
+// An iCalendarProperty expresses a property of an iCalendar component.
+type iCalendarProperty struct {
+	params map[string]string
+	value  string
+}
+
+// parseVTODOs returns the properties of the VTODO components in data keyed by
+// their names. The properties of the components nested in them are ignored.
+func parseVTODOs(data []byte) ([]map[string]iCalendarProperty, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+
+	var (
+		todos    []map[string]iCalendarProperty
+		stack    []string
+		calendar bool
+	)
+	for n, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, prop, ok := parseICalendarLine(line)
+		if !ok {
+			return nil, model.ErrValidation{Message: fmt.Sprintf("iCalendar content line %d is invalid", n+1)}
+		}
+
+		switch name {
+		case "BEGIN":
+			component := strings.ToUpper(prop.value)
+			stack = append(stack, component)
+			if component == "VCALENDAR" && len(stack) == 1 {
+				calendar = true
+			}
+			if component == "VTODO" && len(stack) == 2 && stack[0] == "VCALENDAR" {
+				todos = append(todos, make(map[string]iCalendarProperty))
+			}
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1] != strings.ToUpper(prop.value) {
+				return nil, model.ErrValidation{Message: fmt.Sprintf("iCalendar content line %d ends %s not begun", n+1, prop.value)}
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 2 && stack[0] == "VCALENDAR" && stack[1] == "VTODO" {
+				todos[len(todos)-1][name] = prop
+			}
+		}
+	}
+
+	if !calendar || len(stack) != 0 {
+		return nil, model.ErrValidation{Message: "request body is not a valid iCalendar object"}
+	}
+	return todos, nil
+}
+
+// parseICalendarLine splits the unfolded content line into its name, its
+// parameters and its value.
+func parseICalendarLine(line string) (string, iCalendarProperty, bool) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return "", iCalendarProperty{}, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := iCalendarProperty{params: make(map[string]string), value: line[colon+1:]}
+	for _, p := range parts[1:] {
+		if i := strings.Index(p, "="); i > 0 {
+			prop.params[strings.ToUpper(p[:i])] = strings.Trim(p[i+1:], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), prop, true
+}
+
+// parseICalendarTime parses DATE-TIME and DATE values. The ones without UTC
+// designator are in the time zone of TZID, or the local time.
+func parseICalendarTime(prop iCalendarProperty) (time.Time, error) {
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	if t, err := time.ParseInLocation(iCalendarTimeLayout, prop.value, time.UTC); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, prop.value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time " + prop.value)
+}
+
+// newImportedTODO returns the TODO of the VTODO properties, or the reason
+// why it cannot be imported.
+func newImportedTODO(props map[string]iCalendarProperty) (*model.TODO, string) {
+	summary, ok := props["SUMMARY"]
+	if !ok {
+		return nil, "SUMMARY is required"
+	}
+
+	req := &model.CreateTODORequest{
+		Subject:     iCalendarUnescaper.Replace(summary.value),
+		Description: iCalendarUnescaper.Replace(props["DESCRIPTION"].value),
+	}
+	if err := model.Validate(req); err != nil {
+		return nil, err.Error()
+	}
+
+	t := &model.TODO{Subject: req.Subject, Description: req.Description, CreatedAt: time.Now()}
+	if prop, ok := props["CREATED"]; ok {
+		created, err := parseICalendarTime(prop)
+		if err != nil {
+			return nil, "CREATED is an " + err.Error()
+		}
+		t.CreatedAt = created
+	}
+	t.UpdatedAt = t.CreatedAt
+	if prop, ok := props["LAST-MODIFIED"]; ok {
+		updated, err := parseICalendarTime(prop)
+		if err != nil {
+			return nil, "LAST-MODIFIED is an " + err.Error()
+		}
+		t.UpdatedAt = updated
+	}
+	return t, ""
+}
@@ -0,0 +1,151 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+)
+
+func TestICalendarImport(t *testing.T) {
+	t.Parallel()
+
+	calendar := func(lines ...string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+	}
+	type imported struct {
+		subject, description string
+		createdAt, updatedAt time.Time
+	}
+
+	cases := map[string]struct {
+		data     string
+		imported []imported
+		skipped  int
+		err      bool
+	}{
+		"Escaped and folded": {
+			data:     calendar("BEGIN:VTODO", `SUMMARY:a\, b\; c\\ d`, `DESCRIPTION:line 1\nli`, " ne 2", "END:VTODO"),
+			imported: []imported{{subject: `a, b; c\ d`, description: "line 1\nline 2"}},
+		},
+		"Times": {
+			data: calendar(
+				"BEGIN:VTODO", "SUMMARY:utc", "CREATED:20200102T030405Z", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:zoned", "CREATED;TZID=America/New_York:20200102T030405", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:date", "CREATED;VALUE=DATE:20200102", "END:VTODO",
+			),
+			imported: []imported{
+				{subject: "utc", createdAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+				{subject: "zoned", createdAt: time.Date(2020, 1, 2, 8, 4, 5, 0, time.UTC)},
+				{subject: "date", createdAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)},
+			},
+		},
+		"LAST-MODIFIED": {
+			data: calendar(
+				"BEGIN:VTODO", "SUMMARY:modified", "CREATED:20200102T030405Z", "LAST-MODIFIED:20200103T000000Z", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:created", "CREATED:20200102T030405Z", "END:VTODO",
+			),
+			imported: []imported{
+				{subject: "modified", createdAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), updatedAt: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+				{subject: "created", createdAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), updatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+		},
+		"Nested components": {
+			data:     calendar("BEGIN:VTODO", "SUMMARY:todo", "BEGIN:VALARM", "DESCRIPTION:alarm", "END:VALARM", "END:VTODO", "BEGIN:VEVENT", "SUMMARY:event", "END:VEVENT"),
+			imported: []imported{{subject: "todo"}},
+		},
+		"Invalid VTODOs skipped": {
+			data:     calendar("BEGIN:VTODO", "DESCRIPTION:no summary", "END:VTODO", "BEGIN:VTODO", "SUMMARY:bad", "CREATED:yesterday", "END:VTODO", "BEGIN:VTODO", "SUMMARY:bad", "LAST-MODIFIED:20201301T000000Z", "END:VTODO", "BEGIN:VTODO", "SUMMARY:ok", "END:VTODO"),
+			imported: []imported{{subject: "ok"}},
+			skipped:  3,
+		},
+		"Not a calendar": {data: "BEGIN:VTODO\r\nSUMMARY:a\r\nEND:VTODO\r\n", err: true},
+		"Unbalanced":     {data: "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VCALENDAR\r\n", err: true},
+		"Invalid line":   {data: calendar("BEGIN:VTODO", "SUMMARY", "END:VTODO"), err: true},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			response, err := handler.NewICalendarHandler(newTestService(t)).Import(context.Background(), []byte(c.data))
+			if c.err {
+				if err == nil {
+					t.Error("unexpected value, given = nil, expected = error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+
+			if len(response.Skipped) != c.skipped {
+				t.Errorf("unexpected skipped, given = %d, expected = %d", len(response.Skipped), c.skipped)
+			}
+			if len(response.Imported) != len(c.imported) {
+				t.Fatalf("unexpected imported, given = %d, expected = %d", len(response.Imported), len(c.imported))
+			}
+			for i, got := range response.Imported {
+				want := c.imported[i]
+				if got.Subject != want.subject || got.Description != want.description {
+					t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, want.subject, want.description)
+				}
+				if !want.createdAt.IsZero() && !got.CreatedAt.Equal(want.createdAt) {
+					t.Errorf("unexpected created_at, given = %s, expected = %s", got.CreatedAt, want.createdAt)
+				}
+				if !want.updatedAt.IsZero() && !got.UpdatedAt.Equal(want.updatedAt) {
+					t.Errorf("unexpected updated_at, given = %s, expected = %s", got.UpdatedAt, want.updatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestICalendarRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := newTestService(t)
+	todos := []struct{ subject, description string }{
+		{subject: "plain"},
+		{subject: `commas, semicolons; and \ backslashes`, description: "line 1\nline 2\r\n\n"},
+		{subject: strings.Repeat("長い件名", 20), description: strings.Repeat("折り返し ", 40)},
+	}
+	for _, td := range todos {
+		if _, err := src.CreateTODO(ctx, td.subject, td.description); err != nil {
+			t.Fatal("failed to create todo, err =", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.NewICalendarHandler(src).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos.ics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status, given = %d, expected = %d", w.Code, http.StatusOK)
+	}
+	for _, line := range strings.Split(w.Body.String(), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("unexpected line longer than 75 octets, given = %q", line)
+		}
+	}
+
+	response, err := handler.NewICalendarHandler(newTestService(t)).Import(ctx, w.Body.Bytes())
+	if err != nil {
+		t.Fatal("failed to import, err =", err)
+	}
+	if len(response.Imported) != len(todos) {
+		t.Fatalf("unexpected imported, given = %d, expected = %d", len(response.Imported), len(todos))
+	}
+	// the export is in the descending order of ids
+	for i, got := range response.Imported {
+		want := todos[len(todos)-1-i]
+		wantDescription := strings.ReplaceAll(want.description, "\r\n", "\n")
+		if got.Subject != want.subject || got.Description != wantDescription {
+			t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, want.subject, wantDescription)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// maxRPCBatch is the number of calls a batch request may have at most.
+const maxRPCBatch = 100
+
+// An rpcMethodNotFoundError is returned for the methods which do not exist.
+type rpcMethodNotFoundError struct {
+	method string
+}
+
+func (e rpcMethodNotFoundError) Error() string {
+	return fmt.Sprintf("method %q does not exist", e.method)
+}
+
+// A JSONRPCHandler implements handling the JSON-RPC 2.0 endpoint. The methods
+// todo.create, todo.read, todo.update and todo.delete take the requests of the
+// REST API as named params.
+type JSONRPCHandler struct {
+	todo *TODOHandler
+}
+
+// NewJSONRPCHandler returns JSONRPCHandler based http.Handler.
+func NewJSONRPCHandler(svc *service.TODOService) *JSONRPCHandler {
+	return &JSONRPCHandler{
+		todo: NewTODOHandler(svc),
+	}
+}
+
+func (h *JSONRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, r, model.ErrValidation{Message: err.Error()})
+		return
+	}
+
+	// errors of the calls are in the response, only the errors of the HTTP
+	// request are problems
+	var response interface{}
+	b = bytes.TrimSpace(b)
+	switch {
+	case !json.Valid(b):
+		response = newRPCErrorResponse(nil, model.RPCParseError, "request body is not valid JSON")
+	case b[0] == '[':
+		var calls []json.RawMessage
+		if err := json.Unmarshal(b, &calls); err != nil {
+			response = newRPCErrorResponse(nil, model.RPCParseError, err.Error())
+			break
+		}
+		if len(calls) == 0 || len(calls) > maxRPCBatch {
+			response = newRPCErrorResponse(nil, model.RPCInvalidRequest, fmt.Sprintf("batch must have 1 to %d calls", maxRPCBatch))
+			break
+		}
+
+		// a batch of only notifications is answered with nothing, not an
+		// empty array
+		var responses []*model.RPCResponse
+		for _, call := range calls {
+			if res := h.call(r.Context(), call); res != nil {
+				responses = append(responses, res)
+			}
+		}
+		if len(responses) > 0 {
+			response = responses
+		}
+	default:
+		if res := h.call(r.Context(), b); res != nil {
+			response = res
+		}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
+
+// call runs the call b and returns its response, nil for notifications.
+func (h *JSONRPCHandler) call(ctx context.Context, b json.RawMessage) *model.RPCResponse {
+	var req model.RPCRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return newRPCErrorResponse(nil, model.RPCInvalidRequest, "request must be an object of the members of JSON-RPC 2.0")
+	}
+	if !validRPCID(req.ID) {
+		return newRPCErrorResponse(nil, model.RPCInvalidRequest, "id must be a string, a number or null")
+	}
+	switch {
+	case req.JSONRPC != "2.0":
+		return newRPCErrorResponse(req.ID, model.RPCInvalidRequest, `jsonrpc must be "2.0"`)
+	case req.Method == "":
+		return newRPCErrorResponse(req.ID, model.RPCInvalidRequest, "method is required")
+	case len(req.Params) > 0 && req.Params[0] != '{' && req.Params[0] != '[' && string(req.Params) != "null":
+		return newRPCErrorResponse(req.ID, model.RPCInvalidRequest, "params must be an object or an array")
+	}
+
+	result, err := h.dispatch(ctx, req.Method, req.Params)
+	if req.ID == nil {
+		if err != nil {
+			log.Println(err)
+		}
+		return nil
+	}
+	if err != nil {
+		return &model.RPCResponse{JSONRPC: "2.0", Error: newRPCError(err), ID: req.ID}
+	}
+	return &model.RPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// dispatch runs the method with the TODOHandler.
+func (h *JSONRPCHandler) dispatch(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "todo.create":
+		var request model.CreateTODORequest
+		if err := unmarshalParams(params, &request); err != nil {
+			return nil, err
+		}
+		response, err := h.todo.Create(ctx, &request)
+		if err != nil {
+			return nil, err
+		}
+		return &model.RPCTODOResult{TODO: response.TODO, Version: response.Version}, nil
+
+	case "todo.read":
+		request := model.ReadTODORequest{Size: defaultReadSize}
+		if err := unmarshalParams(params, &request); err != nil {
+			return nil, err
+		}
+		response, err := h.todo.Read(ctx, &request)
+		if err != nil {
+			return nil, err
+		}
+		return response, nil
+
+	case "todo.update":
+		var request model.UpdateTODORequestV2
+		if err := unmarshalParams(params, &request); err != nil {
+			return nil, err
+		}
+		response, err := h.todo.Update(ctx, newUpdateTODORequest(&request))
+		if err != nil {
+			return nil, err
+		}
+		return &model.RPCTODOResult{TODO: response.TODO, Version: response.Version}, nil
+
+	case "todo.delete":
+		var request model.DeleteTODORequest
+		if err := unmarshalParams(params, &request); err != nil {
+			return nil, err
+		}
+		response, err := h.todo.Delete(ctx, &request)
+		if err != nil {
+			return nil, err
+		}
+		return response, nil
+
+	default:
+		return nil, rpcMethodNotFoundError{method: method}
+	}
+}
+
+// unmarshalParams decodes the named params into v and validates it. Absent
+// params are an empty object.
+func unmarshalParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+	if params[0] != '{' {
+		return model.ErrValidation{Message: "params must be an object"}
+	}
+	return unmarshalRequest(params, v)
+}
+
+// validRPCID reports whether id is absent, a string, a number or null.
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch c := id[0]; {
+	case c == '"', c == '-', '0' <= c && c <= '9':
+		return true
+	default:
+		return string(id) == "null"
+	}
+}
+
+// newRPCError returns the error of err. The failures of methods carry their
+// problem in the data, and the invalid requests of the REST API are invalid
+// params.
+func newRPCError(err error) *model.RPCError {
+	var notFound rpcMethodNotFoundError
+	if errors.As(err, &notFound) {
+		return &model.RPCError{Code: model.RPCMethodNotFound, Message: err.Error()}
+	}
+	log.Println(err)
+
+	p := NewProblem(err)
+	code := model.RPCServerError
+	switch p.Code {
+	case "validation_failed":
+		code = model.RPCInvalidParams
+	case "internal_error":
+		code = model.RPCInternalError
+	}
+
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	return &model.RPCError{Code: code, Message: message, Data: p}
+}
+
+func newRPCErrorResponse(id json.RawMessage, code int, message string) *model.RPCResponse {
+	return &model.RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &model.RPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
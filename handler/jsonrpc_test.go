@@ -0,0 +1,244 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestJSONRPC(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		method string
+		body   string
+		status int
+		// responses are the responses as "<id> <code> <problem code>", whose
+		// code is 0 for results
+		responses []string
+		// todos is the number of TODOs after the request
+		todos int
+	}{
+		"Create": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "b"}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 0"},
+			todos:     2,
+		},
+		"Read": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.read", "params": {"size": 1}, "id": "r"}`,
+			status:    http.StatusOK,
+			responses: []string{`"r" 0`},
+			todos:     1,
+		},
+		"Update": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.update", "params": {"id": 1, "subject": "b", "version": 0}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 0"},
+			todos:     1,
+		},
+		"Update stale": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.update", "params": {"id": 1, "subject": "b", "version": 1}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32000 precondition_failed"},
+			todos:     1,
+		},
+		"Delete": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.delete", "params": {"ids": [1]}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 0"},
+		},
+		"Delete missing": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.delete", "params": {"ids": [2]}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32000 not_found"},
+			todos:     1,
+		},
+		"Null id": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "b"}, "id": null}`,
+			status:    http.StatusOK,
+			responses: []string{"null 0"},
+			todos:     2,
+		},
+		"Notification": {
+			body:   `{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "b"}}`,
+			status: http.StatusNoContent,
+			todos:  2,
+		},
+		"Failed notification": {
+			body:   `{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": ""}}`,
+			status: http.StatusNoContent,
+			todos:  1,
+		},
+		"Batch": {
+			body: `[
+				{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "b"}, "id": 1},
+				{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "c"}},
+				{"jsonrpc": "2.0", "method": "todo.archive", "id": 3},
+				1
+			]`,
+			status:    http.StatusOK,
+			responses: []string{"1 0", "3 -32601", "null -32600"},
+			todos:     3,
+		},
+		"Batch of notifications": {
+			body: `[
+				{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "b"}},
+				{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": "c"}}
+			]`,
+			status: http.StatusNoContent,
+			todos:  3,
+		},
+		"Empty batch": {
+			body:      `[]`,
+			status:    http.StatusOK,
+			responses: []string{"null -32600"},
+			todos:     1,
+		},
+		"Parse error": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.read"`,
+			status:    http.StatusOK,
+			responses: []string{"null -32700"},
+			todos:     1,
+		},
+		"Parse error of a batch": {
+			body:      `[{"jsonrpc": "2.0", "method": "todo.read", "id": 1},`,
+			status:    http.StatusOK,
+			responses: []string{"null -32700"},
+			todos:     1,
+		},
+		"Invalid version": {
+			body:      `{"jsonrpc": "1.0", "method": "todo.read", "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32600"},
+			todos:     1,
+		},
+		"Without method": {
+			body:      `{"jsonrpc": "2.0", "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32600"},
+			todos:     1,
+		},
+		"Invalid params": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.read", "params": 1, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32600"},
+			todos:     1,
+		},
+		"Invalid id": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.read", "id": true}`,
+			status:    http.StatusOK,
+			responses: []string{"null -32600"},
+			todos:     1,
+		},
+		"Not an object": {
+			body:      `"todo.read"`,
+			status:    http.StatusOK,
+			responses: []string{"null -32600"},
+			todos:     1,
+		},
+		"Unknown method": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.archive", "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32601"},
+			todos:     1,
+		},
+		"Invalid param": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.create", "params": {"subject": ""}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32602 validation_failed"},
+			todos:     1,
+		},
+		"Unknown param": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.update", "params": {"id": 1, "subject": "b", "due": "tomorrow"}, "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32602 validation_failed"},
+			todos:     1,
+		},
+		"Positional params": {
+			body:      `{"jsonrpc": "2.0", "method": "todo.create", "params": ["b"], "id": 1}`,
+			status:    http.StatusOK,
+			responses: []string{"1 -32602 validation_failed"},
+			todos:     1,
+		},
+		"GET": {
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+			todos:  1,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			method := c.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, "/rpc", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.NewJSONRPCHandler(svc).ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.status == http.StatusNoContent && w.Body.Len() != 0 {
+				t.Errorf("unexpected body, given = %s, expected none", w.Body)
+			}
+			if c.status == http.StatusOK {
+				var responses []*model.RPCResponse
+				b := bytes.TrimSpace(w.Body.Bytes())
+				if len(b) > 0 && b[0] != '[' {
+					b = append(append([]byte("["), b...), ']')
+				}
+				if err := json.Unmarshal(b, &responses); err != nil {
+					t.Fatal("failed to decode response, err =", err)
+				}
+				var given []string
+				for _, res := range responses {
+					if res.JSONRPC != "2.0" {
+						t.Errorf("unexpected jsonrpc, given = %q, expected = %q", res.JSONRPC, "2.0")
+					}
+					s := string(res.ID) + " 0"
+					if res.Error != nil {
+						s = fmt.Sprintf("%s %d", res.ID, res.Error.Code)
+						if res.Error.Data != nil {
+							s += " " + res.Error.Data.Code
+						}
+					} else if res.Result == nil {
+						t.Errorf("unexpected result, given = nil, expected one for %s", res.ID)
+					}
+					given = append(given, s)
+				}
+				if strings.Join(given, ",") != strings.Join(c.responses, ",") {
+					t.Errorf("unexpected responses, given = %q, expected = %q", given, c.responses)
+				}
+			}
+
+			todos, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if len(todos) != c.todos {
+				t.Errorf("unexpected todos, given = %d, expected = %d", len(todos), c.todos)
+			}
+		})
+	}
+}
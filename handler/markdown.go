@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// MarkdownContentType is the media type of Markdown documents.
+const MarkdownContentType = "text/markdown"
+
+var (
+	markdownHeadingPattern = regexp.MustCompile(`^#{1,6}\s+(.*?)(?:\s+#+)?\s*$`)
+	markdownItemPattern    = regexp.MustCompile(`^([ \t]*)[-*+] \[([ xX])\] (.*)$`)
+	// markdownEscapedItemPattern matches the lines of descriptions which look
+	// like items after the backslashes escaping them.
+	markdownEscapedItemPattern = regexp.MustCompile(`^([ \t]*)(\\*[-*+] \[[ xX]\] .*)$`)
+)
+
+// A MarkdownHandler implements exporting and importing TODOs as Markdown
+// checklists. Headings become the groups of the TODOs below them, nested items
+// their children, and the text indented under an item its description.
+type MarkdownHandler struct {
+	svc *service.TODOService
+}
+
+// NewMarkdownHandler returns MarkdownHandler based http.Handler.
+func NewMarkdownHandler(svc *service.TODOService) *MarkdownHandler {
+	return &MarkdownHandler{
+		svc: svc,
+	}
+}
+
+// A markdownItem expresses a checklist item of a Markdown document.
+type markdownItem struct {
+	indent      int
+	checked     bool
+	subject     string
+	description []string
+	group       string
+	parent      *markdownItem
+}
+
+// Import handles the endpoint that creates a TODO for every unchecked item of
+// the Markdown checklists. Checked items are skipped, and their children are
+// nested in the nearest imported ancestor.
+func (h *MarkdownHandler) Import(ctx context.Context, data []byte) (*model.ImportTODOResponse, error) {
+	items := parseMarkdownChecklist(string(data))
+	if len(items) == 0 {
+		return nil, model.ErrValidation{Message: "request body has no checklist items"}
+	}
+
+	response := &model.ImportTODOResponse{
+		Imported: make([]*model.TODO, 0, len(items)),
+		Skipped:  make([]*model.SkippedImport, 0),
+	}
+	err := h.svc.RunInTx(ctx, func(ctx context.Context) error {
+		ids := make(map[*markdownItem]int64, len(items))
+		for i, item := range items {
+			if item.checked {
+				response.Skipped = append(response.Skipped, &model.SkippedImport{Index: i, Reason: "item is checked"})
+				continue
+			}
+
+			req := &model.CreateTODORequest{Subject: item.subject, Description: dedent(item.description)}
+			if err := model.Validate(req); err != nil {
+				response.Skipped = append(response.Skipped, &model.SkippedImport{Index: i, Reason: err.Error()})
+				continue
+			}
+
+			outline := model.TODOOutline{Group: item.group}
+			for p := item.parent; p != nil && outline.ParentID == 0; p = p.parent {
+				outline.ParentID = ids[p]
+			}
+
+			t, err := h.svc.CreateTODOInOutline(ctx, req.Subject, req.Description, outline)
+			if err != nil {
+				return err
+			}
+			ids[item] = t.ID
+			response.Imported = append(response.Imported, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Export handles the endpoint that renders every TODO as a Markdown checklist.
+func (h *MarkdownHandler) Export(ctx context.Context) (*model.ReadTODOResponse, string, error) {
+	var (
+		response *model.ReadTODOResponse
+		outlines map[int64]model.TODOOutline
+	)
+	err := h.svc.RunInReadTx(ctx, func(ctx context.Context) error {
+		var err error
+		if response, err = readAll(ctx, h.svc); err != nil {
+			return err
+		}
+		outlines, err = h.svc.ReadTODOOutlines(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return response, renderMarkdownChecklist(response.TODOs, outlines), nil
+}
+
+func (h *MarkdownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		response, markdown, err := h.Export(ctx)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		if checkNotModified(w, r, listETag(response, MarkdownContentType), response.LastModified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", MarkdownContentType+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := io.WriteString(w, markdown); err != nil {
+			log.Println(err)
+		}
+
+	case http.MethodPost:
+		w.Header().Add("Vary", "Accept")
+		c, err := negotiate(r.Header.Get("Accept"), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != MarkdownContentType {
+				WriteError(w, r, model.ErrUnsupportedMediaType{})
+				return
+			}
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, model.ErrValidation{Message: err.Error()})
+			return
+		}
+
+		response, err := h.Import(ctx, data)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		writeResponse(w, c, http.StatusOK, response)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// parseMarkdownChecklist returns the checklist items in the document in order.
+// Text indented under an item belongs to it, and other text ends the list.
+func parseMarkdownChecklist(doc string) []*markdownItem {
+	var (
+		items   []*markdownItem
+		stack   []*markdownItem
+		current *markdownItem
+		group   string
+	)
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := markdownHeadingPattern.FindStringSubmatch(line); m != nil {
+			group, stack, current = m[1], nil, nil
+			continue
+		}
+
+		if m := markdownItemPattern.FindStringSubmatch(line); m != nil {
+			indent := indentWidth(m[1])
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+
+			item := &markdownItem{
+				indent:  indent,
+				checked: m[2] != " ",
+				subject: m[3],
+				group:   group,
+			}
+			if len(stack) > 0 {
+				item.parent = stack[len(stack)-1]
+			}
+			stack = append(stack, item)
+			items = append(items, item)
+			current = item
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		if current != nil && (trimmed == "" || indentWidth(line[:len(line)-len(trimmed)]) > current.indent) {
+			current.description = append(current.description, unescapeMarkdownItem(line))
+			continue
+		}
+		stack, current = nil, nil
+	}
+	return items
+}
+
+// escapeMarkdownItem escapes the line of a description which would be read as
+// an item with a backslash, which Markdown renders as the character after it.
+func escapeMarkdownItem(line string) string {
+	if m := markdownEscapedItemPattern.FindStringSubmatch(line); m != nil {
+		return m[1] + `\` + m[2]
+	}
+	return line
+}
+
+// unescapeMarkdownItem removes the backslash added by escapeMarkdownItem.
+func unescapeMarkdownItem(line string) string {
+	if m := markdownEscapedItemPattern.FindStringSubmatch(line); m != nil && strings.HasPrefix(m[2], `\`) {
+		return m[1] + m[2][1:]
+	}
+	return line
+}
+
+// indentWidth returns the width of the white space counting a tab as 4.
+func indentWidth(s string) int {
+	return len(s) + 3*strings.Count(s, "\t")
+}
+
+// dedent joins the lines removing the blank lines around them and the
+// indentation they have in common.
+func dedent(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	common := -1
+	for _, l := range lines {
+		trimmed := strings.TrimLeft(l, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(l) - len(trimmed); common < 0 || n < common {
+			common = n
+		}
+	}
+
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) >= common && common > 0 {
+			l = l[common:]
+		}
+		out[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderMarkdownChecklist renders the TODOs in the order of their ids, under
+// the headings of their groups and nested in their parents.
+func renderMarkdownChecklist(todos []*model.TODO, outlines map[int64]model.TODOOutline) string {
+	sorted := make([]*model.TODO, len(todos))
+	copy(sorted, todos)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	exists := make(map[int64]bool, len(sorted))
+	for _, t := range sorted {
+		exists[t.ID] = true
+	}
+
+	var (
+		groups   []string
+		roots    = make(map[string][]*model.TODO)
+		children = make(map[int64][]*model.TODO)
+	)
+	for _, t := range sorted {
+		o := outlines[t.ID]
+		if o.ParentID != 0 && exists[o.ParentID] {
+			children[o.ParentID] = append(children[o.ParentID], t)
+			continue
+		}
+		if _, ok := roots[o.Group]; !ok {
+			groups = append(groups, o.Group)
+		}
+		roots[o.Group] = append(roots[o.Group], t)
+	}
+	// the TODOs without a group come before the headings
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i] == "" && groups[j] != "" })
+
+	var (
+		b      strings.Builder
+		render func(t *model.TODO, depth int)
+	)
+	render = func(t *model.TODO, depth int) {
+		indent := strings.Repeat("  ", depth)
+		b.WriteString(indent + "- [ ] " + strings.Join(strings.Fields(t.Subject), " ") + "\n")
+		if t.Description != "" {
+			for _, l := range strings.Split(t.Description, "\n") {
+				if l != "" {
+					l = indent + "  " + escapeMarkdownItem(l)
+				}
+				b.WriteString(l + "\n")
+			}
+		}
+		for _, c := range children[t.ID] {
+			render(c, depth+1)
+		}
+	}
+
+	for i, g := range groups {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if g != "" {
+			b.WriteString("## " + g + "\n\n")
+		}
+		for _, t := range roots[g] {
+			render(t, 0)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,165 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestMarkdownImport(t *testing.T) {
+	t.Parallel()
+
+	type todo struct {
+		subject, description, group string
+		// parent is the index of the imported parent, -1 for none
+		parent int
+	}
+	cases := map[string]struct {
+		doc      string
+		imported []todo
+		skipped  int
+		err      bool
+	}{
+		"Items": {
+			doc:      "- [ ] a\n* [ ] b\n+ [ ] c\n",
+			imported: []todo{{subject: "a", parent: -1}, {subject: "b", parent: -1}, {subject: "c", parent: -1}},
+		},
+		"Headings and nesting": {
+			doc: "# Work\n- [ ] a\n  - [ ] b\n    - [ ] c\n  - [ ] d\n## Home\n- [ ] e\n",
+			imported: []todo{
+				{subject: "a", group: "Work", parent: -1},
+				{subject: "b", group: "Work", parent: 0},
+				{subject: "c", group: "Work", parent: 1},
+				{subject: "d", group: "Work", parent: 0},
+				{subject: "e", group: "Home", parent: -1},
+			},
+		},
+		"Checked items": {
+			doc:      "- [ ] a\n  - [x] b\n    - [ ] c\n- [X] d\n",
+			imported: []todo{{subject: "a", parent: -1}, {subject: "c", parent: 0}},
+			skipped:  2,
+		},
+		"Descriptions": {
+			doc:      "- [ ] a\n  line 1\n\n    line 2\n  \\- [ ] not an item\nend of the list\n- [ ] b\r\n\tline\r\n",
+			imported: []todo{{subject: "a", description: "line 1\n\n  line 2\n- [ ] not an item", parent: -1}, {subject: "b", description: "line", parent: -1}},
+		},
+		"No items": {
+			doc: "# Work\n- a\n",
+			err: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			response, err := handler.NewMarkdownHandler(svc).Import(ctx, []byte(c.doc))
+			if c.err {
+				if !errors.As(err, &model.ErrValidation{}) {
+					t.Fatalf("unexpected error, given = %v, expected = ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+			if len(response.Skipped) != c.skipped {
+				t.Errorf("unexpected skipped, given = %d, expected = %d", len(response.Skipped), c.skipped)
+			}
+			if len(response.Imported) != len(c.imported) {
+				t.Fatalf("unexpected imported, given = %d, expected = %d", len(response.Imported), len(c.imported))
+			}
+
+			outlines, err := svc.ReadTODOOutlines(ctx)
+			if err != nil {
+				t.Fatal("failed to read outlines, err =", err)
+			}
+			for i, got := range response.Imported {
+				want := c.imported[i]
+				if got.Subject != want.subject || got.Description != want.description {
+					t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, want.subject, want.description)
+				}
+				outline := model.TODOOutline{Group: want.group}
+				if want.parent >= 0 {
+					outline.ParentID = response.Imported[want.parent].ID
+				}
+				if outlines[got.ID] != outline {
+					t.Errorf("unexpected outline of %q, given = %+v, expected = %+v", got.Subject, outlines[got.ID], outline)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type todo struct {
+		subject, description string
+		outline              model.TODOOutline
+	}
+	cases := map[string]struct {
+		todos []todo
+	}{
+		"Plain": {todos: []todo{{subject: "a"}, {subject: "b", description: "line 1\n\nline 2"}}},
+		"Item in description": {todos: []todo{
+			{subject: "a", description: "- [ ] x\n  * [x] y\n+ [X] z"},
+			{subject: "b"},
+		}},
+		"Escaped item in description": {todos: []todo{{subject: "a", description: "\\- [ ] x\n\\\\* [ ] y"}}},
+		"Heading in description":      {todos: []todo{{subject: "a", description: "## not a group"}}},
+		"Outline": {todos: []todo{
+			{subject: "a"},
+			{subject: "b", outline: model.TODOOutline{Group: "g"}},
+			{subject: "c", description: "- [ ] not a child", outline: model.TODOOutline{Group: "g", ParentID: 2}},
+		}},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			src := newTestService(t)
+			for _, td := range c.todos {
+				if _, err := src.CreateTODOInOutline(ctx, td.subject, td.description, td.outline); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+			_, exported, err := handler.NewMarkdownHandler(src).Export(ctx)
+			if err != nil {
+				t.Fatal("failed to export, err =", err)
+			}
+
+			dst := newTestService(t)
+			h := handler.NewMarkdownHandler(dst)
+			imported, err := h.Import(ctx, []byte(exported))
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+			if len(imported.Imported) != len(c.todos) {
+				t.Fatalf("unexpected imported, given = %d, expected = %d\n%s", len(imported.Imported), len(c.todos), exported)
+			}
+			for i, got := range imported.Imported {
+				if got.Subject != c.todos[i].subject || got.Description != c.todos[i].description {
+					t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, c.todos[i].subject, c.todos[i].description)
+				}
+			}
+
+			_, reexported, err := h.Export(ctx)
+			if err != nil {
+				t.Fatal("failed to export, err =", err)
+			}
+			if reexported != exported {
+				t.Errorf("unexpected export, given =\n%s\nexpected =\n%s", reexported, exported)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// Admin allows the requests with the bearer token of ADMIN_TOKEN. Every request
+// is rejected when it is not set, which disables the admin endpoints.
+func Admin(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		auth := r.Header.Get("Authorization")
+		const prefix = "Bearer "
+		if token == "" || !strings.HasPrefix(auth, prefix) ||
+			subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="todo-admin"`)
+			handler.WriteError(w, r, model.ErrUnauthorized{})
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
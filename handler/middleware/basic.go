@@ -3,18 +3,28 @@ package middleware
 import (
 	"net/http"
 	"os"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
 )
 
 func Basic(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		name, pass, ok := r.BasicAuth()
-		uid := os.Getenv("BASIC_AUTH_USER_ID")
-		up := os.Getenv("BASIC_AUTH_PASSWORD")
-		if uid != name || up != pass || !ok {
-			w.WriteHeader(http.StatusUnauthorized)
+		if !basicAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="todo"`)
+			handler.WriteError(w, r, model.ErrUnauthorized{})
 			return
 		}
 		h.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
 }
+
+// basicAuthorized reports whether r has the credentials of BASIC_AUTH_USER_ID
+// and BASIC_AUTH_PASSWORD in the Authorization header.
+func basicAuthorized(r *http.Request) bool {
+	name, pass, ok := r.BasicAuth()
+	uid := os.Getenv("BASIC_AUTH_USER_ID")
+	up := os.Getenv("BASIC_AUTH_PASSWORD")
+	return uid == name && up == pass && ok
+}
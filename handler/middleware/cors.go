@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CORSConfig expresses the cross-origin requests allowed by CORS.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the API, "*" allows any
+	// but not with credentials.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers readable by the scripts.
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long the browsers cache the result of a preflight request.
+	MaxAge time.Duration
+}
+
+// CORS sets the CORS headers on the responses to the allowed origins and
+// answers the preflight requests without passing them to h, so that they do
+// not need credentials.
+func CORS(config CORSConfig) func(http.Handler) http.Handler {
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge / time.Second))
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if origin != "" {
+				w.Header().Add("Vary", "Origin")
+				if config.allows(origin) {
+					// credentials are only allowed to the origins listed
+					switch {
+					case config.AllowCredentials && config.lists(origin):
+						w.Header().Set("Access-Control-Allow-Origin", origin)
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					case config.lists("*"):
+						w.Header().Set("Access-Control-Allow-Origin", "*")
+					default:
+						w.Header().Set("Access-Control-Allow-Origin", origin)
+					}
+
+					if preflight {
+						w.Header().Set("Access-Control-Allow-Methods", methods)
+						w.Header().Set("Access-Control-Allow-Headers", headers)
+						w.Header().Set("Access-Control-Max-Age", maxAge)
+					} else if exposed != "" {
+						w.Header().Set("Access-Control-Expose-Headers", exposed)
+					}
+				}
+			}
+
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Validate rejects allowing credentials to any origin, which would let every
+// site call the API with the credentials of its users.
+func (c CORSConfig) Validate() error {
+	if c.AllowCredentials && c.lists("*") {
+		return errors.New("cors: credentials cannot be allowed to any origin")
+	}
+	return nil
+}
+
+func (c CORSConfig) allows(origin string) bool {
+	return c.lists("*") || c.lists(origin)
+}
+
+// lists reports whether the origin is one of AllowedOrigins.
+func (c CORSConfig) lists(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		origins     []string
+		credentials bool
+		origin      string
+		preflight   bool
+		// allowOrigin and allowCredentials are the headers expected
+		allowOrigin      string
+		allowCredentials string
+		status           int
+	}{
+		"Listed":                      {origins: []string{"https://a.example"}, origin: "https://a.example", allowOrigin: "https://a.example", status: http.StatusOK},
+		"Not listed":                  {origins: []string{"https://a.example"}, origin: "https://b.example", status: http.StatusOK},
+		"Wildcard":                    {origins: []string{"*"}, origin: "https://b.example", allowOrigin: "*", status: http.StatusOK},
+		"Listed with credentials":     {origins: []string{"https://a.example"}, credentials: true, origin: "https://a.example", allowOrigin: "https://a.example", allowCredentials: "true", status: http.StatusOK},
+		"Wildcard without credential": {origins: []string{"https://a.example", "*"}, credentials: true, origin: "https://b.example", allowOrigin: "*", status: http.StatusOK},
+		"Preflight":                   {origins: []string{"https://a.example"}, origin: "https://a.example", preflight: true, allowOrigin: "https://a.example", status: http.StatusNoContent},
+		"Same origin":                 {origins: []string{"https://a.example"}, status: http.StatusOK},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config := middleware.CORSConfig{
+				AllowedOrigins:   c.origins,
+				AllowedMethods:   []string{http.MethodGet},
+				AllowCredentials: c.credentials,
+			}
+			h := middleware.CORS(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if c.preflight {
+				r.Method = http.MethodOptions
+				r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			if c.origin != "" {
+				r.Header.Set("Origin", c.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Errorf("unexpected status, given = %d, expected = %d", w.Code, c.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != c.allowOrigin {
+				t.Errorf("unexpected Access-Control-Allow-Origin, given = %q, expected = %q", got, c.allowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != c.allowCredentials {
+				t.Errorf("unexpected Access-Control-Allow-Credentials, given = %q, expected = %q", got, c.allowCredentials)
+			}
+		})
+	}
+}
+
+func TestCORSConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		config middleware.CORSConfig
+		valid  bool
+	}{
+		"Listed with credentials":   {config: middleware.CORSConfig{AllowedOrigins: []string{"https://a.example"}, AllowCredentials: true}, valid: true},
+		"Wildcard":                  {config: middleware.CORSConfig{AllowedOrigins: []string{"*"}}, valid: true},
+		"Wildcard with credentials": {config: middleware.CORSConfig{AllowedOrigins: []string{"https://a.example", "*"}, AllowCredentials: true}},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := c.config.Validate(); (err == nil) != c.valid {
+				t.Errorf("unexpected value, given = %v, expected valid = %t", err, c.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import "net/http"
+
+// DAV advertises the WebDAV compliance classes of the route in the DAV header,
+// which clients discover by OPTIONS.
+func DAV(classes string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("DAV", classes)
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
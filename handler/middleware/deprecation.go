@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Deprecation marks the responses of a deprecated API version with the
+// Deprecation header and links the endpoint of its successor version.
+func Deprecation(successor string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestDeprecation(t *testing.T) {
+	t.Parallel()
+
+	h := middleware.Deprecation("/v2/todos")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</todos?prev_id=3>; rel="next"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status, given = %d, expected = %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("unexpected Deprecation, given = %q, expected = %q", got, "true")
+	}
+	links := w.Header().Values("Link")
+	expected := []string{`</v2/todos>; rel="successor-version"`, `</todos?prev_id=3>; rel="next"`}
+	if len(links) != len(expected) || links[0] != expected[0] || links[1] != expected[1] {
+		t.Errorf("unexpected Link, given = %q, expected = %q", links, expected)
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	ua "github.com/mileusna/useragent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCAuthLayers returns the options of gRPC servers which apply the layers of
+// AuthLayers to every call.
+func GRPCAuthLayers() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcRecovery, grpcAccess, grpcBasic),
+		grpc.ChainStreamInterceptor(grpcStreamRecovery, grpcStreamAccess, grpcStreamBasic),
+	}
+}
+
+func grpcRecovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Println(v)
+			err = status.Errorf(codes.Internal, "panic: %v", v)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func grpcStreamRecovery(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Println(v)
+			err = status.Errorf(codes.Internal, "panic: %v", v)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func grpcAccess(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	defer printGRPCAccess(ctx, info.FullMethod, start)
+	return handler(ctx, req)
+}
+
+func grpcStreamAccess(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	defer printGRPCAccess(ss.Context(), info.FullMethod, start)
+	return handler(srv, ss)
+}
+
+// printGRPCAccess prints the access log of the call like Access, with the
+// method as the path.
+func printGRPCAccess(ctx context.Context, method string, start time.Time) {
+	access := &model.Access{
+		Timestamp: start,
+		Latency:   int64(time.Since(start) / time.Millisecond),
+		Path:      method,
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("user-agent")) > 0 {
+		access.OS = ua.Parse(md.Get("user-agent")[0]).OS
+	}
+
+	json, err := json.Marshal(access)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(json))
+}
+
+func grpcBasic(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !grpcBasicAuthorized(ctx) {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamBasic(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !grpcBasicAuthorized(ss.Context()) {
+		return status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return handler(srv, ss)
+}
+
+// grpcBasicAuthorized reports whether the authorization metadata of the call
+// has the credentials of Basic.
+func grpcBasicAuthorized(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+	return basicAuthorized(r)
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// Idempotency replays the stored response of a POST request retried with the
+// same Idempotency-Key header instead of processing it again.
+func Idempotency(svc *service.IdempotencyService) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				handler.WriteError(w, r, model.ErrValidation{Message: err.Error()})
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			key = r.URL.Path + " " + key
+			stored, err := svc.Begin(ctx, key, fingerprint(r, body))
+
+			if err != nil {
+				handler.WriteError(w, r, err)
+				return
+			}
+
+			if stored != nil {
+				for k, v := range stored.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				if _, err := w.Write(stored.Body); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				// keep the key reserved only for requests that reached a result
+				if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+					if err := svc.Abort(ctx, key); err != nil {
+						log.Println(err)
+					}
+					return
+				}
+
+				resp := &model.IdempotentResponse{
+					StatusCode: rec.status,
+					Header:     rec.header,
+					Body:       rec.body.Bytes(),
+				}
+				if err := svc.Complete(ctx, key, resp); err != nil {
+					log.Println(err)
+				}
+			}()
+
+			h.ServeHTTP(rec, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// fingerprint identifies the request sent with an Idempotency-Key.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	// Accept selects the API version and the encoding of the stored response
+	fmt.Fprintf(h, "%s\n", r.Header.Get("Accept"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A recorder copies the response written through it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
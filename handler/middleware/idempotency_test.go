@@ -0,0 +1,116 @@
+package middleware_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestIdempotency(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		key, body string
+		status    int
+		replayed  bool
+	}
+	cases := map[string]struct {
+		requests []request
+		// calls is the number of the requests processed by the handler
+		calls int
+	}{
+		"Replayed": {
+			requests: []request{
+				{key: "a", body: "x", status: http.StatusCreated},
+				{key: "a", body: "x", status: http.StatusCreated, replayed: true},
+			},
+			calls: 1,
+		},
+		"Reused with another body": {
+			requests: []request{
+				{key: "a", body: "x", status: http.StatusCreated},
+				{key: "a", body: "y", status: http.StatusUnprocessableEntity},
+			},
+			calls: 1,
+		},
+		"Other keys": {
+			requests: []request{
+				{key: "a", body: "x", status: http.StatusCreated},
+				{key: "b", body: "x", status: http.StatusCreated},
+			},
+			calls: 2,
+		},
+		"Without key": {
+			requests: []request{
+				{body: "x", status: http.StatusCreated},
+				{body: "x", status: http.StatusCreated},
+			},
+			calls: 2,
+		},
+		"Failed request retried": {
+			requests: []request{
+				{key: "a", body: "fail", status: http.StatusInternalServerError},
+				{key: "a", body: "fail", status: http.StatusInternalServerError},
+			},
+			calls: 2,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "idempotency_test.db"))
+			if err != nil {
+				t.Fatal("failed to create db, err =", err)
+			}
+			t.Cleanup(func() { todoDB.Close() })
+
+			calls := 0
+			h := middleware.Idempotency(service.NewIdempotencyService(todoDB, time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if body, _ := io.ReadAll(r.Body); string(body) == "fail" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(strconv.Itoa(calls)))
+			}))
+
+			var first string
+			for i, req := range c.requests {
+				r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(req.body))
+				if req.key != "" {
+					r.Header.Set("Idempotency-Key", req.key)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+
+				if w.Code != req.status {
+					t.Errorf("unexpected status of request %d, given = %d, expected = %d", i, w.Code, req.status)
+				}
+				if got := w.Header().Get("Idempotent-Replayed") == "true"; got != req.replayed {
+					t.Errorf("unexpected replay of request %d, given = %t, expected = %t", i, got, req.replayed)
+				}
+				if i == 0 {
+					first = w.Body.String()
+				} else if req.replayed && w.Body.String() != first {
+					t.Errorf("unexpected replayed body, given = %q, expected = %q", w.Body.String(), first)
+				}
+			}
+			if calls != c.calls {
+				t.Errorf("unexpected calls, given = %d, expected = %d", calls, c.calls)
+			}
+		})
+	}
+}
@@ -25,3 +25,15 @@ func AuthLayers(handler http.Handler) http.Handler {
 		),
 	)
 }
+
+func AdminLayers(handler http.Handler) http.Handler {
+	return Recovery(
+		Context(
+			Access(
+				Admin(
+					handler,
+				),
+			),
+		),
+	)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// Methods limits the route to methods. OPTIONS is answered with the Allow
+// header, HEAD is served by the GET handler without the body, and the other
+// methods are rejected by 405 Method Not Allowed.
+func Methods(methods ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	if allowed[http.MethodGet] {
+		methods = append(methods, http.MethodHead)
+	}
+	methods = append(methods, http.MethodOptions)
+	allow := strings.Join(methods, ", ")
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodOptions:
+				w.Header().Set("Allow", allow)
+				w.WriteHeader(http.StatusNoContent)
+
+			case r.Method == http.MethodHead && allowed[http.MethodGet]:
+				// the server discards the body written to the response of HEAD
+				r = r.Clone(r.Context())
+				r.Method = http.MethodGet
+				h.ServeHTTP(w, r)
+
+			case allowed[r.Method]:
+				h.ServeHTTP(w, r)
+
+			default:
+				w.Header().Set("Allow", allow)
+				handler.WriteError(w, r, model.ErrMethodNotAllowed{})
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestMethods(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		methods []string
+		method  string
+		status  int
+		allow   string
+		body    bool
+	}{
+		"Allowed":      {methods: []string{http.MethodPost}, method: http.MethodPost, status: http.StatusOK, body: true},
+		"Not allowed":  {methods: []string{http.MethodPost}, method: http.MethodDelete, status: http.StatusMethodNotAllowed, allow: "POST, OPTIONS", body: true},
+		"OPTIONS":      {methods: []string{http.MethodGet, http.MethodPost}, method: http.MethodOptions, status: http.StatusNoContent, allow: "GET, POST, HEAD, OPTIONS"},
+		"HEAD":         {methods: []string{http.MethodGet}, method: http.MethodHead, status: http.StatusOK},
+		"HEAD of POST": {methods: []string{http.MethodPost}, method: http.MethodHead, status: http.StatusMethodNotAllowed, allow: "POST, OPTIONS"},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := middleware.Methods(c.methods...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					t.Error("HEAD is passed to the handler")
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("body"))
+			}))
+
+			// the server discards the body of HEAD responses, which the
+			// recorder keeps
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			r, err := http.NewRequest(c.method, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal("failed to request, err =", err)
+			}
+			defer res.Body.Close()
+			n, _ := res.Body.Read(make([]byte, 1))
+
+			if res.StatusCode != c.status {
+				t.Errorf("unexpected status, given = %d, expected = %d", res.StatusCode, c.status)
+			}
+			if got := res.Header.Get("Allow"); got != c.allow {
+				t.Errorf("unexpected Allow, given = %q, expected = %q", got, c.allow)
+			}
+			if (n > 0) != c.body {
+				t.Errorf("unexpected body, given = %t, expected = %t", n > 0, c.body)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/handler"
 )
 
 func Recovery(h http.Handler) http.Handler {
@@ -11,6 +13,7 @@ func Recovery(h http.Handler) http.Handler {
 			err := recover()
 			if err != nil {
 				fmt.Println(err)
+				handler.WriteError(w, r, fmt.Errorf("panic: %v", err))
 			}
 		}()
 		h.ServeHTTP(w, r)
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// ProblemContentType is the media type of error responses.
+const ProblemContentType = "application/problem+json"
+
+// NewProblem returns the RFC 7807 problem details of err. Its code is stable
+// across the endpoints so that clients can branch on it.
+func NewProblem(err error) *model.Problem {
+	var (
+		validation model.ErrValidation
+		conflict   model.ErrConflict
+		status     = http.StatusInternalServerError
+		code       = "internal_error"
+	)
+	switch {
+	case errors.As(err, &model.ErrNotFound{}), errors.As(err, new(*model.ErrNotFound)):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.As(err, &validation):
+		status, code = http.StatusBadRequest, "validation_failed"
+	case errors.As(err, &model.ErrUnauthorized{}):
+		status, code = http.StatusUnauthorized, "unauthorized"
+	case errors.As(err, &model.ErrMethodNotAllowed{}):
+		status, code = http.StatusMethodNotAllowed, "method_not_allowed"
+	case errors.As(err, &model.ErrNotAcceptable{}):
+		status, code = http.StatusNotAcceptable, "not_acceptable"
+	case errors.As(err, &conflict):
+		status, code = http.StatusConflict, "conflict"
+	case errors.As(err, &model.ErrIdempotencyKeyInUse{}):
+		status, code = http.StatusConflict, "idempotency_key_in_use"
+	case errors.As(err, &model.ErrPreconditionFailed{}):
+		status, code = http.StatusPreconditionFailed, "precondition_failed"
+	case errors.As(err, &model.ErrGone{}):
+		status, code = http.StatusGone, "gone"
+	case errors.As(err, &model.ErrUnsupportedMediaType{}):
+		status, code = http.StatusUnsupportedMediaType, "unsupported_media_type"
+	case errors.As(err, &model.ErrIdempotencyKeyReused{}):
+		status, code = http.StatusUnprocessableEntity, "idempotency_key_reused"
+	case errors.As(err, &dependencyError{}):
+		status, code = http.StatusFailedDependency, "failed_dependency"
+	}
+
+	p := &model.Problem{
+		Type:   "urn:todo:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+	}
+	switch {
+	case code == "validation_failed":
+		p.Detail = validation.Message
+		p.InvalidParams = validation.InvalidParams
+	case status == http.StatusInternalServerError:
+		// internal errors may contain details of the database
+	default:
+		if detail := strings.TrimSpace(err.Error()); detail != p.Title {
+			p.Detail = detail
+		}
+	}
+	return p
+}
+
+// WriteError logs err and writes it as an application/problem+json response.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Println(err)
+
+	p := NewProblem(err)
+	p.Instance = r.URL.RequestURI()
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Println(err)
+	}
+}
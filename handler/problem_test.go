@@ -0,0 +1,145 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestNewProblem(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err    error
+		status int
+		code   string
+		detail string
+		params int
+	}{
+		"ErrNotFound": {
+			err:    model.ErrNotFound{},
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+		"*ErrNotFound": {
+			err:    &model.ErrNotFound{},
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+		"ErrValidation": {
+			err:    model.ErrValidation{Message: "request has invalid fields", InvalidParams: []*model.InvalidParam{{Name: "subject", Reason: "is required"}}},
+			status: http.StatusBadRequest,
+			code:   "validation_failed",
+			detail: "request has invalid fields",
+			params: 1,
+		},
+		"ErrUnauthorized": {
+			err:    model.ErrUnauthorized{},
+			status: http.StatusUnauthorized,
+			code:   "unauthorized",
+		},
+		"ErrMethodNotAllowed": {
+			err:    model.ErrMethodNotAllowed{},
+			status: http.StatusMethodNotAllowed,
+			code:   "method_not_allowed",
+		},
+		"ErrNotAcceptable": {
+			err:    model.ErrNotAcceptable{},
+			status: http.StatusNotAcceptable,
+			code:   "not_acceptable",
+		},
+		"ErrConflict": {
+			err:    model.ErrConflict{Message: "id 1 exists"},
+			status: http.StatusConflict,
+			code:   "conflict",
+			detail: "id 1 exists",
+		},
+		"ErrIdempotencyKeyInUse": {
+			err:    model.ErrIdempotencyKeyInUse{},
+			status: http.StatusConflict,
+			code:   "idempotency_key_in_use",
+			detail: "Idempotency-Key is in use by a request in progress",
+		},
+		"ErrPreconditionFailed": {
+			err:    model.ErrPreconditionFailed{},
+			status: http.StatusPreconditionFailed,
+			code:   "precondition_failed",
+		},
+		"ErrGone": {
+			err:    model.ErrGone{Message: "changes after 1 are no longer kept"},
+			status: http.StatusGone,
+			code:   "gone",
+			detail: "changes after 1 are no longer kept",
+		},
+		"ErrUnsupportedMediaType": {
+			err:    model.ErrUnsupportedMediaType{},
+			status: http.StatusUnsupportedMediaType,
+			code:   "unsupported_media_type",
+		},
+		"ErrIdempotencyKeyReused": {
+			err:    model.ErrIdempotencyKeyReused{},
+			status: http.StatusUnprocessableEntity,
+			code:   "idempotency_key_reused",
+			detail: "Idempotency-Key is reused with a different request",
+		},
+		"Wrapped": {
+			err:    fmt.Errorf("update: %w", model.ErrNotFound{}),
+			status: http.StatusNotFound,
+			code:   "not_found",
+			detail: "update: Not Found",
+		},
+		"Internal": {
+			err:    errors.New("no such table: todos"),
+			status: http.StatusInternalServerError,
+			code:   "internal_error",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := handler.NewProblem(c.err)
+			if p.Status != c.status || p.Code != c.code {
+				t.Errorf("unexpected problem, given = %d %s, expected = %d %s", p.Status, p.Code, c.status, c.code)
+			}
+			if p.Type != "urn:todo:problem:"+c.code || p.Title != http.StatusText(c.status) {
+				t.Errorf("unexpected type and title, given = %s %q, expected = %s %q", p.Type, p.Title, "urn:todo:problem:"+c.code, http.StatusText(c.status))
+			}
+			if p.Detail != c.detail {
+				t.Errorf("unexpected detail, given = %q, expected = %q", p.Detail, c.detail)
+			}
+			if len(p.InvalidParams) != c.params {
+				t.Errorf("unexpected invalid_params, given = %d, expected = %d", len(p.InvalidParams), c.params)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	handler.WriteError(w, httptest.NewRequest(http.MethodGet, "/todos/1?x=y", nil), model.ErrNotFound{})
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status, given = %d, expected = %d", w.Code, http.StatusNotFound)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != handler.ProblemContentType {
+		t.Errorf("unexpected content type, given = %s, expected = %s", ct, handler.ProblemContentType)
+	}
+	var p model.Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal("failed to decode problem, err =", err)
+	}
+	if p.Status != http.StatusNotFound || p.Code != "not_found" || p.Instance != "/todos/1?x=y" {
+		t.Errorf("unexpected problem, given = %+v", p)
+	}
+}
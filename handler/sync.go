@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// syncPageSize is the number of TODOs changed on the server read at once.
+const syncPageSize = 100
+
+var errSyncChangeRequired = model.ErrValidation{Message: "change is required"}
+
+// A SyncHandler implements the delta sync of offline clients. The sync token
+// is the id of the last event of the event log the client has seen.
+type SyncHandler struct {
+	todo *TODOHandler
+}
+
+// NewSyncHandler returns SyncHandler based http.Handler.
+func NewSyncHandler(svc *service.TODOService) *SyncHandler {
+	return &SyncHandler{
+		todo: NewTODOHandler(svc),
+	}
+}
+
+// Sync handles the endpoint that applies the changes of the client in order,
+// each in a savepoint of one transaction, and returns the TODOs changed since
+// the token in the same transaction. The changes of the client are among them,
+// so that the client takes their versions.
+//
+// Conflicts are resolved by fixed rules: an update or a deletion made on
+// another version than the one on the server is rejected and the TODO on the
+// server wins, and an update of a deleted TODO is rejected and the deletion
+// wins.
+func (h *SyncHandler) Sync(ctx context.Context, req *model.SyncRequest) (*model.SyncResponse, error) {
+	if err := model.Validate(req); err != nil {
+		return nil, err
+	}
+	var (
+		cursor  int64
+		resumed = req.Token != ""
+	)
+	if resumed {
+		var err error
+		if cursor, err = strconv.ParseInt(req.Token, 10, 64); err != nil || cursor < 0 {
+			return nil, model.ErrValidation{
+				Message:       "request has invalid fields",
+				InvalidParams: []*model.InvalidParam{{Name: "token", Reason: "is not a sync token"}},
+			}
+		}
+	}
+
+	response := &model.SyncResponse{
+		Results: make([]*model.SyncResult, len(req.Changes)),
+		Changes: make([]*model.SyncDelta, 0),
+	}
+	err := h.todo.svc.RunInTx(ctx, func(ctx context.Context) error {
+		for i, change := range req.Changes {
+			result := &model.SyncResult{Index: i}
+			if change != nil {
+				result.ClientID = change.ClientID
+			}
+			err := h.todo.svc.RunInSavepoint(ctx, func(ctx context.Context) error {
+				return h.apply(ctx, change, result)
+			})
+			var validation model.ErrValidation
+			switch {
+			case errors.As(err, &validation):
+				result.Status = model.SyncInvalid
+				result.Error = NewProblem(err)
+			case err != nil:
+				return err
+			}
+			response.Results[i] = result
+		}
+
+		first, last, err := h.todo.svc.EventLogBounds(ctx)
+		if err != nil {
+			return err
+		}
+		response.Token = strconv.FormatInt(last, 10)
+
+		// the events after the token are no longer kept, or the token is of
+		// another database
+		if !resumed || cursor+1 < first || cursor > last {
+			response.Full = true
+			all, err := readAll(ctx, h.todo.svc)
+			if err != nil {
+				return err
+			}
+			// readAll reads them in the descending order of ids
+			for i := len(all.TODOs) - 1; i >= 0; i-- {
+				t := all.TODOs[i]
+				response.Changes = append(response.Changes, &model.SyncDelta{ID: t.ID, TODO: t, Version: all.Versions[t.ID]})
+			}
+			return nil
+		}
+
+		changed, err := h.changedSince(ctx, cursor)
+		if err != nil {
+			return err
+		}
+		response.Changes = changed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// apply applies the change of the client and sets its result. Only the errors
+// other than the conflicts are returned.
+func (h *SyncHandler) apply(ctx context.Context, change *model.SyncChange, result *model.SyncResult) error {
+	if change == nil {
+		return errSyncChangeRequired
+	}
+	if err := model.Validate(change); err != nil {
+		return err
+	}
+	if change.Op != model.SyncOpCreate && change.ID == 0 {
+		return model.ErrValidation{
+			Message:       "request has invalid fields",
+			InvalidParams: []*model.InvalidParam{{Name: "id", Reason: "is required"}},
+		}
+	}
+
+	var err error
+	switch change.Op {
+	case model.SyncOpCreate:
+		request := &model.CreateTODORequest{Subject: change.Subject, Description: change.Description}
+		if err := model.Validate(request); err != nil {
+			return err
+		}
+		var response *model.CreateTODOResponse
+		if response, err = h.todo.Create(ctx, request); err == nil {
+			result.TODO, result.Version = response.TODO, &response.Version
+		}
+
+	case model.SyncOpUpdate:
+		request := &model.UpdateTODORequest{
+			ID:          int(change.ID),
+			Subject:     change.Subject,
+			Description: change.Description,
+			Version:     change.BaseVersion,
+		}
+		if err := model.Validate(request); err != nil {
+			return err
+		}
+		var response *model.UpdateTODOResponse
+		if response, err = h.todo.Update(ctx, request); err == nil {
+			result.TODO, result.Version = response.TODO, &response.Version
+		}
+
+	case model.SyncOpDelete:
+		request := &model.DeleteTODORequest{IDs: []int64{change.ID}}
+		if change.BaseVersion != nil {
+			request.Versions = map[int64]int64{change.ID: *change.BaseVersion}
+		}
+		_, err = h.todo.Delete(ctx, request)
+		if errors.As(err, &model.ErrNotFound{}) {
+			// deleting a deleted TODO is idempotent
+			err = nil
+		}
+	}
+
+	switch {
+	case err == nil:
+		result.Status = model.SyncApplied
+	case errors.As(err, &model.ErrNotFound{}):
+		result.Status = model.SyncGone
+	case errors.As(err, &model.ErrPreconditionFailed{}):
+		t, version, err := h.todo.svc.GetTODO(ctx, change.ID)
+		if err != nil {
+			return err
+		}
+		result.Status, result.TODO, result.Version = model.SyncConflict, t, &version
+	default:
+		return err
+	}
+	return nil
+}
+
+// changedSince returns the TODOs changed after the event of cursor in the
+// ascending order of ids, as they are now.
+func (h *SyncHandler) changedSince(ctx context.Context, cursor int64) ([]*model.SyncDelta, error) {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for {
+		events, err := h.todo.svc.ReadEvents(ctx, &model.ReadEventsRequest{}, cursor, eventPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			if !seen[e.TODOID] {
+				seen[e.TODOID] = true
+				ids = append(ids, e.TODOID)
+			}
+			cursor = e.ID
+		}
+		if len(events) < eventPageSize {
+			break
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	deltas := make([]*model.SyncDelta, 0, len(ids))
+	for start := 0; start < len(ids); start += syncPageSize {
+		end := start + syncPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		page := ids[start:end]
+
+		todos, versions, err := h.todo.svc.FindTODOs(ctx, &model.TODOFilter{IDs: page}, 0, int64(len(page)))
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[int64]*model.TODO, len(todos))
+		for _, t := range todos {
+			found[t.ID] = t
+		}
+		for _, id := range page {
+			if t, ok := found[id]; ok {
+				deltas = append(deltas, &model.SyncDelta{ID: id, TODO: t, Version: versions[id]})
+			} else {
+				deltas = append(deltas, &model.SyncDelta{ID: id, Deleted: true})
+			}
+		}
+	}
+	return deltas, nil
+}
+
+func (h *SyncHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	var request model.SyncRequest
+	if err := decodeRequest(r, &request); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	response, err := h.Sync(r.Context(), &request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println(err)
+	}
+}
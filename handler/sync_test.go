@@ -0,0 +1,317 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A syncFixture holds a TODO updated on the server since its stale version,
+// and a TODO deleted on the server.
+type syncFixture struct {
+	updated, deleted int64
+	stale, current   int64
+}
+
+func newSyncFixture(t *testing.T, svc *service.TODOService) *syncFixture {
+	t.Helper()
+
+	ctx := context.Background()
+	f := &syncFixture{}
+	updated, err := svc.CreateTODO(ctx, "a", "")
+	if err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	f.updated = updated.ID
+	if _, f.stale, err = svc.GetTODO(ctx, f.updated); err != nil {
+		t.Fatal("failed to get todo, err =", err)
+	}
+	if _, err := svc.UpdateTODO(ctx, f.updated, "a on server", ""); err != nil {
+		t.Fatal("failed to update todo, err =", err)
+	}
+	if _, f.current, err = svc.GetTODO(ctx, f.updated); err != nil {
+		t.Fatal("failed to get todo, err =", err)
+	}
+
+	deleted, err := svc.CreateTODO(ctx, "b", "")
+	if err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	f.deleted = deleted.ID
+	if err := svc.DeleteTODO(ctx, []int64{f.deleted}); err != nil {
+		t.Fatal("failed to delete todo, err =", err)
+	}
+	return f
+}
+
+func TestSyncConflicts(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		changes  func(f *syncFixture) []*model.SyncChange
+		statuses []string
+		// subject is the one of the updated TODO after the sync
+		subject string
+	}{
+		"Create": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpCreate, ClientID: "c1", Subject: "c"}}
+			},
+			statuses: []string{model.SyncApplied},
+			subject:  "a on server",
+		},
+		"Update on the current version": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpUpdate, ID: f.updated, BaseVersion: &f.current, Subject: "a on client"}}
+			},
+			statuses: []string{model.SyncApplied},
+			subject:  "a on client",
+		},
+		"Update without a base version": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpUpdate, ID: f.updated, Subject: "a on client"}}
+			},
+			statuses: []string{model.SyncApplied},
+			subject:  "a on client",
+		},
+		"Update on a stale version": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpUpdate, ID: f.updated, BaseVersion: &f.stale, Subject: "a on client"}}
+			},
+			statuses: []string{model.SyncConflict},
+			subject:  "a on server",
+		},
+		"Update of a deleted TODO": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpUpdate, ID: f.deleted, Subject: "b on client"}}
+			},
+			statuses: []string{model.SyncGone},
+			subject:  "a on server",
+		},
+		"Delete on a stale version": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpDelete, ID: f.updated, BaseVersion: &f.stale}}
+			},
+			statuses: []string{model.SyncConflict},
+			subject:  "a on server",
+		},
+		"Delete of a deleted TODO": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{{Op: model.SyncOpDelete, ID: f.deleted}}
+			},
+			statuses: []string{model.SyncApplied},
+			subject:  "a on server",
+		},
+		"Invalid changes": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{
+					nil,
+					{Op: model.SyncOpUpdate, Subject: "no id"},
+					{Op: model.SyncOpCreate},
+					{Op: "rename", ID: f.updated},
+				}
+			},
+			statuses: []string{model.SyncInvalid, model.SyncInvalid, model.SyncInvalid, model.SyncInvalid},
+			subject:  "a on server",
+		},
+		"Invalid change among others": {
+			changes: func(f *syncFixture) []*model.SyncChange {
+				return []*model.SyncChange{
+					{Op: model.SyncOpUpdate, ID: f.updated, BaseVersion: &f.current, Subject: "a on client"},
+					{Op: model.SyncOpCreate},
+				}
+			},
+			statuses: []string{model.SyncApplied, model.SyncInvalid},
+			subject:  "a on client",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			f := newSyncFixture(t, svc)
+
+			changes := c.changes(f)
+			response, err := handler.NewSyncHandler(svc).Sync(ctx, &model.SyncRequest{Changes: changes})
+			if err != nil {
+				t.Fatal("failed to sync, err =", err)
+			}
+			if len(response.Results) != len(c.statuses) {
+				t.Fatalf("unexpected results, given = %d, expected = %d", len(response.Results), len(c.statuses))
+			}
+			for i, result := range response.Results {
+				if result.Index != i || result.Status != c.statuses[i] {
+					t.Errorf("unexpected result, given = %d %s, expected = %d %s", result.Index, result.Status, i, c.statuses[i])
+				}
+				if changes[i] != nil && result.ClientID != changes[i].ClientID {
+					t.Errorf("unexpected client_id, given = %q, expected = %q", result.ClientID, changes[i].ClientID)
+				}
+				switch result.Status {
+				case model.SyncApplied, model.SyncConflict:
+					if changes[i].Op != model.SyncOpDelete || result.Status == model.SyncConflict {
+						if result.TODO == nil || result.Version == nil {
+							t.Errorf("unexpected result, given = %+v, expected the TODO and its version", result)
+						}
+					}
+				case model.SyncInvalid:
+					if result.Error == nil {
+						t.Error("unexpected result, given = no error, expected = the problem")
+					}
+				}
+				if result.Status == model.SyncConflict && result.TODO.Subject != "a on server" {
+					t.Errorf("unexpected todo, given = %q, expected = %q", result.TODO.Subject, "a on server")
+				}
+			}
+
+			got, _, err := svc.GetTODO(ctx, f.updated)
+			if err != nil {
+				t.Fatal("failed to get todo, err =", err)
+			}
+			if got.Subject != c.subject {
+				t.Errorf("unexpected subject, given = %q, expected = %q", got.Subject, c.subject)
+			}
+			if _, _, err := svc.GetTODO(ctx, f.deleted); !errors.As(err, &model.ErrNotFound{}) {
+				t.Errorf("unexpected error, given = %v, expected = ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestSyncChanges(t *testing.T) {
+	t.Parallel()
+
+	type delta struct {
+		subject string
+		deleted bool
+	}
+	cases := map[string]struct {
+		// token returns the token of the sync, given the one taken before
+		// the changes of the fixture
+		token   func(before int64) string
+		full    bool
+		changes []delta
+		err     bool
+	}{
+		"First sync": {
+			token:   func(before int64) string { return "" },
+			full:    true,
+			changes: []delta{{subject: "a on server"}, {subject: "c"}},
+		},
+		"Delta": {
+			token:   func(before int64) string { return strconv.FormatInt(before, 10) },
+			changes: []delta{{deleted: true}, {subject: "a on server"}, {deleted: true}, {subject: "c"}},
+		},
+		"Up to date": {
+			token: func(before int64) string { return strconv.FormatInt(before+6, 10) },
+		},
+		"Token of another database": {
+			token:   func(before int64) string { return strconv.FormatInt(before+100, 10) },
+			full:    true,
+			changes: []delta{{subject: "a on server"}, {subject: "c"}},
+		},
+		"Token too old": {
+			token:   func(before int64) string { return "0" },
+			full:    true,
+			changes: []delta{{subject: "a on server"}, {subject: "c"}},
+		},
+		"Invalid token": {
+			token: func(before int64) string { return "abc" },
+			err:   true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			todoDB := newTestDB(t)
+			svc := service.NewTODOService(todoDB)
+			h := handler.NewSyncHandler(svc)
+
+			// the event log starts after a dropped event, as when older
+			// events are no longer kept
+			if _, err := svc.CreateTODO(ctx, "dropped", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := todoDB.Exec(`DELETE FROM todo_events`); err != nil {
+				t.Fatal("failed to delete events, err =", err)
+			}
+			if _, err := todoDB.Exec(`DELETE FROM todos`); err != nil {
+				t.Fatal("failed to delete todos, err =", err)
+			}
+			if _, err := svc.CreateTODO(ctx, "unchanged", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			before, err := h.Sync(ctx, &model.SyncRequest{})
+			if err != nil {
+				t.Fatal("failed to sync, err =", err)
+			}
+			beforeToken, err := strconv.ParseInt(before.Token, 10, 64)
+			if err != nil {
+				t.Fatal("failed to parse token, err =", err)
+			}
+
+			// 6 events: a created and updated, b created and deleted,
+			// unchanged deleted and c created
+			newSyncFixture(t, svc)
+			all, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if err := svc.DeleteTODO(ctx, []int64{all[len(all)-1].ID}); err != nil {
+				t.Fatal("failed to delete todo, err =", err)
+			}
+			if _, err := svc.CreateTODO(ctx, "c", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			response, err := h.Sync(ctx, &model.SyncRequest{Token: c.token(beforeToken)})
+			if c.err {
+				if !errors.As(err, &model.ErrValidation{}) {
+					t.Fatalf("unexpected error, given = %v, expected = ErrValidation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to sync, err =", err)
+			}
+
+			if response.Token != strconv.FormatInt(beforeToken+6, 10) {
+				t.Errorf("unexpected token, given = %s, expected = %d", response.Token, beforeToken+6)
+			}
+			if response.Full != c.full {
+				t.Errorf("unexpected full, given = %t, expected = %t", response.Full, c.full)
+			}
+			var got []delta
+			for _, d := range response.Changes {
+				switch {
+				case d.Deleted && d.TODO == nil:
+					got = append(got, delta{deleted: true})
+				case !d.Deleted && d.TODO != nil && d.TODO.ID == d.ID:
+					got = append(got, delta{subject: d.TODO.Subject})
+				default:
+					t.Errorf("unexpected delta, given = %+v", d)
+				}
+			}
+			if len(got) != len(c.changes) {
+				t.Fatalf("unexpected changes, given = %+v, expected = %+v", got, c.changes)
+			}
+			for i := range got {
+				if got[i] != c.changes[i] {
+					t.Errorf("unexpected change, given = %+v, expected = %+v", got[i], c.changes[i])
+				}
+			}
+		})
+	}
+}
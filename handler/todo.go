@@ -1,16 +1,23 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
 )
 
+// defaultReadSize is the number of TODOs read when size is not given.
+const defaultReadSize = 5
+
 // A TODOHandler implements handling REST endpoints.
 type TODOHandler struct {
 	svc *service.TODOService
@@ -25,188 +32,281 @@ func NewTODOHandler(svc *service.TODOService) *TODOHandler {
 
 // Create handles the endpoint that creates the TODO.
 func (h *TODOHandler) Create(ctx context.Context, req *model.CreateTODORequest) (*model.CreateTODOResponse, error) {
-	tm, err := h.svc.CreateTODO(ctx, req.Subject, req.Description)
+	tm, version, err := h.svc.CreateTODOWithVersion(ctx, req.Subject, req.Description)
 	if err != nil {
 		return nil, err
 	}
-	return &model.CreateTODOResponse{TODO: tm}, nil
+	return &model.CreateTODOResponse{TODO: tm, Version: version}, nil
 }
 
-// Read handles the endpoint that reads the TODOs.
+// Read handles the endpoint that reads the TODOs with the selected fields and
+// the included relations.
 func (h *TODOHandler) Read(ctx context.Context, req *model.ReadTODORequest) (*model.ReadTODOResponse, error) {
-	todos, err := h.svc.ReadTODO(ctx, req.PrevID, req.Size)
+	response := &model.ReadTODOResponse{Fields: req.Fields, Include: req.Include}
+	err := h.svc.RunInReadTx(ctx, func(ctx context.Context) error {
+		todos, versions, err := h.svc.ReadTODOFields(ctx, req.PrevID, req.Size, req.Fields)
+		if err != nil {
+			return err
+		}
+		response.TODOs, response.Versions = todos, versions
+
+		if response.LastModified, err = h.svc.LastModified(ctx); err != nil {
+			return err
+		}
+
+		for _, include := range req.Include {
+			switch include {
+			case model.IncludeCount:
+				count, err := h.svc.CountTODOs(ctx)
+				if err != nil {
+					return err
+				}
+				response.Count = &count
+			case model.IncludeETags:
+				response.ETags = make(map[int64]string, len(todos))
+				for _, t := range todos {
+					response.ETags[t.ID] = todoETag(t.ID, versions[t.ID])
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &model.ReadTODOResponse{TODOs: todos}, nil
+	return response, nil
 }
 
 // Update handles the endpoint that updates the TODO.
 func (h *TODOHandler) Update(ctx context.Context, req *model.UpdateTODORequest) (*model.UpdateTODOResponse, error) {
-	tm, err := h.svc.UpdateTODO(ctx, int64(req.ID), req.Subject, req.Description)
+	tm, version, err := h.svc.UpdateTODOIfMatch(ctx, int64(req.ID), req.Version, req.Subject, req.Description)
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.UpdateTODOResponse{TODO: tm}, nil
+	return &model.UpdateTODOResponse{TODO: tm, Version: version}, nil
 }
 
 // Delete handles the endpoint that deletes the TODOs.
 func (h *TODOHandler) Delete(ctx context.Context, req *model.DeleteTODORequest) (*model.DeleteTODOResponse, error) {
-	if err := h.svc.DeleteTODO(ctx, req.IDs); err != nil {
+	var err error
+	if req.Versions != nil {
+		err = h.svc.DeleteTODOIfMatch(ctx, req.IDs, req.Versions)
+	} else {
+		err = h.svc.DeleteTODO(ctx, req.IDs)
+	}
+	if err != nil {
 		return nil, err
 	}
 	return &model.DeleteTODOResponse{}, nil
 }
 
 func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	ctx := context.Background()
+	ctx := r.Context()
+
+	w.Header().Add("Vary", "Accept")
+	c, err := negotiate(r.Header.Get("Accept"), r.Method == http.MethodGet)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		size := r.URL.Query().Get("size")
-		log.Println(size)
-		var err error
-		size64 := int64(5)
-		if size != "" {
-			size64, err = strconv.ParseInt(size, 10, 64)
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-		}
-
-		prevId := r.URL.Query().Get("prev_id")
-		prevId64 := int64(0)
-		if prevId != "" {
-			prevId64, err = strconv.ParseInt(prevId, 10, 64)
-			if err != nil {
-				log.Println(err)
-				return
-			}
+		request, err := parseReadTODORequest(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
 		}
-		request := &model.ReadTODORequest{Size: size64, PrevID: prevId64}
 
 		response, err := h.Read(ctx, request)
-
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+			WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-
-		je := json.NewEncoder(w)
-
-		if err := je.Encode(response); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+		if checkNotModified(w, r, listETag(response, c.mediaTypes[0]), response.LastModified) {
+			return
 		}
 
+		writeResponse(w, c, http.StatusOK, response)
+
 	case http.MethodPost:
-		decoder := json.NewDecoder(r.Body)
 		var request model.CreateTODORequest
-		err := decoder.Decode(&request)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		if request.Subject == "" {
-			log.Println("Subject not found")
-			w.WriteHeader(http.StatusBadRequest)
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
 			return
 		}
 
 		response, err := h.Create(ctx, &request)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+			WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-
-		je := json.NewEncoder(w)
-
-		if err := je.Encode(response); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-		}
+		w.Header().Set("ETag", todoETag(response.TODO.ID, response.Version))
+		writeResponse(w, c, http.StatusOK, response)
 
 	case http.MethodPut:
-		decoder := json.NewDecoder(r.Body)
 		var request model.UpdateTODORequest
-		err := decoder.Decode(&request)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			log.Println(err)
-			return
-		}
-		if request.ID == 0 {
-			log.Println("ID not found")
-			w.WriteHeader(http.StatusBadRequest)
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
 			return
 		}
-		if request.Subject == "" {
-			log.Println("Subject not found")
-			w.WriteHeader(http.StatusBadRequest)
+		if err := setUpdateIfMatch(&request, r.Header.Get("If-Match")); err != nil {
+			WriteError(w, r, err)
 			return
 		}
 
 		response, err := h.Update(ctx, &request)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+			WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		w.Header().Set("ETag", todoETag(response.TODO.ID, response.Version))
+		writeResponse(w, c, http.StatusOK, response)
 
-		je := json.NewEncoder(w)
-
-		if err := je.Encode(response); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 	case http.MethodDelete:
-		decoder := json.NewDecoder(r.Body)
 		var request model.DeleteTODORequest
-		err := decoder.Decode(&request)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
 			return
 		}
+		setDeleteIfMatch(&request, r.Header.Get("If-Match"))
 
 		response, err := h.Delete(ctx, &request)
-
-		switch err {
-		case nil:
-			break
-		case model.ErrNotFound{}:
-			log.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			return
-		default:
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+		if err != nil {
+			WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		writeResponse(w, c, http.StatusOK, response)
 
-		je := json.NewEncoder(w)
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// parseReadTODORequest returns the validated ReadTODORequest in the query of r.
+func parseReadTODORequest(r *http.Request) (*model.ReadTODORequest, error) {
+	var err error
+	q := r.URL.Query()
+
+	size := q.Get("size")
+	size64 := int64(defaultReadSize)
+	if size != "" {
+		size64, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, model.ErrValidation{Message: "size must be an integer"}
+		}
+	}
+
+	prevId := q.Get("prev_id")
+	prevId64 := int64(0)
+	if prevId != "" {
+		prevId64, err = strconv.ParseInt(prevId, 10, 64)
+		if err != nil {
+			return nil, model.ErrValidation{Message: "prev_id must be an integer"}
+		}
+	}
+
+	request := &model.ReadTODORequest{
+		Size:    size64,
+		PrevID:  prevId64,
+		Fields:  splitList(q.Get("fields")),
+		Include: splitList(q.Get("include")),
+	}
+	if err := model.Validate(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
 
-		if err := je.Encode(response); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
+// splitList splits the comma separated query parameter s, skipping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
 		}
+	}
+	return list
+}
+
+// decodeRequest decodes the body of r into v by its Content-Type and validates it.
+func decodeRequest(r *http.Request, v interface{}) error {
+	b, err := decodeBody(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalRequest(b, v)
+}
+
+// unmarshalRequest decodes the JSON request b into v and validates it. Unknown
+// fields are rejected together with the other invalid fields.
+func unmarshalRequest(b []byte, v interface{}) error {
+	params := unknownFields(b, v)
+	d := json.NewDecoder(bytes.NewReader(b))
+	if len(params) == 0 {
+		// the unknown fields of nested objects fail the decoding
+		d.DisallowUnknownFields()
+	}
 
+	var typeErr *json.UnmarshalTypeError
+	err := d.Decode(v)
+	switch {
+	case err == nil:
+		break
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return model.ErrValidation{Message: "request body must be an object"}
+	case errors.As(err, &typeErr):
+		return model.ErrValidation{
+			Message:       "request has invalid fields",
+			InvalidParams: []*model.InvalidParam{{Name: typeErr.Field, Reason: "must be " + typeErr.Type.String()}},
+		}
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		return model.ErrValidation{Message: "request body is not valid JSON: " + err.Error()}
+	}
+
+	var verr model.ErrValidation
+	if err := model.Validate(v); errors.As(err, &verr) {
+		params = append(params, verr.InvalidParams...)
+	}
+	if len(params) > 0 {
+		return model.ErrValidation{Message: "request has invalid fields", InvalidParams: params}
+	}
+	return nil
+}
+
+// unknownFields returns the fields in the JSON object b that v does not have.
+func unknownFields(b []byte, v interface{}) []*model.InvalidParam {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	params := make([]*model.InvalidParam, len(names))
+	for i, name := range names {
+		params[i] = &model.InvalidParam{Name: name, Reason: "is unknown"}
 	}
+	return params
 }
@@ -0,0 +1,356 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestTODOReadFields(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query  string
+		status int
+		// fields are the keys of every TODO
+		fields []string
+		count  bool
+		etags  bool
+	}{
+		"All fields": {
+			status: http.StatusOK,
+			fields: []string{"created_at", "description", "id", "subject", "updated_at"},
+		},
+		"Fields": {
+			query:  "?fields=subject,id",
+			status: http.StatusOK,
+			fields: []string{"id", "subject"},
+		},
+		"Include count": {
+			query:  "?include=count",
+			status: http.StatusOK,
+			fields: []string{"created_at", "description", "id", "subject", "updated_at"},
+			count:  true,
+		},
+		"Include etags": {
+			query:  "?include=etags",
+			status: http.StatusOK,
+			fields: []string{"created_at", "description", "id", "subject", "updated_at"},
+			etags:  true,
+		},
+		"Fields and includes": {
+			query:  "?fields=id&include=count,etags",
+			status: http.StatusOK,
+			fields: []string{"id"},
+			count:  true,
+			etags:  true,
+		},
+		"Unknown field": {
+			query:  "?fields=id,version",
+			status: http.StatusBadRequest,
+		},
+		"Unknown include": {
+			query:  "?include=children",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			for _, subject := range []string{"a", "b"} {
+				if _, err := svc.CreateTODO(ctx, subject, ""); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			handler.NewTODOHandler(svc).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos"+c.query, nil))
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				TODOs []map[string]json.RawMessage `json:"todos"`
+				Count *int64                       `json:"count"`
+				ETags map[string]string            `json:"etags"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal("failed to decode response, err =", err)
+			}
+			if len(response.TODOs) != 2 {
+				t.Fatalf("unexpected todos, given = %d, expected = %d", len(response.TODOs), 2)
+			}
+			for _, td := range response.TODOs {
+				var keys []string
+				for k := range td {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				if strings.Join(keys, ",") != strings.Join(c.fields, ",") {
+					t.Errorf("unexpected fields, given = %v, expected = %v", keys, c.fields)
+				}
+			}
+			if (response.Count != nil) != c.count || (c.count && *response.Count != 2) {
+				t.Errorf("unexpected count, given = %v, expected = %t", response.Count, c.count)
+			}
+			if c.etags && (len(response.ETags) != 2 || response.ETags["1"] == "" || response.ETags["2"] == "") {
+				t.Errorf("unexpected etags, given = %v, expected one for each TODO", response.ETags)
+			} else if !c.etags && response.ETags != nil {
+				t.Errorf("unexpected etags, given = %v, expected = none", response.ETags)
+			}
+		})
+	}
+}
+
+func TestTODOCreateRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body   string
+		status int
+		detail string
+		// params are the names of the invalid params
+		params []string
+	}{
+		"Valid": {
+			body:   `{"subject": "a"}`,
+			status: http.StatusOK,
+		},
+		"Field names ignoring case": {
+			body:   `{"Subject": "a", "DESCRIPTION": "b"}`,
+			status: http.StatusOK,
+		},
+		"Unknown fields": {
+			body:   `{"subject": "a", "status": "done", "due": null}`,
+			status: http.StatusBadRequest,
+			detail: "request has invalid fields",
+			params: []string{"due", "status"},
+		},
+		"Unknown and invalid fields": {
+			body:   `{"status": "done"}`,
+			status: http.StatusBadRequest,
+			detail: "request has invalid fields",
+			params: []string{"status", "subject"},
+		},
+		"Type of a field": {
+			body:   `{"subject": 1}`,
+			status: http.StatusBadRequest,
+			detail: "request has invalid fields",
+			params: []string{"subject"},
+		},
+		"Not an object": {
+			body:   `["a"]`,
+			status: http.StatusBadRequest,
+			detail: "request body must be an object",
+		},
+		"Invalid JSON": {
+			body:   `{"subject": "a"`,
+			status: http.StatusBadRequest,
+			detail: "request body is not valid JSON: unexpected EOF",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.NewTODOHandler(newTestService(t)).ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.status == http.StatusOK {
+				return
+			}
+
+			var p model.Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal("failed to decode problem, err =", err)
+			}
+			if p.Code != "validation_failed" || p.Detail != c.detail {
+				t.Errorf("unexpected problem, given = %s %q, expected = %s %q", p.Code, p.Detail, "validation_failed", c.detail)
+			}
+			names := make([]string, len(p.InvalidParams))
+			for i, param := range p.InvalidParams {
+				names[i] = param.Name
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(c.params, ",") {
+				t.Errorf("unexpected invalid_params, given = %v, expected = %v", names, c.params)
+			}
+		})
+	}
+}
+
+func TestTODOIfMatch(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		method string
+		// ifMatch returns If-Match from the current and a stale ETag of the
+		// TODO
+		ifMatch func(current, stale string) string
+		status  int
+		// changed is true when the request updates or deletes the TODO
+		changed bool
+	}{
+		"Update without If-Match": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return "" },
+			status:  http.StatusOK,
+			changed: true,
+		},
+		"Update with the current ETag": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return current },
+			status:  http.StatusOK,
+			changed: true,
+		},
+		"Update with a list of ETags": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return `"99-1", ` + current },
+			status:  http.StatusOK,
+			changed: true,
+		},
+		"Update with a stale ETag": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return stale },
+			status:  http.StatusPreconditionFailed,
+		},
+		"Update with the ETag of another TODO": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return `"99-1"` },
+			status:  http.StatusPreconditionFailed,
+		},
+		"Update with a weak ETag": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return "W/" + current },
+			status:  http.StatusPreconditionFailed,
+		},
+		"Update with *": {
+			method:  http.MethodPut,
+			ifMatch: func(current, stale string) string { return "*" },
+			status:  http.StatusOK,
+			changed: true,
+		},
+		"Delete with the current ETag": {
+			method:  http.MethodDelete,
+			ifMatch: func(current, stale string) string { return current },
+			status:  http.StatusOK,
+			changed: true,
+		},
+		"Delete with a stale ETag": {
+			method:  http.MethodDelete,
+			ifMatch: func(current, stale string) string { return stale },
+			status:  http.StatusPreconditionFailed,
+		},
+		"Delete with *": {
+			method:  http.MethodDelete,
+			ifMatch: func(current, stale string) string { return "*" },
+			status:  http.StatusOK,
+			changed: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			h := handler.NewTODOHandler(svc)
+			do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, "/todos", strings.NewReader(body))
+				r.Header.Set("Content-Type", "application/json")
+				if ifMatch != "" {
+					r.Header.Set("If-Match", ifMatch)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			w := do(http.MethodPost, `{"subject": "a"}`, "")
+			stale := w.Header().Get("ETag")
+			w = do(http.MethodPut, `{"id": 1, "subject": "b"}`, stale)
+			current := w.Header().Get("ETag")
+			if w.Code != http.StatusOK || stale == "" || current == stale {
+				t.Fatalf("unexpected update, given = %d %s %s, expected = %d and a new ETag", w.Code, stale, current, http.StatusOK)
+			}
+
+			body := `{"id": 1, "subject": "c"}`
+			if c.method == http.MethodDelete {
+				body = `{"ids": [1]}`
+			}
+			w = do(c.method, body, c.ifMatch(current, stale))
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			if c.method == http.MethodPut && c.changed {
+				if etag := w.Header().Get("ETag"); etag == current || etag == stale || etag == "" {
+					t.Errorf("unexpected etag, given = %s, expected a new one", etag)
+				}
+			}
+
+			todos, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			switch {
+			case c.method == http.MethodDelete && c.changed:
+				if len(todos) != 0 {
+					t.Errorf("unexpected todos, given = %d, expected = %d", len(todos), 0)
+				}
+			case len(todos) != 1:
+				t.Errorf("unexpected todos, given = %d, expected = %d", len(todos), 1)
+			case c.method == http.MethodPut && c.changed && todos[0].Subject != "c",
+				!c.changed && todos[0].Subject != "b":
+				t.Errorf("unexpected subject, given = %q, changed = %t", todos[0].Subject, c.changed)
+			}
+		})
+	}
+}
+
+func TestTODORequestContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	rctx, cancel := context.WithCancel(ctx)
+	cancel()
+	r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"subject": "a"}`)).WithContext(rctx)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.NewTODOHandler(svc).ServeHTTP(w, r)
+
+	if w.Code == http.StatusOK {
+		t.Errorf("unexpected status, given = %d, expected an error of the canceled request", w.Code)
+	}
+	todos, err := svc.ReadTODO(ctx, 0, 10)
+	if err != nil {
+		t.Fatal("failed to read todos, err =", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("unexpected todos, given = %d, expected = %d", len(todos), 0)
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A TODOV2Handler implements handling REST endpoints of the API v2. It shares
+// TODOService with TODOHandler and differs in the request and response models.
+type TODOV2Handler struct {
+	todo *TODOHandler
+}
+
+// NewTODOV2Handler returns TODOV2Handler based http.Handler.
+func NewTODOV2Handler(svc *service.TODOService) *TODOV2Handler {
+	return &TODOV2Handler{
+		todo: NewTODOHandler(svc),
+	}
+}
+
+// Create handles the endpoint that creates the TODO.
+func (h *TODOV2Handler) Create(ctx context.Context, req *model.CreateTODORequest) (*model.TODOV2, error) {
+	res, err := h.todo.Create(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewTODOV2(res.TODO, res.Version), nil
+}
+
+// Read handles the endpoint that reads the TODOs.
+func (h *TODOV2Handler) Read(ctx context.Context, req *model.ReadTODORequest) (*model.ReadTODOResponseV2, error) {
+	res, err := h.todo.Read(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newReadTODOResponseV2(res, req.Size), nil
+}
+
+// Update handles the endpoint that updates the TODO. The version in the body
+// is required unless it is nil.
+func (h *TODOV2Handler) Update(ctx context.Context, req *model.UpdateTODORequestV2) (*model.TODOV2, error) {
+	res, err := h.todo.Update(ctx, newUpdateTODORequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return model.NewTODOV2(res.TODO, res.Version), nil
+}
+
+// Delete handles the endpoint that deletes the TODOs.
+func (h *TODOV2Handler) Delete(ctx context.Context, req *model.DeleteTODORequest) error {
+	_, err := h.todo.Delete(ctx, req)
+	return err
+}
+
+func newReadTODOResponseV2(res *model.ReadTODOResponse, size int64) *model.ReadTODOResponseV2 {
+	response := &model.ReadTODOResponseV2{Items: make([]*model.TODOV2, len(res.TODOs))}
+	for i, t := range res.TODOs {
+		response.Items[i] = model.NewTODOV2(t, res.Versions[t.ID])
+	}
+	if n := len(res.TODOs); n > 0 && int64(n) == size {
+		next := res.TODOs[n-1].ID
+		response.NextPrevID = &next
+	}
+	return response
+}
+
+func newUpdateTODORequest(req *model.UpdateTODORequestV2) *model.UpdateTODORequest {
+	return &model.UpdateTODORequest{
+		ID:          int(req.ID),
+		Subject:     req.Subject,
+		Description: req.Description,
+		Version:     req.Version,
+	}
+}
+
+func (h *TODOV2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	w.Header().Add("Vary", "Accept")
+	c, err := negotiate(r.Header.Get("Accept"), false)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		request, err := parseReadTODORequest(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if len(request.Fields) > 0 || len(request.Include) > 0 {
+			WriteError(w, r, model.ErrValidation{Message: "fields and include are not supported by v2"})
+			return
+		}
+
+		res, err := h.todo.Read(ctx, request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		if checkNotModified(w, r, listETag(res, "v2 "+c.mediaTypes[0]), res.LastModified) {
+			return
+		}
+
+		writeResponse(w, c, http.StatusOK, newReadTODOResponseV2(res, request.Size))
+
+	case http.MethodPost:
+		var request model.CreateTODORequest
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		response, err := h.Create(ctx, &request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", todoETag(response.ID, response.Version))
+		writeResponse(w, c, http.StatusCreated, response)
+
+	case http.MethodPut:
+		var request model.UpdateTODORequestV2
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		// If-Match takes precedence over the version in the body
+		update := newUpdateTODORequest(&request)
+		if err := setUpdateIfMatch(update, r.Header.Get("If-Match")); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		res, err := h.todo.Update(ctx, update)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", todoETag(res.TODO.ID, res.Version))
+		writeResponse(w, c, http.StatusOK, model.NewTODOV2(res.TODO, res.Version))
+
+	case http.MethodDelete:
+		var request model.DeleteTODORequest
+		if err := decodeRequest(r, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		setDeleteIfMatch(&request, r.Header.Get("If-Match"))
+
+		if err := h.Delete(ctx, &request); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
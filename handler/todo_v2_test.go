@@ -0,0 +1,95 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestTODOV2(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		method string
+		target string
+		body   string
+		status int
+		// todos is the number of TODOs after the request
+		todos int
+	}{
+		"Create":         {method: http.MethodPost, target: "/v2/todos", body: `{"subject": "b"}`, status: http.StatusCreated, todos: 2},
+		"Read":           {method: http.MethodGet, target: "/v2/todos?size=1", status: http.StatusOK, todos: 1},
+		"Read fields":    {method: http.MethodGet, target: "/v2/todos?fields=id", status: http.StatusBadRequest, todos: 1},
+		"Read include":   {method: http.MethodGet, target: "/v2/todos?include=count", status: http.StatusBadRequest, todos: 1},
+		"Update":         {method: http.MethodPut, target: "/v2/todos", body: `{"id": 1, "subject": "b", "version": 0}`, status: http.StatusOK, todos: 1},
+		"Update stale":   {method: http.MethodPut, target: "/v2/todos", body: `{"id": 1, "subject": "b", "version": 1}`, status: http.StatusPreconditionFailed, todos: 1},
+		"Delete":         {method: http.MethodDelete, target: "/v2/todos", body: `{"ids": [1]}`, status: http.StatusNoContent},
+		"Delete missing": {method: http.MethodDelete, target: "/v2/todos", body: `{"ids": [2]}`, status: http.StatusNotFound, todos: 1},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+			if c.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			handler.NewTODOV2Handler(svc).ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d, body = %s", w.Code, c.status, w.Body)
+			}
+			switch c.status {
+			case http.StatusCreated:
+				var td model.TODOV2
+				if err := json.NewDecoder(w.Body).Decode(&td); err != nil {
+					t.Fatal("failed to decode response, err =", err)
+				}
+				if td.ID != 2 || td.Version != 0 {
+					t.Errorf("unexpected todo, given = %+v, expected id = %d, version = %d", td, 2, 0)
+				}
+				if etag := w.Header().Get("ETag"); etag != `"2-0"` {
+					t.Errorf("unexpected etag, given = %s, expected = %s", etag, `"2-0"`)
+				}
+			case http.StatusOK:
+				if c.method != http.MethodGet {
+					break
+				}
+				var res model.ReadTODOResponseV2
+				if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+					t.Fatal("failed to decode response, err =", err)
+				}
+				if len(res.Items) != 1 || res.Items[0].Version != 0 || res.NextPrevID == nil || *res.NextPrevID != 1 {
+					t.Errorf("unexpected response, given = %+v, expected one item of version 0 and next_prev_id = 1", res)
+				}
+			case http.StatusNoContent:
+				if w.Body.Len() != 0 {
+					t.Errorf("unexpected body, given = %s, expected none", w.Body)
+				}
+			}
+
+			todos, err := svc.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if len(todos) != c.todos {
+				t.Errorf("unexpected todos, given = %d, expected = %d", len(todos), c.todos)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// TodoTxtContentType is the media type of todo.txt files.
+	TodoTxtContentType = "text/plain"
+
+	todoTxtDateLayout = "2006-01-02"
+)
+
+var (
+	// todoTxtPattern splits a todo.txt line into the completion mark with its
+	// date, the priority, the creation date and the text.
+	todoTxtPattern = regexp.MustCompile(`^(x \d{4}-\d{2}-\d{2} )?(\([A-Z]\) )?(?:(\d{4}-\d{2}-\d{2}) )?(.*)$`)
+
+	// todoTxtKeyPattern matches the id and description keys in the text, which
+	// carry the fields of TODO that todo.txt does not have. When a key occurs
+	// more than once, the last one carries the field.
+	todoTxtKeyPattern = regexp.MustCompile(`(?:^| )(id|description):(\S*)`)
+)
+
+// A TodoTxtHandler implements exporting and importing TODOs as todo.txt lines.
+//
+// The completion mark and the priority of a line are kept at the head of the
+// subject, and +project, @context and the other key:value pairs in it, so that
+// they round-trip. The id and the description are written as the id: and
+// description: keys, and the creation date as the date of created_at.
+type TodoTxtHandler struct {
+	svc *service.TODOService
+}
+
+// NewTodoTxtHandler returns TodoTxtHandler based http.Handler.
+func NewTodoTxtHandler(svc *service.TODOService) *TodoTxtHandler {
+	return &TodoTxtHandler{
+		svc: svc,
+	}
+}
+
+// A todoTxtLine expresses a parsed todo.txt line.
+type todoTxtLine struct {
+	// ID is the value of the id: key, 0 when the line has none.
+	ID          int64
+	Subject     string
+	Description string
+	// CreatedAt is the creation date, zero when the line has none.
+	CreatedAt time.Time
+}
+
+// Import handles the endpoint that upserts a TODO for every line of the
+// todo.txt data. Lines with the id of an existing TODO update it, and the other
+// lines create TODOs.
+func (h *TodoTxtHandler) Import(ctx context.Context, data []byte) (*model.ImportTODOResponse, error) {
+	response := &model.ImportTODOResponse{
+		Imported: make([]*model.TODO, 0),
+		Updated:  make([]*model.TODO, 0),
+		Skipped:  make([]*model.SkippedImport, 0),
+	}
+
+	err := h.svc.RunInTx(ctx, func(ctx context.Context) error {
+		s := bufio.NewScanner(bytes.NewReader(data))
+		// escaped descriptions make lines longer than the default limit
+		s.Buffer(nil, 1<<20)
+		for i := 0; s.Scan(); i++ {
+			if strings.TrimSpace(s.Text()) == "" {
+				continue
+			}
+
+			line, err := parseTodoTxtLine(s.Text())
+			if err == nil {
+				req := &model.CreateTODORequest{Subject: line.Subject, Description: line.Description}
+				err = model.Validate(req)
+				line.Subject, line.Description = req.Subject, req.Description
+			}
+			if err != nil {
+				skipped := &model.SkippedImport{Index: i, Reason: err.Error()}
+				if line != nil && line.ID != 0 {
+					skipped.ID = strconv.FormatInt(line.ID, 10)
+				}
+				response.Skipped = append(response.Skipped, skipped)
+				continue
+			}
+
+			t, updated, err := h.upsert(ctx, line)
+			if err != nil {
+				return err
+			}
+			switch {
+			case t == nil:
+				// the TODO is up to date
+			case updated:
+				response.Updated = append(response.Updated, t)
+			default:
+				response.Imported = append(response.Imported, t)
+			}
+		}
+		return s.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// upsert updates the TODO of line.ID when it exists and differs from line, or
+// creates a TODO of line. It returns nil when the TODO is up to date.
+func (h *TodoTxtHandler) upsert(ctx context.Context, line *todoTxtLine) (*model.TODO, bool, error) {
+	if line.ID != 0 {
+		t, _, err := h.svc.GetTODO(ctx, line.ID)
+		switch {
+		case err == nil:
+			if t.Subject == line.Subject && t.Description == line.Description {
+				return nil, false, nil
+			}
+			t, err = h.svc.UpdateTODO(ctx, line.ID, line.Subject, line.Description)
+			return t, true, err
+		case errors.As(err, &model.ErrNotFound{}):
+			break
+		default:
+			return nil, false, err
+		}
+	}
+
+	now := time.Now()
+	createdAt := line.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	t, err := h.svc.CreateTODOAt(ctx, line.Subject, line.Description, createdAt, now)
+	return t, false, err
+}
+
+func (h *TodoTxtHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		response, err := readAll(ctx, h.svc)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		if checkNotModified(w, r, listETag(response, "text/x-todo-txt"), response.LastModified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", TodoTxtContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="todo.txt"`)
+		w.WriteHeader(http.StatusOK)
+
+		for _, t := range response.TODOs {
+			if _, err := io.WriteString(w, formatTodoTxtLine(t)+"\n"); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+	case http.MethodPost:
+		w.Header().Add("Vary", "Accept")
+		c, err := negotiate(r.Header.Get("Accept"), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != TodoTxtContentType {
+				WriteError(w, r, model.ErrUnsupportedMediaType{})
+				return
+			}
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, model.ErrValidation{Message: err.Error()})
+			return
+		}
+
+		response, err := h.Import(ctx, data)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		writeResponse(w, c, http.StatusOK, response)
+
+	default:
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+	}
+}
+
+// formatTodoTxtLine returns the todo.txt line of t.
+func formatTodoTxtLine(t *model.TODO) string {
+	m := todoTxtPattern.FindStringSubmatch(t.Subject)
+	completion, priority, text := m[1], m[2], t.Subject[len(m[1])+len(m[2]):]
+
+	var b strings.Builder
+	b.WriteString(completion)
+	b.WriteString(priority)
+	b.WriteString(t.CreatedAt.In(time.Local).Format(todoTxtDateLayout))
+	b.WriteString(" ")
+	b.WriteString(text)
+	fmt.Fprintf(&b, " id:%d", t.ID)
+	// a description: key in the subject is kept by writing the key after it
+	if t.Description != "" || todoTxtHasKey(text, "description") {
+		b.WriteString(" description:")
+		b.WriteString(url.PathEscape(t.Description))
+	}
+	return b.String()
+}
+
+// todoTxtHasKey reports whether text has the key.
+func todoTxtHasKey(text, key string) bool {
+	for _, sm := range todoTxtKeyPattern.FindAllStringSubmatch(text, -1) {
+		if sm[1] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTodoTxtLine parses the todo.txt line s.
+func parseTodoTxtLine(s string) (*todoTxtLine, error) {
+	m := todoTxtPattern.FindStringSubmatch(strings.TrimRight(s, "\r"))
+	line := &todoTxtLine{}
+
+	if m[3] != "" {
+		createdAt, err := time.ParseInLocation(todoTxtDateLayout, m[3], time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("creation date %s is invalid", m[3])
+		}
+		line.CreatedAt = createdAt
+	}
+
+	// the last id: and description: keys carry the fields, the earlier ones
+	// are part of the subject
+	text := m[4]
+	last := map[string][]int{}
+	for _, sm := range todoTxtKeyPattern.FindAllStringSubmatchIndex(text, -1) {
+		last[text[sm[2]:sm[3]]] = sm
+	}
+	if sm, ok := last["id"]; ok {
+		value := text[sm[4]:sm[5]]
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			return line, fmt.Errorf("id:%s is invalid", value)
+		}
+		line.ID = id
+	}
+	if sm, ok := last["description"]; ok {
+		description, err := url.PathUnescape(text[sm[4]:sm[5]])
+		if err != nil {
+			return line, fmt.Errorf("description is invalid: %v", err)
+		}
+		line.Description = description
+	}
+	// the later key is cut first so that the span of the other stays valid
+	spans := [][]int{last["id"], last["description"]}
+	if spans[0] != nil && spans[1] != nil && spans[0][0] < spans[1][0] {
+		spans[0], spans[1] = spans[1], spans[0]
+	}
+	for _, sm := range spans {
+		if sm != nil {
+			text = text[:sm[0]] + text[sm[1]:]
+		}
+	}
+
+	line.Subject = m[1] + m[2] + text
+	return line, nil
+}
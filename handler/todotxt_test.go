@@ -0,0 +1,145 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+)
+
+func TestTodoTxtImport(t *testing.T) {
+	t.Parallel()
+
+	type imported struct {
+		subject, description string
+		createdAt            time.Time
+	}
+	cases := map[string]struct {
+		data     string
+		imported []imported
+		skipped  int
+	}{
+		"Plain": {
+			data:     "call mom\n",
+			imported: []imported{{subject: "call mom"}},
+		},
+		"Priority, dates, projects and contexts": {
+			data:     "(A) 2020-01-02 call mom +family @phone due:2020-02-01\n",
+			imported: []imported{{subject: "(A) call mom +family @phone due:2020-02-01", createdAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)}},
+		},
+		"Completed": {
+			data:     "x 2020-01-03 2020-01-02 done\n",
+			imported: []imported{{subject: "x 2020-01-03 done", createdAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)}},
+		},
+		"Description": {
+			data:     "a description:line%201%0Aline%202\n",
+			imported: []imported{{subject: "a", description: "line 1\nline 2"}},
+		},
+		"Blank lines and CRLF": {
+			data:     "a\r\n\r\nb\r\n",
+			imported: []imported{{subject: "a"}, {subject: "b"}},
+		},
+		"Invalid lines skipped": {
+			data:     "a id:x\n2020-13-01 b\n a description:%zz\nc\n",
+			imported: []imported{{subject: "c"}},
+			skipped:  3,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			response, err := handler.NewTodoTxtHandler(newTestService(t)).Import(context.Background(), []byte(c.data))
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+			if len(response.Skipped) != c.skipped {
+				t.Errorf("unexpected skipped, given = %d, expected = %d", len(response.Skipped), c.skipped)
+			}
+			if len(response.Imported) != len(c.imported) {
+				t.Fatalf("unexpected imported, given = %d, expected = %d", len(response.Imported), len(c.imported))
+			}
+			for i, got := range response.Imported {
+				want := c.imported[i]
+				if got.Subject != want.subject || got.Description != want.description {
+					t.Errorf("unexpected todo, given = %q %q, expected = %q %q", got.Subject, got.Description, want.subject, want.description)
+				}
+				if !want.createdAt.IsZero() && !got.CreatedAt.Equal(want.createdAt) {
+					t.Errorf("unexpected created_at, given = %s, expected = %s", got.CreatedAt, want.createdAt)
+				}
+			}
+		})
+	}
+}
+
+func TestTodoTxtRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		subject, description string
+	}{
+		"Plain":                     {subject: "call mom"},
+		"Priority":                  {subject: "(A) call mom +family @phone"},
+		"Completed":                 {subject: "x 2020-01-03 (B) done due:2020-01-01"},
+		"Date in subject":           {subject: "2020-01-01 is a holiday"},
+		"Description":               {subject: "a", description: "line 1\nline 2 100% done\tok"},
+		"Key in subject":            {subject: "see id:abc and description:xyz"},
+		"Key at the end of subject": {subject: "see description:xyz"},
+		"Key with description":      {subject: "see id:7", description: "d"},
+		"Multi-byte":                {subject: "牛乳を買う @スーパー", description: "2本"},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			created, err := svc.CreateTODO(ctx, c.subject, c.description)
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			h := handler.NewTodoTxtHandler(svc)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todo.txt", nil))
+			exported := w.Body.String()
+			if strings.Count(exported, "\n") != 1 {
+				t.Fatalf("unexpected export, given = %q, expected one line", exported)
+			}
+
+			// the line of an existing TODO is up to date
+			response, err := h.Import(ctx, []byte(exported))
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+			if len(response.Imported)+len(response.Updated)+len(response.Skipped) != 0 {
+				t.Errorf("unexpected import of %q, given = %d imported, %d updated, %d skipped, expected = none",
+					exported, len(response.Imported), len(response.Updated), len(response.Skipped))
+			}
+
+			// the line creates the same TODO in another database
+			response, err = handler.NewTodoTxtHandler(newTestService(t)).Import(ctx, []byte(exported))
+			if err != nil {
+				t.Fatal("failed to import, err =", err)
+			}
+			if len(response.Imported) != 1 {
+				t.Fatalf("unexpected import of %q, given = %d imported, expected = 1", exported, len(response.Imported))
+			}
+			got := response.Imported[0]
+			if got.Subject != c.subject || got.Description != c.description {
+				t.Errorf("unexpected todo of %q, given = %q %q, expected = %q %q", exported, got.Subject, got.Description, c.subject, c.description)
+			}
+			if y, m, d := got.CreatedAt.In(time.Local).Date(); y != created.CreatedAt.In(time.Local).Year() || m != created.CreatedAt.In(time.Local).Month() || d != created.CreatedAt.In(time.Local).Day() {
+				t.Errorf("unexpected created_at, given = %s, expected the date of %s", got.CreatedAt, created.CreatedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A VersionHandler routes requests of an unversioned path to the handler of
+// the API version in the version parameter of Accept, such as
+// "application/json; version=2". Requests without it are served by v1.
+type VersionHandler struct {
+	versions map[string]http.Handler
+}
+
+// NewVersionHandler returns VersionHandler based http.Handler.
+func NewVersionHandler(v1, v2 http.Handler) *VersionHandler {
+	return &VersionHandler{
+		versions: map[string]http.Handler{
+			"1": v1,
+			"2": v2,
+		},
+	}
+}
+
+func (h *VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	version := acceptVersion(r.Header.Get("Accept"))
+	if version == "" {
+		version = "1"
+	}
+
+	vh, ok := h.versions[version]
+	if !ok {
+		w.Header().Add("Vary", "Accept")
+		WriteError(w, r, model.ErrNotAcceptable{})
+		return
+	}
+	vh.ServeHTTP(w, r)
+}
+
+// acceptVersion returns the first version parameter in the Accept header
+// without its "v" prefix.
+func acceptVersion(accept string) string {
+	for _, s := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(s)
+		if err != nil {
+			continue
+		}
+		if v := params["version"]; v != "" {
+			return strings.TrimPrefix(v, "v")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+)
+
+func TestVersionHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		accept string
+		status int
+		// version is the body written by the handler of the version
+		version string
+	}{
+		"No Accept":           {status: http.StatusOK, version: "v1"},
+		"No version":          {accept: "application/json", status: http.StatusOK, version: "v1"},
+		"Version 1":           {accept: "application/json; version=1", status: http.StatusOK, version: "v1"},
+		"Version 2":           {accept: "application/json; version=2", status: http.StatusOK, version: "v2"},
+		"Prefixed version":    {accept: "application/json;version=v2", status: http.StatusOK, version: "v2"},
+		"First version wins":  {accept: "text/csv, application/json; version=2, application/json; version=1", status: http.StatusOK, version: "v2"},
+		"Invalid media range": {accept: "application/json; version=2; =, text/csv; version=1", status: http.StatusOK, version: "v1"},
+		"Unknown version":     {accept: "application/json; version=3", status: http.StatusNotAcceptable},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			version := func(v string) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.WriteString(w, v)
+				})
+			}
+			h := handler.NewVersionHandler(version("v1"), version("v2"))
+
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if c.accept != "" {
+				r.Header.Set("Accept", c.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("unexpected status, given = %d, expected = %d", w.Code, c.status)
+			}
+			if c.status != http.StatusOK {
+				if vary := w.Header().Get("Vary"); vary != "Accept" {
+					t.Errorf("unexpected Vary, given = %q, expected = %q", vary, "Accept")
+				}
+				return
+			}
+			if w.Body.String() != c.version {
+				t.Errorf("unexpected version, given = %s, expected = %s", w.Body, c.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A WebhookHandler implements the admin endpoints that manage the webhooks.
+type WebhookHandler struct {
+	svc *service.WebhookService
+}
+
+// NewWebhookHandler returns WebhookHandler based http.Handler.
+func NewWebhookHandler(svc *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		svc: svc,
+	}
+}
+
+// Create handles the endpoint that creates the webhook.
+func (h *WebhookHandler) Create(ctx context.Context, req *model.CreateWebhookRequest) (*model.CreateWebhookResponse, error) {
+	if err := checkWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	w, err := h.svc.CreateWebhook(ctx, req.URL, req.EventTypes, req.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreateWebhookResponse{Webhook: w}, nil
+}
+
+// Read handles the endpoint that reads the webhooks.
+func (h *WebhookHandler) Read(ctx context.Context) (*model.ReadWebhookResponse, error) {
+	webhooks, err := h.svc.ReadWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReadWebhookResponse{Webhooks: webhooks}, nil
+}
+
+// Update handles the endpoint that updates the webhook.
+func (h *WebhookHandler) Update(ctx context.Context, req *model.UpdateWebhookRequest) (*model.UpdateWebhookResponse, error) {
+	if err := checkWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	w, err := h.svc.UpdateWebhook(ctx, req.ID, req.URL, req.EventTypes, req.Secret, req.Active)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UpdateWebhookResponse{Webhook: w}, nil
+}
+
+// Delete handles the endpoint that deletes the webhooks.
+func (h *WebhookHandler) Delete(ctx context.Context, req *model.DeleteWebhookRequest) (*model.DeleteWebhookResponse, error) {
+	if err := h.svc.DeleteWebhook(ctx, req.IDs); err != nil {
+		return nil, err
+	}
+	return &model.DeleteWebhookResponse{}, nil
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	w.Header().Add("Vary", "Accept")
+	c, err := negotiate(r.Header.Get("Accept"), false)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		response, err = h.Read(ctx)
+
+	case http.MethodPost:
+		var request model.CreateWebhookRequest
+		if err = decodeRequest(r, &request); err == nil {
+			response, err = h.Create(ctx, &request)
+		}
+
+	case http.MethodPut:
+		var request model.UpdateWebhookRequest
+		if err = decodeRequest(r, &request); err == nil {
+			response, err = h.Update(ctx, &request)
+		}
+
+	case http.MethodDelete:
+		var request model.DeleteWebhookRequest
+		if err = decodeRequest(r, &request); err == nil {
+			response, err = h.Delete(ctx, &request)
+		}
+
+	default:
+		err = model.ErrMethodNotAllowed{}
+	}
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResponse(w, c, http.StatusOK, response)
+}
+
+// checkWebhookURL rejects the URLs other than absolute http and https ones.
+// Private addresses are allowed as the webhooks are managed by admins.
+func checkWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return nil
+	}
+	return model.ErrValidation{
+		Message:       "request has invalid fields",
+		InvalidParams: []*model.InvalidParam{{Name: "url", Reason: "must be an absolute http or https URL"}},
+	}
+}
+
+// A WebhookDeliveryHandler implements the admin endpoints that read the log of
+// the webhook deliveries and redeliver them.
+type WebhookDeliveryHandler struct {
+	svc *service.WebhookService
+}
+
+// NewWebhookDeliveryHandler returns WebhookDeliveryHandler based http.Handler.
+func NewWebhookDeliveryHandler(svc *service.WebhookService) *WebhookDeliveryHandler {
+	return &WebhookDeliveryHandler{
+		svc: svc,
+	}
+}
+
+// Read handles the endpoint that reads the deliveries with their attempts.
+func (h *WebhookDeliveryHandler) Read(ctx context.Context, req *model.ReadWebhookDeliveryRequest) (*model.ReadWebhookDeliveryResponse, error) {
+	deliveries, err := h.svc.ReadDeliveries(ctx, req.WebhookID, req.Status, req.PrevID, req.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReadWebhookDeliveryResponse{Deliveries: deliveries}, nil
+}
+
+// Redeliver handles the endpoint that sends the event of the delivery again.
+func (h *WebhookDeliveryHandler) Redeliver(ctx context.Context, req *model.RedeliverWebhookRequest) (*model.RedeliverWebhookResponse, error) {
+	d, err := h.svc.Redeliver(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RedeliverWebhookResponse{Delivery: d}, nil
+}
+
+func (h *WebhookDeliveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	w.Header().Add("Vary", "Accept")
+	c, err := negotiate(r.Header.Get("Accept"), false)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		var request *model.ReadWebhookDeliveryRequest
+		if request, err = parseReadWebhookDeliveryRequest(r); err == nil {
+			response, err = h.Read(ctx, request)
+		}
+
+	case http.MethodPost:
+		var request model.RedeliverWebhookRequest
+		if err = decodeRequest(r, &request); err == nil {
+			response, err = h.Redeliver(ctx, &request)
+		}
+
+	default:
+		err = model.ErrMethodNotAllowed{}
+	}
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResponse(w, c, http.StatusOK, response)
+}
+
+// parseReadWebhookDeliveryRequest returns the validated
+// ReadWebhookDeliveryRequest in the query of r.
+func parseReadWebhookDeliveryRequest(r *http.Request) (*model.ReadWebhookDeliveryRequest, error) {
+	q := r.URL.Query()
+	request := &model.ReadWebhookDeliveryRequest{
+		Status: q.Get("status"),
+		Size:   defaultReadSize,
+	}
+
+	params := []struct {
+		name  string
+		value *int64
+	}{
+		{name: "webhook_id", value: &request.WebhookID},
+		{name: "prev_id", value: &request.PrevID},
+		{name: "size", value: &request.Size},
+	}
+	for _, p := range params {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, model.ErrValidation{Message: p.name + " must be an integer"}
+		}
+		*p.value = n
+	}
+
+	if err := model.Validate(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPingInterval is the interval of the pings detecting dead connections.
+	wsPingInterval = 30 * time.Second
+	// wsPongWait is how long a connection is kept without a message or a pong.
+	wsPongWait = 2 * wsPingInterval
+	// wsWriteWait is how long a message takes to be written at most.
+	wsWriteWait = 10 * time.Second
+	// wsMaxMessageSize is the size of the largest command accepted.
+	wsMaxMessageSize = 1 << 20
+	// wsMaxSubscriptions is the number of subscriptions a connection can have.
+	wsMaxSubscriptions = 32
+)
+
+// A WebSocketHandler implements the WebSocket API, where clients subscribe to
+// the TODOs matching filters, receiving a snapshot of them and the diffs of
+// their changes, and send commands creating, updating and deleting TODOs.
+type WebSocketHandler struct {
+	todo     *TODOHandler
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	conns  sync.WaitGroup
+}
+
+// NewWebSocketHandler returns WebSocketHandler based http.Handler. Connections
+// are accepted from the same origin and the allowedOrigins, "*" allowing any.
+func NewWebSocketHandler(svc *service.TODOService, allowedOrigins []string) *WebSocketHandler {
+	return &WebSocketHandler{
+		todo: NewTODOHandler(svc),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return checkWebSocketOrigin(r, allowedOrigins)
+			},
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// Close closes the connections being served with the going away status, and
+// waits for them. It is called after http.Server.Shutdown, which does not
+// track hijacked connections.
+func (h *WebSocketHandler) Close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+	h.mu.Unlock()
+
+	h.conns.Wait()
+}
+
+// track adds a connection to the ones Close waits for, unless it is closed.
+func (h *WebSocketHandler) track() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.conns.Add(1)
+	return true
+}
+
+// A wsSubscription expresses a subscription of a connection with the ids of
+// the TODOs the client has been sent.
+type wsSubscription struct {
+	filter *model.TODOFilter
+	ids    map[int64]bool
+}
+
+// A wsInbound expresses a command read from a connection, or the error of
+// decoding it.
+type wsInbound struct {
+	command *model.WSCommand
+	err     error
+}
+
+// A wsSession expresses the state of a connection. Its methods are called by
+// the goroutine serving the connection, which is the only one writing to it.
+type wsSession struct {
+	h    *WebSocketHandler
+	conn *websocket.Conn
+	subs map[string]*wsSubscription
+	// cursor is the id of the last event applied to the subscriptions.
+	cursor int64
+	seq    int
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, model.ErrMethodNotAllowed{})
+		return
+	}
+
+	// subscribe before reading the log so that no event is missed in between
+	notified, cancel := h.todo.svc.SubscribeEvents()
+	defer cancel()
+
+	ctx := r.Context()
+	_, last, err := h.todo.svc.EventLogBounds(ctx)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if !h.track() {
+		w.Header().Set("Connection", "close")
+		WriteError(w, r, fmt.Errorf("websocket: server is shutting down"))
+		return
+	}
+	defer h.conns.Done()
+
+	// Upgrade replies the errors of handshakes by itself
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	s := &wsSession{
+		h:      h,
+		conn:   conn,
+		subs:   make(map[string]*wsSubscription),
+		cursor: last,
+	}
+
+	inbound := make(chan wsInbound)
+	readErr := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go s.read(inbound, readErr, quit)
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case in := <-inbound:
+			err = s.handle(ctx, in)
+		case <-notified:
+			err = s.drain(ctx, 0)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case err := <-readErr:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println(err)
+			}
+			return
+		case <-h.done:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// read reads the commands from the connection until it fails.
+func (s *wsSession) read(inbound chan<- wsInbound, readErr chan<- error, quit <-chan struct{}) {
+	s.conn.SetReadLimit(wsMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, b, err := s.conn.ReadMessage()
+		if err != nil {
+			readErr <- err
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var command model.WSCommand
+		err = unmarshalRequest(b, &command)
+		select {
+		case inbound <- wsInbound{command: &command, err: err}:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// handle runs the command and replies its result or error. Only the errors of
+// the connection are returned.
+func (s *wsSession) handle(ctx context.Context, in wsInbound) error {
+	cmd := in.command
+	var reply interface{}
+	err := in.err
+	if err == nil {
+		reply, err = s.run(ctx, cmd)
+	}
+	if err != nil {
+		log.Println(err)
+		reply = &model.WSErrorMessage{ID: cmd.ID, Type: model.WSError, Error: NewProblem(err)}
+	}
+	return s.write(reply)
+}
+
+func (s *wsSession) run(ctx context.Context, cmd *model.WSCommand) (interface{}, error) {
+	switch cmd.Type {
+	case model.WSSubscribe:
+		return s.subscribe(ctx, cmd)
+
+	case model.WSUnsubscribe:
+		if _, ok := s.subs[cmd.Subscription]; !ok {
+			return nil, model.ErrNotFound{}
+		}
+		delete(s.subs, cmd.Subscription)
+		return &model.WSResultMessage{ID: cmd.ID, Type: model.WSResult}, nil
+
+	case model.WSCreate:
+		var req model.CreateTODORequest
+		if err := unmarshalPayload(cmd.Payload, &req); err != nil {
+			return nil, err
+		}
+		res, err := s.h.todo.Create(ctx, &req)
+		if err != nil {
+			return nil, err
+		}
+		return &model.WSResultMessage{ID: cmd.ID, Type: model.WSResult, TODO: res.TODO, Version: &res.Version}, nil
+
+	case model.WSUpdate:
+		var req model.UpdateTODORequestV2
+		if err := unmarshalPayload(cmd.Payload, &req); err != nil {
+			return nil, err
+		}
+		res, err := s.h.todo.Update(ctx, newUpdateTODORequest(&req))
+		if err != nil {
+			return nil, err
+		}
+		return &model.WSResultMessage{ID: cmd.ID, Type: model.WSResult, TODO: res.TODO, Version: &res.Version}, nil
+
+	case model.WSDelete:
+		var req model.DeleteTODORequest
+		if err := unmarshalPayload(cmd.Payload, &req); err != nil {
+			return nil, err
+		}
+		if _, err := s.h.todo.Delete(ctx, &req); err != nil {
+			return nil, err
+		}
+		return &model.WSResultMessage{ID: cmd.ID, Type: model.WSResult}, nil
+	}
+
+	return nil, model.ErrValidation{Message: fmt.Sprintf("unknown command %q", cmd.Type)}
+}
+
+// subscribe adds the subscription of the command and returns the snapshot of
+// it. The events logged before the snapshot are applied to the other
+// subscriptions first, so that every subscription follows the same events.
+func (s *wsSession) subscribe(ctx context.Context, cmd *model.WSCommand) (interface{}, error) {
+	filter := cmd.Filter
+	if filter == nil {
+		filter = &model.TODOFilter{}
+	}
+	if err := model.Validate(filter); err != nil {
+		return nil, err
+	}
+
+	name := cmd.Subscription
+	if name == "" {
+		s.seq++
+		name = fmt.Sprintf("s%d", s.seq)
+	}
+	if _, ok := s.subs[name]; ok {
+		return nil, model.ErrConflict{Message: fmt.Sprintf("subscription %s already exists", name)}
+	}
+	if len(s.subs) >= wsMaxSubscriptions {
+		return nil, model.ErrValidation{Message: fmt.Sprintf("a connection has %d subscriptions at most", wsMaxSubscriptions)}
+	}
+
+	sub := &wsSubscription{filter: filter, ids: make(map[int64]bool)}
+	snapshot := &model.WSSnapshotMessage{ID: cmd.ID, Type: model.WSSnapshot, Subscription: name, TODOs: []*model.TODO{}}
+	var last int64
+	err := s.h.todo.svc.RunInReadTx(ctx, func(ctx context.Context) error {
+		var prevID int64
+		for {
+			todos, _, err := s.h.todo.svc.FindTODOs(ctx, filter, prevID, exportPageSize)
+			if err != nil {
+				return err
+			}
+			for _, t := range todos {
+				sub.ids[t.ID] = true
+			}
+			snapshot.TODOs = append(snapshot.TODOs, todos...)
+			if len(todos) < exportPageSize {
+				break
+			}
+			prevID = todos[len(todos)-1].ID
+		}
+
+		var err error
+		_, last, err = s.h.todo.svc.EventLogBounds(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.drain(ctx, last); err != nil {
+		return nil, err
+	}
+	s.subs[name] = sub
+
+	return snapshot, nil
+}
+
+// drain applies the events after the cursor to the subscriptions and writes
+// their diffs. upTo limits the events applied, 0 applying all of them.
+func (s *wsSession) drain(ctx context.Context, upTo int64) error {
+	names := make([]string, 0, len(s.subs))
+	for name := range s.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for {
+		events, err := s.h.todo.svc.ReadEvents(ctx, &model.ReadEventsRequest{}, s.cursor, eventPageSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if upTo > 0 && e.ID > upTo {
+				return nil
+			}
+			for _, name := range names {
+				if diff := s.subs[name].apply(e); diff != nil {
+					diff.Subscription = name
+					if err := s.write(diff); err != nil {
+						return err
+					}
+				}
+			}
+			s.cursor = e.ID
+		}
+		if len(events) < eventPageSize {
+			return nil
+		}
+	}
+}
+
+// apply returns the diff of the subscription by the event, nil when the event
+// does not change the TODOs matching it.
+func (sub *wsSubscription) apply(e *model.TODOEvent) *model.WSDiffMessage {
+	diff := &model.WSDiffMessage{Type: model.WSDiff, TODOID: e.TODOID, EventID: e.ID}
+	had := sub.ids[e.TODOID]
+	matches := e.TODO != nil && sub.filter.Match(e.TODO)
+
+	switch {
+	case matches && had:
+		diff.Op, diff.TODO = model.WSChanged, e.TODO
+	case matches:
+		diff.Op, diff.TODO = model.WSAdded, e.TODO
+		sub.ids[e.TODOID] = true
+	case had:
+		diff.Op = model.WSRemoved
+		delete(sub.ids, e.TODOID)
+	default:
+		return nil
+	}
+	return diff
+}
+
+func (s *wsSession) write(v interface{}) error {
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(v)
+}
+
+// unmarshalPayload decodes the payload of a command like a request body.
+func unmarshalPayload(payload json.RawMessage, v interface{}) error {
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	return unmarshalRequest(payload, v)
+}
+
+// checkWebSocketOrigin reports whether the Origin of the handshake is the
+// host of r or one of the allowed origins. Requests without Origin are not
+// made by browsers and allowed.
+func checkWebSocketOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
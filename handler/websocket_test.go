@@ -0,0 +1,308 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/gorilla/websocket"
+)
+
+// A wsMessage expresses any message of the WebSocket API.
+type wsMessage struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Subscription string         `json:"subscription"`
+	TODOs        []*model.TODO  `json:"todos"`
+	Op           string         `json:"op"`
+	TODOID       int64          `json:"todo_id"`
+	TODO         *model.TODO    `json:"todo"`
+	Version      *int64         `json:"version"`
+	Error        *model.Problem `json:"error"`
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		filter   string
+		snapshot []int64
+		// diffs are the diffs received as "<op> <todo id>"
+		diffs []string
+	}{
+		"All": {
+			snapshot: []int64{3, 2, 1},
+			diffs:    []string{"changed 2", "changed 1", "added 4", "removed 3"},
+		},
+		"Query": {
+			filter:   `{"query": "ALPHA"}`,
+			snapshot: []int64{3, 1},
+			diffs:    []string{"added 2", "removed 1", "removed 3"},
+		},
+		"IDs": {
+			filter:   `{"ids": [2]}`,
+			snapshot: []int64{2},
+			diffs:    []string{"changed 2"},
+		},
+		"Query and IDs": {
+			filter:   `{"ids": [1, 2], "query": "alpha"}`,
+			snapshot: []int64{1},
+			diffs:    []string{"added 2", "removed 1"},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			for _, subject := range []string{"alpha", "beta", "alphabet"} {
+				if _, err := svc.CreateTODO(ctx, subject, ""); err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+			}
+
+			conn := dialWebSocket(t, handler.NewWebSocketHandler(svc, nil))
+			command := `{"id": "1", "type": "subscribe", "subscription": "s"}`
+			if c.filter != "" {
+				command = fmt.Sprintf(`{"id": "1", "type": "subscribe", "subscription": "s", "filter": %s}`, c.filter)
+			}
+			snapshot := sendWebSocket(t, conn, command)
+			if snapshot.Type != model.WSSnapshot || snapshot.ID != "1" || snapshot.Subscription != "s" {
+				t.Fatalf("unexpected message, given = %+v, expected the snapshot of s", snapshot)
+			}
+			var ids []int64
+			for _, td := range snapshot.TODOs {
+				ids = append(ids, td.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(c.snapshot) {
+				t.Errorf("unexpected snapshot, given = %v, expected = %v", ids, c.snapshot)
+			}
+
+			if _, err := svc.UpdateTODO(ctx, 2, "alpha beta", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			if _, err := svc.UpdateTODO(ctx, 1, "omega", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			if _, err := svc.CreateTODO(ctx, "gamma", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if err := svc.DeleteTODO(ctx, []int64{3}); err != nil {
+				t.Fatal("failed to delete todo, err =", err)
+			}
+
+			var diffs []string
+			for len(diffs) < len(c.diffs) {
+				m := readWebSocket(t, conn)
+				if m.Type != model.WSDiff || m.Subscription != "s" {
+					t.Fatalf("unexpected message, given = %+v, expected a diff of s", m)
+				}
+				if (m.Op == model.WSRemoved) != (m.TODO == nil) {
+					t.Errorf("unexpected todo, given = %+v, expected it unless removed", m.TODO)
+				}
+				diffs = append(diffs, fmt.Sprintf("%s %d", m.Op, m.TODOID))
+			}
+			if strings.Join(diffs, ",") != strings.Join(c.diffs, ",") {
+				t.Errorf("unexpected diffs, given = %q, expected = %q", diffs, c.diffs)
+			}
+		})
+	}
+}
+
+func TestWebSocketSnapshotPages(t *testing.T) {
+	t.Parallel()
+
+	const n = 150
+
+	ctx := context.Background()
+	svc := newTestService(t)
+	for i := 0; i < n; i++ {
+		if _, err := svc.CreateTODO(ctx, fmt.Sprintf("todo %d", i), ""); err != nil {
+			t.Fatal("failed to create todo, err =", err)
+		}
+	}
+
+	conn := dialWebSocket(t, handler.NewWebSocketHandler(svc, nil))
+	snapshot := sendWebSocket(t, conn, `{"type": "subscribe", "filter": {"query": "TODO"}}`)
+	if snapshot.Type != model.WSSnapshot || snapshot.Subscription != "s1" {
+		t.Fatalf("unexpected message, given = %+v, expected the snapshot of s1", snapshot)
+	}
+	if len(snapshot.TODOs) != n {
+		t.Fatalf("unexpected todos, given = %d, expected = %d", len(snapshot.TODOs), n)
+	}
+	for i, td := range snapshot.TODOs {
+		if td.ID != int64(n-i) {
+			t.Errorf("unexpected todo, given = %d, expected = %d", td.ID, n-i)
+		}
+	}
+}
+
+func TestWebSocketCommands(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		command string
+		// code is the code of the error replied, empty when it succeeds
+		code    string
+		version *int64
+	}{
+		"Create": {
+			command: `{"id": "c", "type": "create", "payload": {"subject": "b"}}`,
+			version: new(int64),
+		},
+		"Create invalid": {
+			command: `{"id": "c", "type": "create", "payload": {"subject": ""}}`,
+			code:    "validation_failed",
+		},
+		"Update": {
+			command: `{"id": "c", "type": "update", "payload": {"id": 1, "subject": "b", "version": 0}}`,
+			version: func() *int64 { v := int64(1); return &v }(),
+		},
+		"Update stale": {
+			command: `{"id": "c", "type": "update", "payload": {"id": 1, "subject": "b", "version": 1}}`,
+			code:    "precondition_failed",
+		},
+		"Delete": {
+			command: `{"id": "c", "type": "delete", "payload": {"ids": [1]}}`,
+		},
+		"Delete missing": {
+			command: `{"id": "c", "type": "delete", "payload": {"ids": [2]}}`,
+			code:    "not_found",
+		},
+		"Unsubscribe": {
+			command: `{"id": "c", "type": "unsubscribe", "subscription": "s"}`,
+		},
+		"Unsubscribe unknown": {
+			command: `{"id": "c", "type": "unsubscribe", "subscription": "t"}`,
+			code:    "not_found",
+		},
+		"Subscribe existing": {
+			command: `{"id": "c", "type": "subscribe", "subscription": "s"}`,
+			code:    "conflict",
+		},
+		"Unknown command": {
+			command: `{"id": "c", "type": "rename"}`,
+			code:    "validation_failed",
+		},
+		"Unknown field": {
+			command: `{"id": "c", "type": "create", "payload": {"subject": "b", "due": "tomorrow"}}`,
+			code:    "validation_failed",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			svc := newTestService(t)
+			if _, err := svc.CreateTODO(ctx, "a", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			conn := dialWebSocket(t, handler.NewWebSocketHandler(svc, nil))
+			if m := sendWebSocket(t, conn, `{"type": "subscribe", "subscription": "s"}`); m.Type != model.WSSnapshot {
+				t.Fatalf("unexpected message, given = %+v, expected a snapshot", m)
+			}
+
+			m := sendWebSocket(t, conn, c.command)
+			if m.ID != "c" {
+				t.Errorf("unexpected id, given = %q, expected = %q", m.ID, "c")
+			}
+			if c.code != "" {
+				if m.Type != model.WSError || m.Error == nil || m.Error.Code != c.code {
+					t.Errorf("unexpected message, given = %+v, expected an error of %s", m, c.code)
+				}
+				return
+			}
+			if m.Type != model.WSResult {
+				t.Fatalf("unexpected message, given = %+v %+v, expected a result", m, m.Error)
+			}
+			if (m.Version == nil) != (c.version == nil) || (c.version != nil && *m.Version != *c.version) {
+				t.Errorf("unexpected version, given = %v, expected = %v", m.Version, c.version)
+			}
+		})
+	}
+}
+
+func TestWebSocketInvalidCommand(t *testing.T) {
+	t.Parallel()
+
+	conn := dialWebSocket(t, handler.NewWebSocketHandler(newTestService(t), nil))
+	m := sendWebSocket(t, conn, `{"type": `)
+	if m.Type != model.WSError || m.Error == nil || m.Error.Status != 400 {
+		t.Errorf("unexpected message, given = %+v, expected an error of a bad request", m)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	t.Parallel()
+
+	h := handler.NewWebSocketHandler(newTestService(t), nil)
+	conn := dialWebSocket(t, h)
+	if m := sendWebSocket(t, conn, `{"type": "subscribe"}`); m.Type != model.WSSnapshot {
+		t.Fatalf("unexpected message, given = %+v, expected a snapshot", m)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		h.Close()
+		close(closed)
+	}()
+
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("unexpected error, given = %v, expected the close of going away", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("unexpected Close, expected it to return after the connection is closed")
+	}
+}
+
+// dialWebSocket connects to h, which is closed with the test.
+func dialWebSocket(t *testing.T, h *handler.WebSocketHandler) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Cleanup(h.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal("failed to dial, err =", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendWebSocket sends the command and reads the next message.
+func sendWebSocket(t *testing.T, conn *websocket.Conn, command string) *wsMessage {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(command)); err != nil {
+		t.Fatal("failed to send command, err =", err)
+	}
+	return readWebSocket(t, conn)
+}
+
+func readWebSocket(t *testing.T, conn *websocket.Conn) *wsMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m wsMessage
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal("failed to read message, err =", err)
+	}
+	return &m
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -13,7 +16,9 @@ import (
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/handler"
 	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/pb"
 	"github.com/TechBowl-japan/go-stations/service"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -26,8 +31,11 @@ func main() {
 func realMain() error {
 	// config values
 	const (
-		defaultPort   = ":8080"
-		defaultDBPath = ".sqlite3/todo.db"
+		defaultPort              = ":8080"
+		defaultGRPCPort          = ":50051"
+		defaultDBPath            = ".sqlite3/todo.db"
+		defaultIdempotencyKeyTTL = 24 * time.Hour
+		defaultCORSMaxAge        = 10 * time.Minute
 	)
 
 	port := os.Getenv("PORT")
@@ -35,13 +43,61 @@ func realMain() error {
 		port = defaultPort
 	}
 
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = defaultGRPCPort
+	}
+
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = defaultDBPath
 	}
 
-	// set time zone
 	var err error
+	idempotencyKeyTTL := defaultIdempotencyKeyTTL
+	if v := os.Getenv("IDEMPOTENCY_KEY_TTL"); v != "" {
+		idempotencyKeyTTL, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	var todoConfig service.TODOServiceConfig
+	if v := os.Getenv("EVENT_RETENTION"); v != "" {
+		todoConfig.EventRetention, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	corsConfig := middleware.CORSConfig{
+		AllowedOrigins: splitEnv("CORS_ALLOWED_ORIGINS", nil),
+		AllowedMethods: splitEnv("CORS_ALLOWED_METHODS", []string{
+			http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+		}),
+		AllowedHeaders: splitEnv("CORS_ALLOWED_HEADERS", []string{
+			"Authorization", "Content-Type", "If-Match", "If-None-Match", "If-Modified-Since", "Idempotency-Key",
+		}),
+		ExposedHeaders: []string{"ETag", "Last-Modified", "Deprecation", "Link", "Idempotent-Replayed"},
+		MaxAge:         defaultCORSMaxAge,
+	}
+	if v := os.Getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+		corsConfig.AllowCredentials, err = strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+	}
+	if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+		corsConfig.MaxAge, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+	if err := corsConfig.Validate(); err != nil {
+		return err
+	}
+
+	// set time zone
 	time.Local, err = time.LoadLocation("Asia/Tokyo")
 	if err != nil {
 		return err
@@ -58,26 +114,97 @@ func realMain() error {
 	mux := http.NewServeMux()
 
 	// TODO: ここから実装を行う
+	readWrite := middleware.Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+
 	hh := handler.NewHealthzHandler()
-	mux.Handle("/healthz", middleware.AuthLayers(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	mux.Handle("/healthz", middleware.Methods(http.MethodGet)(middleware.AuthLayers(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		hh.ServeHTTP(rw, r)
-	})))
+	}))))
+
+	ts := service.NewTODOServiceWithConfig(todoDB, todoConfig)
+	is := service.NewIdempotencyService(todoDB, idempotencyKeyTTL)
+	idempotency := middleware.Idempotency(is)
+
+	// v1 is served on the unversioned paths as well for existing clients
+	v1 := middleware.Deprecation("/v2/todos")(handler.NewTODOHandler(ts))
+	v2 := handler.NewTODOV2Handler(ts)
+	mux.Handle("/todos", readWrite(middleware.AuthLayers(idempotency(handler.NewVersionHandler(v1, v2)))))
+	mux.Handle("/v1/todos", readWrite(middleware.AuthLayers(idempotency(v1))))
+	mux.Handle("/v2/todos", readWrite(middleware.AuthLayers(idempotency(v2))))
+
+	ih := handler.NewICalendarHandler(ts)
+	mux.Handle("/todos.ics", middleware.Methods(http.MethodGet, http.MethodPost)(middleware.AuthLayers(ih)))
+
+	// CalDAV clients discover the collection from the well-known URI
+	cdh := handler.NewCalDAVHandler(ts)
+	mux.Handle(handler.CalDAVRoot, middleware.DAV("1, 3, calendar-access")(middleware.Methods("PROPFIND", "REPORT", http.MethodGet, http.MethodPut, http.MethodDelete)(middleware.AuthLayers(cdh))))
+	mux.Handle("/.well-known/caldav", middleware.Layers(http.RedirectHandler(handler.CalDAVRoot, http.StatusMovedPermanently)))
+
+	tth := handler.NewTodoTxtHandler(ts)
+	mux.Handle("/todo.txt", middleware.Methods(http.MethodGet, http.MethodPost)(middleware.AuthLayers(tth)))
+
+	mdh := handler.NewMarkdownHandler(ts)
+	mux.Handle("/todos.md", middleware.Methods(http.MethodGet, http.MethodPost)(middleware.AuthLayers(mdh)))
+
+	csvh := handler.NewCSVHandler(ts)
+	mux.Handle("/todos.csv", middleware.Methods(http.MethodPost)(middleware.AuthLayers(csvh)))
+
+	eh := handler.NewEventsHandler(ts)
+	mux.Handle("/events", middleware.Methods(http.MethodGet)(middleware.AuthLayers(eh)))
+
+	wsh := handler.NewWebSocketHandler(ts, corsConfig.AllowedOrigins)
+	mux.Handle("/ws", middleware.Methods(http.MethodGet)(middleware.AuthLayers(wsh)))
 
-	ts := service.NewTODOService(todoDB)
-	th := handler.NewTODOHandler(ts)
-	mux.Handle("/todos", middleware.AuthLayers(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		th.ServeHTTP(rw, r)
-	})))
+	mux.Handle("/graphql", middleware.Methods(http.MethodPost)(middleware.AuthLayers(handler.NewGraphQLHandler(ts))))
+	mux.Handle("/rpc", middleware.Methods(http.MethodPost)(middleware.AuthLayers(handler.NewJSONRPCHandler(ts))))
+
+	bh := middleware.Methods(http.MethodPost, http.MethodPut)(middleware.AuthLayers(handler.NewTODOBulkHandler(ts)))
+	mux.Handle("/todos/bulk", bh)
+	mux.Handle("/v1/todos/bulk", bh)
+
+	mux.Handle("/sync", middleware.Methods(http.MethodPost)(middleware.AuthLayers(handler.NewSyncHandler(ts))))
+	mux.Handle("/changes", middleware.Methods(http.MethodGet)(middleware.AuthLayers(handler.NewChangesHandler(ts))))
+
+	bah := middleware.Methods(http.MethodPost)(middleware.AuthLayers(handler.NewBatchHandler(ts)))
+	mux.Handle("/batch", bah)
+	mux.Handle("/v1/batch", bah)
+
+	// the admin endpoints require ADMIN_TOKEN instead of the basic auth
+	bs := service.NewBackupService(todoDB, ts)
+	mux.Handle("/admin/backup", middleware.Methods(http.MethodGet)(middleware.AdminLayers(handler.NewBackupHandler(bs))))
+	mux.Handle("/admin/restore", middleware.Methods(http.MethodPost)(middleware.AdminLayers(handler.NewRestoreHandler(bs))))
+
+	ws := service.NewWebhookService(todoDB, ts, service.WebhookConfig{})
+	mux.Handle("/admin/webhooks", readWrite(middleware.AdminLayers(handler.NewWebhookHandler(ws))))
+	mux.Handle("/admin/webhooks/deliveries", middleware.Methods(http.MethodGet, http.MethodPost)(middleware.AdminLayers(handler.NewWebhookDeliveryHandler(ws))))
+
+	gs := handler.NewTODOGRPCServer(ts)
+	grpcSrv := grpc.NewServer(middleware.GRPCAuthLayers()...)
+	pb.RegisterTODOServiceServer(grpcSrv, gs)
+	lis, err := net.Listen("tcp", grpcPort)
+	if err != nil {
+		return err
+	}
+
+	// deliveries are sent in the background until the server is shut down
+	deliveryCtx, stopDelivery := context.WithCancel(context.Background())
+	deliveryDone := make(chan struct{})
+	go func() {
+		defer close(deliveryDone)
+		ws.Run(deliveryCtx)
+	}()
 
 	ph := handler.NewPanicHandler()
-	mux.Handle("/do-panic", middleware.Layers(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	mux.Handle("/do-panic", middleware.Methods(http.MethodGet)(middleware.Layers(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		ph.ServeHTTP(rw, r)
-	})))
+	}))))
 
 	srv := &http.Server{
 		Addr:    defaultPort,
-		Handler: mux,
+		Handler: middleware.CORS(corsConfig)(mux),
 	}
+	// Shutdown waits for the requests in progress, which event streams never end
+	srv.RegisterOnShutdown(eh.Close)
 
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -86,11 +213,27 @@ func realMain() error {
 		}
 	}()
 
+	go func() {
+		if err := grpcSrv.Serve(lis); err != nil {
+			log.Fatalln("gRPC server closed with error:", err)
+		}
+	}()
+
 	waitSignal()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
+	err = srv.Shutdown(ctx)
+	// hijacked WebSocket connections are not tracked by Shutdown
+	wsh.Close()
+	// GracefulStop waits for the calls in progress, which watch streams never end
+	gs.Close()
+	if !stopGRPC(ctx, grpcSrv) && err == nil {
+		err = ctx.Err()
+	}
+	stopDelivery()
+	<-deliveryDone
+	if err != nil {
 		log.Println("Failed to gracefully shutdown:", err)
 		return err
 	}
@@ -99,6 +242,39 @@ func realMain() error {
 	return nil
 }
 
+// stopGRPC stops srv gracefully, or forcibly when ctx is done first. It
+// reports whether srv stopped gracefully.
+func stopGRPC(ctx context.Context, srv *grpc.Server) bool {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-ctx.Done():
+		srv.Stop()
+		return false
+	}
+}
+
+// splitEnv returns the comma separated values of the environment variable key,
+// or def when it is not set.
+func splitEnv(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var values []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
 func waitSignal() {
 	log.Println("start")
 	var endWaiter sync.WaitGroup
@@ -0,0 +1,41 @@
+package model
+
+const (
+	// BackupFormat identifies the JSON documents of backups.
+	BackupFormat = "go-stations-backup"
+	// BackupVersion is the version of the backup format written by the server.
+	// Backups of this or an older version can be restored.
+	BackupVersion = 1
+)
+
+const (
+	// RestoreSkip keeps the existing rows which have the ids of restored rows.
+	RestoreSkip = "skip"
+	// RestoreOverwrite replaces the existing rows by the restored rows.
+	RestoreOverwrite = "overwrite"
+	// RestoreRenumber gives new ids to the restored rows whose ids are taken, and
+	// updates the references to them.
+	RestoreRenumber = "renumber"
+)
+
+type (
+	// A RestoreRequest expresses the options of restoring a backup.
+	RestoreRequest struct {
+		OnConflict string `json:"on_conflict" validate:"oneof=skip overwrite renumber"`
+	}
+
+	// A RestoreResponse expresses the result of restoring a backup by table.
+	RestoreResponse struct {
+		Tables map[string]*RestoreTableResult `json:"tables"`
+	}
+
+	// A RestoreTableResult expresses the rows of a table that were restored.
+	RestoreTableResult struct {
+		Inserted    int `json:"inserted"`
+		Overwritten int `json:"overwritten"`
+		Skipped     int `json:"skipped"`
+		Renumbered  int `json:"renumbered"`
+		// IDs maps the ids in the backup to the new ids of the renumbered rows.
+		IDs map[string]int64 `json:"ids,omitempty"`
+	}
+)
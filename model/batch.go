@@ -0,0 +1,35 @@
+package model
+
+import "encoding/json"
+
+type (
+	// A BatchRequest expresses ...
+	BatchRequest struct {
+		Atomic     bool              `json:"atomic"`
+		Operations []*BatchOperation `json:"operations" validate:"required,max=100"`
+	}
+
+	// A BatchOperation expresses a request to /todos in a batch request.
+	// @{ref.path} in Body and IfMatch is replaced with the value at the path
+	// in the response body of the earlier operation named ref.
+	BatchOperation struct {
+		Ref     string          `json:"ref"`
+		Method  string          `json:"method"`
+		IfMatch string          `json:"if_match"`
+		Body    json.RawMessage `json:"body"`
+	}
+
+	// A BatchResponse expresses ...
+	BatchResponse struct {
+		Results    []*BatchResult `json:"results"`
+		RolledBack bool           `json:"rolled_back"`
+	}
+
+	// A BatchResult expresses the result of an operation in a batch request.
+	BatchResult struct {
+		Ref    string      `json:"ref,omitempty"`
+		Status int         `json:"status"`
+		Body   interface{} `json:"body,omitempty"`
+		Error  *Problem    `json:"error,omitempty"`
+	}
+)
@@ -0,0 +1,36 @@
+package model
+
+const (
+	// BulkModeAtomic rolls back every item of a bulk request when any of them fails.
+	BulkModeAtomic = "atomic"
+	// BulkModePartial keeps the items of a bulk request that succeeded.
+	BulkModePartial = "partial"
+)
+
+type (
+	// A BulkCreateTODORequest expresses ...
+	BulkCreateTODORequest struct {
+		TODOs []*CreateTODORequest `json:"todos" validate:"required,max=100"`
+		Mode  string               `json:"mode" validate:"oneof=atomic partial"`
+	}
+
+	// A BulkUpdateTODORequest expresses ...
+	BulkUpdateTODORequest struct {
+		TODOs []*UpdateTODORequest `json:"todos" validate:"required,max=100"`
+		Mode  string               `json:"mode" validate:"oneof=atomic partial"`
+	}
+
+	// A BulkTODOResponse expresses ...
+	BulkTODOResponse struct {
+		Results    []*BulkTODOResult `json:"results"`
+		RolledBack bool              `json:"rolled_back"`
+	}
+
+	// A BulkTODOResult expresses the result of an item of a bulk request.
+	BulkTODOResult struct {
+		Index  int      `json:"index"`
+		Status int      `json:"status"`
+		TODO   *TODO    `json:"todo,omitempty"`
+		Error  *Problem `json:"error,omitempty"`
+	}
+)
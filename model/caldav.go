@@ -0,0 +1,12 @@
+package model
+
+// A CalDAVObject expresses a TODO as a calendar object resource of the CalDAV
+// collection, named by the client which created it or after its id.
+type CalDAVObject struct {
+	TODO    *TODO
+	Version int64
+	// Name is the last segment of the URL of the resource.
+	Name string
+	// UID is the UID of the VTODO, kept for the clients matching them.
+	UID string
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+type (
+	// A Change expresses a change of a TODO in the change feed. Seq is the id
+	// of its event, which increases in the order the changes were committed.
+	Change struct {
+		Seq    int64  `json:"seq"`
+		Type   string `json:"type"`
+		TODOID int64  `json:"todo_id"`
+		// Before is the TODO before the change, nil when it is created.
+		Before *TODO `json:"before"`
+		// After is the TODO after the change, nil when it is deleted.
+		After     *TODO     `json:"after"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// A ReadChangesRequest expresses the page of the change feed to read after
+	// the change of After.
+	ReadChangesRequest struct {
+		After int64 `json:"after" validate:"min=0"`
+		Size  int64 `json:"size" validate:"min=1,max=1000"`
+	}
+
+	// A ReadChangesResponse expresses a page of the change feed. Consumers read
+	// the next page after NextAfter, at once when HasMore is true.
+	ReadChangesResponse struct {
+		Changes   []*Change `json:"changes"`
+		NextAfter int64     `json:"next_after"`
+		HasMore   bool      `json:"has_more"`
+	}
+)
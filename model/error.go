@@ -1,6 +1,9 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ErrNotFound struct {
 	//
@@ -9,3 +12,97 @@ type ErrNotFound struct {
 func (e ErrNotFound) Error() string {
 	return fmt.Sprintln("Not Found")
 }
+
+type ErrPreconditionFailed struct {
+	//
+}
+
+func (e ErrPreconditionFailed) Error() string {
+	return fmt.Sprintln("Precondition Failed")
+}
+
+type ErrIdempotencyKeyReused struct {
+	//
+}
+
+func (e ErrIdempotencyKeyReused) Error() string {
+	return fmt.Sprintln("Idempotency-Key is reused with a different request")
+}
+
+type ErrIdempotencyKeyInUse struct {
+	//
+}
+
+func (e ErrIdempotencyKeyInUse) Error() string {
+	return fmt.Sprintln("Idempotency-Key is in use by a request in progress")
+}
+
+// An InvalidParam expresses a field of a request that failed validation.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type ErrValidation struct {
+	Message       string
+	InvalidParams []*InvalidParam
+}
+
+func (e ErrValidation) Error() string {
+	if len(e.InvalidParams) == 0 {
+		return e.Message
+	}
+	reasons := make([]string, len(e.InvalidParams))
+	for i, p := range e.InvalidParams {
+		reasons[i] = p.Name + " " + p.Reason
+	}
+	return e.Message + ": " + strings.Join(reasons, ", ")
+}
+
+type ErrConflict struct {
+	Message string
+}
+
+func (e ErrConflict) Error() string {
+	return e.Message
+}
+
+type ErrGone struct {
+	Message string
+}
+
+func (e ErrGone) Error() string {
+	return e.Message
+}
+
+type ErrUnauthorized struct {
+	//
+}
+
+func (e ErrUnauthorized) Error() string {
+	return fmt.Sprintln("Unauthorized")
+}
+
+type ErrMethodNotAllowed struct {
+	//
+}
+
+func (e ErrMethodNotAllowed) Error() string {
+	return fmt.Sprintln("Method Not Allowed")
+}
+
+type ErrNotAcceptable struct {
+	//
+}
+
+func (e ErrNotAcceptable) Error() string {
+	return fmt.Sprintln("Not Acceptable")
+}
+
+type ErrUnsupportedMediaType struct {
+	//
+}
+
+func (e ErrUnsupportedMediaType) Error() string {
+	return fmt.Sprintln("Unsupported Media Type")
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+const (
+	// EventCreated is the type of the events of created TODOs.
+	EventCreated = "created"
+	// EventUpdated is the type of the events of updated TODOs.
+	EventUpdated = "updated"
+	// EventDeleted is the type of the events of deleted TODOs.
+	EventDeleted = "deleted"
+)
+
+type (
+	// A TODOEvent expresses a change of a TODO in the event log.
+	TODOEvent struct {
+		ID     int64  `json:"id"`
+		Type   string `json:"type"`
+		TODOID int64  `json:"todo_id"`
+		// TODO is the TODO after the change, nil when it is deleted.
+		TODO *TODO `json:"todo"`
+		// Prior is the TODO before the change, nil when it is created.
+		Prior     *TODO     `json:"-"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// A ReadEventsRequest expresses the events to read from the event log.
+	ReadEventsRequest struct {
+		// LastEventID is the id of the last event received, which the events
+		// after it follow.
+		LastEventID int64    `json:"last_event_id" validate:"min=0"`
+		Types       []string `json:"types" validate:"oneof=created updated deleted"`
+		TODOIDs     []int64  `json:"todo_ids"`
+	}
+)
@@ -0,0 +1,10 @@
+package model
+
+// A GraphQLRequest expresses a GraphQL operation posted to the endpoint.
+type GraphQLRequest struct {
+	Query         string                 `json:"query" validate:"required,max=100000"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// Extensions is accepted for the clients sending it, and ignored.
+	Extensions map[string]interface{} `json:"extensions"`
+}
@@ -0,0 +1,10 @@
+package model
+
+import "net/http"
+
+// An IdempotentResponse expresses a response stored for an Idempotency-Key.
+type IdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
@@ -0,0 +1,49 @@
+package model
+
+type (
+	// A ImportTODOResponse expresses the result of importing TODOs from a file.
+	ImportTODOResponse struct {
+		Imported []*TODO `json:"imported"`
+		// Updated are the existing TODOs changed by the file, which upserts them.
+		Updated []*TODO          `json:"updated,omitempty"`
+		Skipped []*SkippedImport `json:"skipped"`
+	}
+
+	// A SkippedImport expresses an entry of the file which was not imported.
+	SkippedImport struct {
+		// Index is the position of the entry in the file, from 0.
+		Index int `json:"index"`
+		// ID identifies the entry in the file, such as UID of VTODO.
+		ID     string `json:"id,omitempty"`
+		Reason string `json:"reason"`
+	}
+)
+
+type (
+	// A ImportCSVRequest expresses the options of importing TODOs from CSV rows.
+	ImportCSVRequest struct {
+		// Columns maps the fields of TODO to the headers of the columns. The
+		// fields not in it are read from the columns named after them.
+		Columns map[string]string
+		// DryRun validates the rows without writing them.
+		DryRun bool
+	}
+
+	// A ImportCSVResponse expresses the result of validating and importing CSV
+	// rows. The rows are written only when every one of them is valid.
+	ImportCSVResponse struct {
+		DryRun    bool           `json:"dry_run"`
+		Committed bool           `json:"committed"`
+		Rows      int            `json:"rows"`
+		Valid     int            `json:"valid"`
+		Imported  []*TODO        `json:"imported"`
+		Errors    []*CSVRowError `json:"errors"`
+	}
+
+	// A CSVRowError expresses the invalid fields of a CSV row.
+	CSVRowError struct {
+		// Row is the number of the record in the file, 1 being the header.
+		Row           int             `json:"row"`
+		InvalidParams []*InvalidParam `json:"invalid_params"`
+	}
+)
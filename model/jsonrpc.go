@@ -0,0 +1,50 @@
+package model
+
+import "encoding/json"
+
+// The error codes of JSON-RPC 2.0.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	// RPCServerError is the code of the other failures of methods, in the
+	// range left to servers.
+	RPCServerError = -32000
+)
+
+type (
+	// An RPCRequest expresses a JSON-RPC 2.0 request. It is a notification,
+	// which is not answered, when ID is absent.
+	RPCRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	// An RPCResponse expresses a JSON-RPC 2.0 response. ID is null when the
+	// id of the request could not be read.
+	RPCResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result,omitempty"`
+		Error   *RPCError       `json:"error,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	// An RPCError expresses the error of a JSON-RPC 2.0 response. Data is the
+	// problem of the failures of methods.
+	RPCError struct {
+		Code    int      `json:"code"`
+		Message string   `json:"message"`
+		Data    *Problem `json:"data,omitempty"`
+	}
+
+	// An RPCTODOResult expresses the result of todo.create and todo.update,
+	// with the version todo.update takes.
+	RPCTODOResult struct {
+		TODO    *TODO `json:"todo"`
+		Version int64 `json:"version"`
+	}
+)
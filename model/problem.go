@@ -0,0 +1,13 @@
+package model
+
+// A Problem expresses an error response body defined by RFC 7807.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// InvalidParams lists the fields of a request that failed validation.
+	InvalidParams []*InvalidParam `json:"invalid_params,omitempty"`
+}
@@ -0,0 +1,74 @@
+package model
+
+const (
+	// SyncOpCreate, SyncOpUpdate and SyncOpDelete are the operations of the
+	// changes sent by sync clients.
+	SyncOpCreate = "create"
+	SyncOpUpdate = "update"
+	SyncOpDelete = "delete"
+
+	// SyncApplied reports a change applied, including the deletion of a TODO
+	// already deleted.
+	SyncApplied = "applied"
+	// SyncConflict reports a change rejected because the TODO changed since
+	// the base version. The TODO on the server wins.
+	SyncConflict = "conflict"
+	// SyncGone reports an update rejected because the TODO was deleted. The
+	// deletion wins.
+	SyncGone = "gone"
+	// SyncInvalid reports a change which is not valid.
+	SyncInvalid = "invalid"
+)
+
+type (
+	// A SyncRequest expresses the changes made by an offline client since it
+	// last synced at Token, which is empty on the first sync.
+	SyncRequest struct {
+		Token   string        `json:"token" validate:"max=20"`
+		Changes []*SyncChange `json:"changes" validate:"max=100"`
+	}
+
+	// A SyncChange expresses a change of a TODO made by a client. ID is the
+	// one of the TODO updated or deleted, and ClientID is echoed in the result
+	// so that clients map their local TODOs to the ones created. BaseVersion
+	// is the version the change was made on, nil applies it regardless.
+	SyncChange struct {
+		Op          string `json:"op" validate:"required,oneof=create update delete"`
+		ClientID    string `json:"client_id" validate:"max=100"`
+		ID          int64  `json:"id" validate:"min=0"`
+		BaseVersion *int64 `json:"base_version" validate:"min=0"`
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+	}
+
+	// A SyncResponse expresses the results of the changes of a client and the
+	// TODOs changed on the server since its token. Changes holds every TODO
+	// when Full is true, because the token was empty or too old, and the
+	// client replaces its TODOs with them.
+	SyncResponse struct {
+		Token   string        `json:"token"`
+		Full    bool          `json:"full"`
+		Results []*SyncResult `json:"results"`
+		Changes []*SyncDelta  `json:"changes"`
+	}
+
+	// A SyncResult expresses the result of a change of a client. TODO is the
+	// one on the server after the change, or the one which won the conflict.
+	SyncResult struct {
+		Index    int      `json:"index"`
+		ClientID string   `json:"client_id,omitempty"`
+		Status   string   `json:"status"`
+		TODO     *TODO    `json:"todo,omitempty"`
+		Version  *int64   `json:"version,omitempty"`
+		Error    *Problem `json:"error,omitempty"`
+	}
+
+	// A SyncDelta expresses a TODO changed on the server, which is a tombstone
+	// when Deleted is true.
+	SyncDelta struct {
+		ID      int64 `json:"id"`
+		Deleted bool  `json:"deleted"`
+		TODO    *TODO `json:"todo,omitempty"`
+		Version int64 `json:"version"`
+	}
+)
@@ -1,6 +1,20 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// TODOFields are the JSON names of the TODO fields selectable by fields.
+var TODOFields = []string{"id", "subject", "description", "created_at", "updated_at"}
+
+// The relations embedded in ReadTODOResponse by include.
+const (
+	// IncludeCount embeds the number of all TODOs.
+	IncludeCount = "count"
+	// IncludeETags embeds the entity tags of the TODOs keyed by id.
+	IncludeETags = "etags"
+)
 
 type (
 	// A TODO expresses ...
@@ -12,41 +26,96 @@ type (
 		UpdatedAt   time.Time `json:"updated_at"`
 	}
 
+	// A TODOOutline expresses where a TODO is placed in an outline, such as a
+	// Markdown document.
+	TODOOutline struct {
+		// Group is the heading the TODO is listed under, empty for none.
+		Group string
+		// ParentID is the id of the TODO it is nested in, 0 for none.
+		ParentID int64
+	}
+
 	// A CreateTODORequest expresses ...
 	CreateTODORequest struct {
-		Subject     string `json:"subject"`
-		Description string `json:"description"`
+		Subject     string `json:"subject" validate:"nfkc,trim,required,max=200"`
+		Description string `json:"description" validate:"nfkc,max=10000"`
 	}
 	// A CreateTODOResponse expresses ...
 	CreateTODOResponse struct {
-		TODO *TODO `json:"todo"`
+		TODO    *TODO `json:"todo"`
+		Version int64 `json:"-"`
 	}
 
 	// A ReadTODORequest expresses ...
 	ReadTODORequest struct {
-		Size   int64 `json:"size"`
-		PrevID int64 `json:"prev_id"`
+		Size    int64    `json:"size" validate:"min=0,max=100"`
+		PrevID  int64    `json:"prev_id" validate:"min=0"`
+		Fields  []string `json:"fields" validate:"oneof=id subject description created_at updated_at"`
+		Include []string `json:"include" validate:"oneof=count etags"`
 	}
 	// A ReadTODOResponse expresses ...
 	ReadTODOResponse struct {
-		TODOs []*TODO `json:"todos"`
+		TODOs []*TODO          `json:"todos"`
+		Count *int64           `json:"count,omitempty"`
+		ETags map[int64]string `json:"etags,omitempty"`
+		// Versions maps ids of TODOs to their versions.
+		Versions map[int64]int64 `json:"-"`
+		// LastModified is the time any TODO was last changed.
+		LastModified time.Time `json:"-"`
+		// Fields limits the fields of TODOs encoded, empty encodes all of them.
+		Fields []string `json:"-"`
+		// Include lists the relations included.
+		Include []string `json:"-"`
 	}
 
 	// A UpdateTODORequest expresses ...
 	UpdateTODORequest struct {
-		ID          int    `json:"id"`
-		Subject     string `json:"subject"`
-		Description string `json:"description"`
+		ID          int    `json:"id" validate:"required,min=1"`
+		Subject     string `json:"subject" validate:"nfkc,trim,required,max=200"`
+		Description string `json:"description" validate:"nfkc,max=10000"`
+		// Version is the version required by If-Match, nil skips the check.
+		Version *int64 `json:"-"`
 	}
 	// A UpdateTODOResponse expresses ...
 	UpdateTODOResponse struct {
-		TODO *TODO `json:"todo"`
+		TODO    *TODO `json:"todo"`
+		Version int64 `json:"-"`
 	}
 
 	// A DeleteTODORequest expresses ...
 	DeleteTODORequest struct {
-		IDs []int64 `json:"ids"`
+		IDs []int64 `json:"ids" validate:"required,max=100"`
+		// Versions maps ids to the versions required by If-Match, nil skips the check.
+		Versions map[int64]int64 `json:"-"`
 	}
 	// A DeleteTODOResponse expresses ...
 	DeleteTODOResponse struct{}
 )
+
+// MarshalJSON encodes the response with only the selected fields of TODOs.
+func (r *ReadTODOResponse) MarshalJSON() ([]byte, error) {
+	type response ReadTODOResponse
+	if len(r.Fields) == 0 {
+		return json.Marshal((*response)(r))
+	}
+
+	todos := make([]map[string]interface{}, len(r.TODOs))
+	for i, t := range r.TODOs {
+		all := map[string]interface{}{
+			"id":          t.ID,
+			"subject":     t.Subject,
+			"description": t.Description,
+			"created_at":  t.CreatedAt,
+			"updated_at":  t.UpdatedAt,
+		}
+		todos[i] = make(map[string]interface{}, len(r.Fields))
+		for _, f := range r.Fields {
+			todos[i][f] = all[f]
+		}
+	}
+
+	return json.Marshal(struct {
+		*response
+		TODOs []map[string]interface{} `json:"todos"`
+	}{(*response)(r), todos})
+}
@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+type (
+	// A TODOV2 expresses a TODO in the API v2, which carries its version.
+	TODOV2 struct {
+		ID          int64     `json:"id"`
+		Subject     string    `json:"subject"`
+		Description string    `json:"description"`
+		Version     int64     `json:"version"`
+		CreatedAt   time.Time `json:"created_at"`
+		UpdatedAt   time.Time `json:"updated_at"`
+	}
+
+	// A ReadTODOResponseV2 expresses the TODOs of one page in the API v2.
+	ReadTODOResponseV2 struct {
+		Items []*TODOV2 `json:"items"`
+		// NextPrevID is the prev_id of the next page, nil on the last page.
+		NextPrevID *int64 `json:"next_prev_id"`
+	}
+
+	// A UpdateTODORequestV2 expresses an update in the API v2, which takes the
+	// expected version in the body as well as in If-Match. It is also the
+	// params of todo.update and the payload of the update command.
+	UpdateTODORequestV2 struct {
+		ID          int64  `json:"id" validate:"required,min=1"`
+		Subject     string `json:"subject" validate:"nfkc,trim,required,max=200"`
+		Description string `json:"description" validate:"nfkc,max=10000"`
+		Version     *int64 `json:"version" validate:"min=0"`
+	}
+)
+
+// NewTODOV2 returns the TODO at the version in the API v2.
+func NewTODOV2(t *TODO, version int64) *TODOV2 {
+	return &TODOV2{
+		ID:          t.ID,
+		Subject:     t.Subject,
+		Description: t.Description,
+		Version:     version,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Validate normalizes and validates the fields of the struct pointed by v by
+// their validate tags, and returns ErrValidation reporting every violation.
+//
+// The rules in a tag are applied from left to right:
+//
+//	trim        removes leading and trailing white space
+//	nfkc        applies Unicode NFKC normalization
+//	required    rejects the zero value and empty slices
+//	min=N       rejects numbers less than N, or strings and slices shorter than N
+//	max=N       rejects numbers greater than N, or strings and slices longer than N
+//	oneof=A B   rejects strings other than the listed ones, except empty one, or
+//	            slices of strings containing them
+//
+// min and max see through pointers and skip nil ones.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("model: Validate of %T", v))
+	}
+	rv = rv.Elem()
+
+	var params []*InvalidParam
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+		name := fieldName(t.Field(i))
+		if reason := validateField(rv.Field(i), name, tag); reason != "" {
+			params = append(params, &InvalidParam{Name: name, Reason: reason})
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+	return ErrValidation{Message: "request has invalid fields", InvalidParams: params}
+}
+
+// validateField applies the rules to f and returns the reason of the first
+// violation.
+func validateField(f reflect.Value, name, tag string) string {
+	for _, rule := range strings.Split(tag, ",") {
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+
+		switch key {
+		case "trim":
+			if f.Kind() == reflect.String {
+				f.SetString(strings.TrimSpace(f.String()))
+			}
+
+		case "nfkc":
+			if f.Kind() == reflect.String {
+				f.SetString(norm.NFKC.String(f.String()))
+			}
+
+		case "required":
+			switch f.Kind() {
+			case reflect.Slice, reflect.Map:
+				if f.Len() == 0 {
+					return "is required"
+				}
+			default:
+				if f.IsZero() {
+					return "is required"
+				}
+			}
+
+		case "min", "max":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("model: invalid rule %q of %s", rule, name))
+			}
+			if f.Kind() == reflect.Ptr && f.IsNil() {
+				continue
+			}
+			size, unit := measure(reflect.Indirect(f))
+			if key == "min" && size < n {
+				return fmt.Sprintf("must be at least %d%s", n, unit)
+			}
+			if key == "max" && size > n {
+				return fmt.Sprintf("must be at most %d%s", n, unit)
+			}
+
+		case "oneof":
+			values := []string{f.String()}
+			if f.Kind() == reflect.Slice {
+				values = make([]string, f.Len())
+				for i := range values {
+					values[i] = f.Index(i).String()
+				}
+			}
+			for _, s := range values {
+				if s != "" && !contains(strings.Fields(arg), s) {
+					return "must be one of " + strings.Join(strings.Fields(arg), ", ")
+				}
+			}
+
+		default:
+			panic(fmt.Sprintf("model: unknown rule %q of %s", rule, name))
+		}
+	}
+
+	return ""
+}
+
+// measure returns the value of a number, the number of characters in a string
+// or the length of a slice, with the unit used in messages.
+func measure(f reflect.Value) (int64, string) {
+	switch f.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(f.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return int64(f.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.Int(), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(f.Uint()), ""
+	default:
+		return 0, ""
+	}
+}
+
+// fieldName returns the JSON name of the field, which clients know it by.
+func fieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
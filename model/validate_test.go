@@ -0,0 +1,91 @@
+package model_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		Subject string   `json:"subject" validate:"nfkc,trim,required,max=5"`
+		Size    int64    `json:"size" validate:"min=1,max=10"`
+		Version *int64   `json:"version" validate:"min=0"`
+		Fields  []string `json:"fields" validate:"max=2,oneof=id subject"`
+		Note    string   `validate:"min=2"`
+		Ignored string   `json:"ignored"`
+	}
+	negative := int64(-1)
+
+	cases := map[string]struct {
+		request *request
+		// invalid maps the names of the invalid fields to their reasons
+		invalid map[string]string
+		// subject is the subject after normalization
+		subject string
+	}{
+		"Valid": {
+			request: &request{Subject: "a", Size: 1, Note: "ab"},
+			subject: "a",
+		},
+		"Normalized": {
+			request: &request{Subject: "  ＡＢ  ", Size: 10, Fields: []string{"id"}, Note: "ab"},
+			subject: "AB",
+		},
+		"Required after trim": {
+			request: &request{Subject: "   ", Size: 1, Note: "ab"},
+			invalid: map[string]string{"subject": "is required"},
+		},
+		"Max of characters": {
+			request: &request{Subject: "あいうえおか", Size: 1, Note: "ab"},
+			invalid: map[string]string{"subject": "must be at most 5 characters"},
+		},
+		"Every violation": {
+			request: &request{Subject: "a", Size: 11, Version: &negative, Fields: []string{"id", "subject", "id"}, Note: "a"},
+			invalid: map[string]string{
+				"size":    "must be at most 10",
+				"version": "must be at least 0",
+				"fields":  "must be at most 2 items",
+				"Note":    "must be at least 2 characters",
+			},
+		},
+		"Oneof": {
+			request: &request{Subject: "a", Size: 1, Fields: []string{"id", "name"}, Note: "ab"},
+			invalid: map[string]string{"fields": "must be one of id, subject"},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := model.Validate(c.request)
+			if len(c.invalid) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected value, given = %v, expected = nil", err)
+				}
+				if c.request.Subject != c.subject {
+					t.Errorf("unexpected subject, given = %q, expected = %q", c.request.Subject, c.subject)
+				}
+				return
+			}
+
+			var validation model.ErrValidation
+			if !errors.As(err, &validation) {
+				t.Fatalf("unexpected value, given = %v, expected = ErrValidation", err)
+			}
+			invalid := make(map[string]string)
+			for _, p := range validation.InvalidParams {
+				invalid[p.Name] = p.Reason
+			}
+			if !reflect.DeepEqual(invalid, c.invalid) {
+				t.Errorf("unexpected invalid params, given = %v, expected = %v", invalid, c.invalid)
+			}
+		})
+	}
+}
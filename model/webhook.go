@@ -0,0 +1,122 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// DeliveryPending is the status of the deliveries waiting to be sent or
+	// retried.
+	DeliveryPending = "pending"
+	// DeliverySucceeded is the status of the deliveries acknowledged with a 2xx
+	// response.
+	DeliverySucceeded = "succeeded"
+	// DeliveryFailed is the status of the deliveries which ran out of attempts.
+	DeliveryFailed = "failed"
+)
+
+type (
+	// A Webhook expresses a subscription of an URL to the events of TODOs.
+	Webhook struct {
+		ID  int64  `json:"id"`
+		URL string `json:"url"`
+		// EventTypes are the types of the events delivered, empty for all.
+		EventTypes []string `json:"event_types"`
+		Active     bool     `json:"active"`
+		// Secret signs the deliveries. It is only returned when the webhook is
+		// created or the secret is replaced.
+		Secret    string    `json:"secret,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	// A WebhookDelivery expresses an event queued to be sent to a webhook.
+	WebhookDelivery struct {
+		ID        int64  `json:"id"`
+		WebhookID int64  `json:"webhook_id"`
+		EventID   int64  `json:"event_id"`
+		EventType string `json:"event_type"`
+		// Payload is the request body, the TODOEvent of the event.
+		Payload  json.RawMessage `json:"payload"`
+		Status   string          `json:"status"`
+		Attempts int             `json:"attempts"`
+		// NextAttemptAt is when the delivery is sent next, nil unless pending.
+		NextAttemptAt *time.Time `json:"next_attempt_at"`
+		// RedeliveryOf is the id of the delivery redelivered by this one.
+		RedeliveryOf *int64            `json:"redelivery_of,omitempty"`
+		CreatedAt    time.Time         `json:"created_at"`
+		Log          []*WebhookAttempt `json:"log"`
+	}
+
+	// A WebhookAttempt expresses an attempt to send a delivery.
+	WebhookAttempt struct {
+		// StatusCode is the status of the response, 0 when none was received.
+		StatusCode int       `json:"status_code"`
+		Error      string    `json:"error,omitempty"`
+		DurationMS int64     `json:"duration_ms"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	// A CreateWebhookRequest expresses ...
+	CreateWebhookRequest struct {
+		URL        string   `json:"url" validate:"trim,required,max=2000"`
+		EventTypes []string `json:"event_types" validate:"oneof=created updated deleted"`
+		// Secret is generated when it is empty.
+		Secret string `json:"secret" validate:"max=200"`
+	}
+	// A CreateWebhookResponse expresses ...
+	CreateWebhookResponse struct {
+		Webhook *Webhook `json:"webhook"`
+	}
+
+	// A ReadWebhookResponse expresses ...
+	ReadWebhookResponse struct {
+		Webhooks []*Webhook `json:"webhooks"`
+	}
+
+	// A UpdateWebhookRequest expresses ...
+	UpdateWebhookRequest struct {
+		ID         int64    `json:"id" validate:"required,min=1"`
+		URL        string   `json:"url" validate:"trim,required,max=2000"`
+		EventTypes []string `json:"event_types" validate:"oneof=created updated deleted"`
+		// Secret replaces the secret unless it is empty.
+		Secret string `json:"secret" validate:"max=200"`
+		// Active enables or disables the webhook, nil keeps it. Disabled ones
+		// are not queued the events, and their pending deliveries are held.
+		Active *bool `json:"active"`
+	}
+	// A UpdateWebhookResponse expresses ...
+	UpdateWebhookResponse struct {
+		Webhook *Webhook `json:"webhook"`
+	}
+
+	// A DeleteWebhookRequest expresses ...
+	DeleteWebhookRequest struct {
+		IDs []int64 `json:"ids" validate:"required"`
+	}
+	// A DeleteWebhookResponse expresses ...
+	DeleteWebhookResponse struct{}
+
+	// A ReadWebhookDeliveryRequest expresses ...
+	ReadWebhookDeliveryRequest struct {
+		WebhookID int64  `json:"webhook_id" validate:"min=0"`
+		Status    string `json:"status" validate:"oneof=pending succeeded failed"`
+		PrevID    int64  `json:"prev_id" validate:"min=0"`
+		Size      int64  `json:"size" validate:"min=0,max=100"`
+	}
+	// A ReadWebhookDeliveryResponse expresses ...
+	ReadWebhookDeliveryResponse struct {
+		Deliveries []*WebhookDelivery `json:"deliveries"`
+	}
+
+	// A RedeliverWebhookRequest expresses ...
+	RedeliverWebhookRequest struct {
+		// ID is the id of the delivery sent again.
+		ID int64 `json:"id" validate:"required,min=1"`
+	}
+	// A RedeliverWebhookResponse expresses ...
+	RedeliverWebhookResponse struct {
+		Delivery *WebhookDelivery `json:"delivery"`
+	}
+)
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// WSSubscribe subscribes to the TODOs matching the filter of the command.
+	WSSubscribe = "subscribe"
+	// WSUnsubscribe cancels the subscription of the command.
+	WSUnsubscribe = "unsubscribe"
+	// WSCreate creates the TODO of CreateTODORequest in the payload.
+	WSCreate = "create"
+	// WSUpdate updates the TODO of UpdateTODORequestV2 in the payload.
+	WSUpdate = "update"
+	// WSDelete deletes the TODOs of DeleteTODORequest in the payload.
+	WSDelete = "delete"
+)
+
+const (
+	// WSSnapshot is the type of WSSnapshotMessage.
+	WSSnapshot = "snapshot"
+	// WSDiff is the type of WSDiffMessage.
+	WSDiff = "diff"
+	// WSResult is the type of WSResultMessage.
+	WSResult = "result"
+	// WSError is the type of WSErrorMessage.
+	WSError = "error"
+)
+
+const (
+	// WSAdded is the operation of a diff adding a TODO to a subscription.
+	WSAdded = "added"
+	// WSChanged is the operation of a diff changing a TODO in a subscription.
+	WSChanged = "changed"
+	// WSRemoved is the operation of a diff removing a TODO from a subscription.
+	WSRemoved = "removed"
+)
+
+type (
+	// A TODOFilter expresses a query of the TODOs a subscription follows. The
+	// empty filter matches every TODO.
+	TODOFilter struct {
+		IDs []int64 `json:"ids" validate:"max=100"`
+		// Query matches the TODOs whose subject or description contains it,
+		// ignoring case.
+		Query string `json:"query" validate:"trim,max=200"`
+	}
+
+	// A WSCommand expresses a message from a client of the WebSocket API.
+	WSCommand struct {
+		// ID is chosen by the client to correlate the command with its reply.
+		ID           string          `json:"id"`
+		Type         string          `json:"type" validate:"required,oneof=subscribe unsubscribe create update delete"`
+		Subscription string          `json:"subscription"`
+		Filter       *TODOFilter     `json:"filter"`
+		Payload      json.RawMessage `json:"payload"`
+	}
+
+	// A WSSnapshotMessage carries the TODOs matching a new subscription.
+	WSSnapshotMessage struct {
+		ID           string  `json:"id,omitempty"`
+		Type         string  `json:"type"`
+		Subscription string  `json:"subscription"`
+		TODOs        []*TODO `json:"todos"`
+	}
+
+	// A WSDiffMessage carries a change of the TODOs matching a subscription.
+	WSDiffMessage struct {
+		Type         string `json:"type"`
+		Subscription string `json:"subscription"`
+		Op           string `json:"op"`
+		TODOID       int64  `json:"todo_id"`
+		// TODO is nil when the TODO is removed.
+		TODO *TODO `json:"todo"`
+		// EventID is the id of the event of the change in the event log.
+		EventID int64 `json:"event_id"`
+	}
+
+	// A WSResultMessage carries the result of a command.
+	WSResultMessage struct {
+		ID      string `json:"id,omitempty"`
+		Type    string `json:"type"`
+		TODO    *TODO  `json:"todo,omitempty"`
+		Version *int64 `json:"version,omitempty"`
+	}
+
+	// A WSErrorMessage carries the error of a command.
+	WSErrorMessage struct {
+		ID    string   `json:"id,omitempty"`
+		Type  string   `json:"type"`
+		Error *Problem `json:"error"`
+	}
+)
+
+// Match reports whether t matches the filter.
+func (f *TODOFilter) Match(t *TODO) bool {
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			found = found || id == t.ID
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		return strings.Contains(strings.ToLower(t.Subject), q) || strings.Contains(strings.ToLower(t.Description), q)
+	}
+	return true
+}
@@ -0,0 +1,350 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: todo.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TODOServiceClient is the client API for TODOService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TODOServiceClient interface {
+	CreateTODO(ctx context.Context, in *CreateTODORequest, opts ...grpc.CallOption) (*CreateTODOResponse, error)
+	ReadTODO(ctx context.Context, in *ReadTODORequest, opts ...grpc.CallOption) (*ReadTODOResponse, error)
+	// ListTODOs streams every TODO matching the filter in the descending order
+	// of ids.
+	ListTODOs(ctx context.Context, in *ListTODOsRequest, opts ...grpc.CallOption) (TODOService_ListTODOsClient, error)
+	UpdateTODO(ctx context.Context, in *UpdateTODORequest, opts ...grpc.CallOption) (*UpdateTODOResponse, error)
+	DeleteTODO(ctx context.Context, in *DeleteTODORequest, opts ...grpc.CallOption) (*DeleteTODOResponse, error)
+	// WatchTODOs streams the events of the TODOs from the event log, after
+	// last_event_id when it is set and from the latest event otherwise. It fails
+	// with OUT_OF_RANGE when the events after last_event_id are no longer kept.
+	WatchTODOs(ctx context.Context, in *WatchTODOsRequest, opts ...grpc.CallOption) (TODOService_WatchTODOsClient, error)
+}
+
+type tODOServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTODOServiceClient(cc grpc.ClientConnInterface) TODOServiceClient {
+	return &tODOServiceClient{cc}
+}
+
+func (c *tODOServiceClient) CreateTODO(ctx context.Context, in *CreateTODORequest, opts ...grpc.CallOption) (*CreateTODOResponse, error) {
+	out := new(CreateTODOResponse)
+	err := c.cc.Invoke(ctx, "/todo.v1.TODOService/CreateTODO", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tODOServiceClient) ReadTODO(ctx context.Context, in *ReadTODORequest, opts ...grpc.CallOption) (*ReadTODOResponse, error) {
+	out := new(ReadTODOResponse)
+	err := c.cc.Invoke(ctx, "/todo.v1.TODOService/ReadTODO", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tODOServiceClient) ListTODOs(ctx context.Context, in *ListTODOsRequest, opts ...grpc.CallOption) (TODOService_ListTODOsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TODOService_ServiceDesc.Streams[0], "/todo.v1.TODOService/ListTODOs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tODOServiceListTODOsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TODOService_ListTODOsClient interface {
+	Recv() (*TODO, error)
+	grpc.ClientStream
+}
+
+type tODOServiceListTODOsClient struct {
+	grpc.ClientStream
+}
+
+func (x *tODOServiceListTODOsClient) Recv() (*TODO, error) {
+	m := new(TODO)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tODOServiceClient) UpdateTODO(ctx context.Context, in *UpdateTODORequest, opts ...grpc.CallOption) (*UpdateTODOResponse, error) {
+	out := new(UpdateTODOResponse)
+	err := c.cc.Invoke(ctx, "/todo.v1.TODOService/UpdateTODO", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tODOServiceClient) DeleteTODO(ctx context.Context, in *DeleteTODORequest, opts ...grpc.CallOption) (*DeleteTODOResponse, error) {
+	out := new(DeleteTODOResponse)
+	err := c.cc.Invoke(ctx, "/todo.v1.TODOService/DeleteTODO", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tODOServiceClient) WatchTODOs(ctx context.Context, in *WatchTODOsRequest, opts ...grpc.CallOption) (TODOService_WatchTODOsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TODOService_ServiceDesc.Streams[1], "/todo.v1.TODOService/WatchTODOs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tODOServiceWatchTODOsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TODOService_WatchTODOsClient interface {
+	Recv() (*TODOEvent, error)
+	grpc.ClientStream
+}
+
+type tODOServiceWatchTODOsClient struct {
+	grpc.ClientStream
+}
+
+func (x *tODOServiceWatchTODOsClient) Recv() (*TODOEvent, error) {
+	m := new(TODOEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TODOServiceServer is the server API for TODOService service.
+// All implementations must embed UnimplementedTODOServiceServer
+// for forward compatibility
+type TODOServiceServer interface {
+	CreateTODO(context.Context, *CreateTODORequest) (*CreateTODOResponse, error)
+	ReadTODO(context.Context, *ReadTODORequest) (*ReadTODOResponse, error)
+	// ListTODOs streams every TODO matching the filter in the descending order
+	// of ids.
+	ListTODOs(*ListTODOsRequest, TODOService_ListTODOsServer) error
+	UpdateTODO(context.Context, *UpdateTODORequest) (*UpdateTODOResponse, error)
+	DeleteTODO(context.Context, *DeleteTODORequest) (*DeleteTODOResponse, error)
+	// WatchTODOs streams the events of the TODOs from the event log, after
+	// last_event_id when it is set and from the latest event otherwise. It fails
+	// with OUT_OF_RANGE when the events after last_event_id are no longer kept.
+	WatchTODOs(*WatchTODOsRequest, TODOService_WatchTODOsServer) error
+	mustEmbedUnimplementedTODOServiceServer()
+}
+
+// UnimplementedTODOServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTODOServiceServer struct {
+}
+
+func (UnimplementedTODOServiceServer) CreateTODO(context.Context, *CreateTODORequest) (*CreateTODOResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTODO not implemented")
+}
+func (UnimplementedTODOServiceServer) ReadTODO(context.Context, *ReadTODORequest) (*ReadTODOResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadTODO not implemented")
+}
+func (UnimplementedTODOServiceServer) ListTODOs(*ListTODOsRequest, TODOService_ListTODOsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListTODOs not implemented")
+}
+func (UnimplementedTODOServiceServer) UpdateTODO(context.Context, *UpdateTODORequest) (*UpdateTODOResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateTODO not implemented")
+}
+func (UnimplementedTODOServiceServer) DeleteTODO(context.Context, *DeleteTODORequest) (*DeleteTODOResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteTODO not implemented")
+}
+func (UnimplementedTODOServiceServer) WatchTODOs(*WatchTODOsRequest, TODOService_WatchTODOsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTODOs not implemented")
+}
+func (UnimplementedTODOServiceServer) mustEmbedUnimplementedTODOServiceServer() {}
+
+// UnsafeTODOServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TODOServiceServer will
+// result in compilation errors.
+type UnsafeTODOServiceServer interface {
+	mustEmbedUnimplementedTODOServiceServer()
+}
+
+func RegisterTODOServiceServer(s grpc.ServiceRegistrar, srv TODOServiceServer) {
+	s.RegisterService(&TODOService_ServiceDesc, srv)
+}
+
+func _TODOService_CreateTODO_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTODORequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TODOServiceServer).CreateTODO(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/todo.v1.TODOService/CreateTODO",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TODOServiceServer).CreateTODO(ctx, req.(*CreateTODORequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TODOService_ReadTODO_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadTODORequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TODOServiceServer).ReadTODO(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/todo.v1.TODOService/ReadTODO",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TODOServiceServer).ReadTODO(ctx, req.(*ReadTODORequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TODOService_ListTODOs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListTODOsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TODOServiceServer).ListTODOs(m, &tODOServiceListTODOsServer{stream})
+}
+
+type TODOService_ListTODOsServer interface {
+	Send(*TODO) error
+	grpc.ServerStream
+}
+
+type tODOServiceListTODOsServer struct {
+	grpc.ServerStream
+}
+
+func (x *tODOServiceListTODOsServer) Send(m *TODO) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TODOService_UpdateTODO_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateTODORequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TODOServiceServer).UpdateTODO(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/todo.v1.TODOService/UpdateTODO",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TODOServiceServer).UpdateTODO(ctx, req.(*UpdateTODORequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TODOService_DeleteTODO_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTODORequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TODOServiceServer).DeleteTODO(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/todo.v1.TODOService/DeleteTODO",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TODOServiceServer).DeleteTODO(ctx, req.(*DeleteTODORequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TODOService_WatchTODOs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTODOsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TODOServiceServer).WatchTODOs(m, &tODOServiceWatchTODOsServer{stream})
+}
+
+type TODOService_WatchTODOsServer interface {
+	Send(*TODOEvent) error
+	grpc.ServerStream
+}
+
+type tODOServiceWatchTODOsServer struct {
+	grpc.ServerStream
+}
+
+func (x *tODOServiceWatchTODOsServer) Send(m *TODOEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TODOService_ServiceDesc is the grpc.ServiceDesc for TODOService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TODOService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "todo.v1.TODOService",
+	HandlerType: (*TODOServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateTODO",
+			Handler:    _TODOService_CreateTODO_Handler,
+		},
+		{
+			MethodName: "ReadTODO",
+			Handler:    _TODOService_ReadTODO_Handler,
+		},
+		{
+			MethodName: "UpdateTODO",
+			Handler:    _TODOService_UpdateTODO_Handler,
+		},
+		{
+			MethodName: "DeleteTODO",
+			Handler:    _TODOService_DeleteTODO_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListTODOs",
+			Handler:       _TODOService_ListTODOs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchTODOs",
+			Handler:       _TODOService_WatchTODOs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "todo.proto",
+}
@@ -0,0 +1,500 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/mattn/go-sqlite3"
+)
+
+// A backupTable expresses a table in backups. Its rows are identified by the
+// id column.
+type backupTable struct {
+	name string
+	// references maps the columns holding ids of rows to the tables of them.
+	references map[string]string
+	// events reports whether the rows restored are logged in the event log as
+	// changes of TODOs.
+	events bool
+}
+
+// backupTables lists the tables in backups in the order they are written.
+var backupTables = []backupTable{
+	{name: "todos", references: map[string]string{"parent_id": "todos"}, events: true},
+}
+
+// A BackupService implements logical backups of the tables as JSON documents.
+type BackupService struct {
+	db    *sql.DB
+	todos *TODOService
+}
+
+// NewBackupService returns new BackupService which logs the TODOs restored in
+// the event log of todos.
+func NewBackupService(db *sql.DB, todos *TODOService) *BackupService {
+	return &BackupService{
+		db:    db,
+		todos: todos,
+	}
+}
+
+// Dump writes the backup of every table to w in one transaction. The rows are
+// written as they are read so that the backup is not held in memory.
+func (s *BackupService) Dump(ctx context.Context, w io.Writer) error {
+	return RunInReadTx(ctx, s.db, func(ctx context.Context) error {
+		header, err := json.Marshal(struct {
+			Format    string    `json:"format"`
+			Version   int       `json:"version"`
+			CreatedAt time.Time `json:"created_at"`
+		}{model.BackupFormat, model.BackupVersion, time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+
+		bw := bufio.NewWriter(w)
+		bw.Write(header[:len(header)-1])
+		bw.WriteString(`,"tables":{`)
+		for i, t := range backupTables {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			fmt.Fprintf(bw, "\n%q:[", t.name)
+			if err := s.dumpTable(ctx, bw, t.name); err != nil {
+				return err
+			}
+			bw.WriteString("]")
+		}
+		bw.WriteString("}}\n")
+		return bw.Flush()
+	})
+}
+
+func (s *BackupService) dumpTable(ctx context.Context, w *bufio.Writer, table string) error {
+	rows, err := connFrom(ctx, s.db).QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s ORDER BY id`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for n := 0; rows.Next(); n++ {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			if t, ok := values[i].(time.Time); ok {
+				values[i] = t.UTC().Format(time.RFC3339)
+			}
+			row[c] = values[i]
+		}
+
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString("\n")
+		w.Write(b)
+	}
+	return rows.Err()
+}
+
+// A restoredRow expresses a row renumbered by Restore whose references may
+// have to follow the rows renumbered after it.
+type restoredRow struct {
+	table  *backupTable
+	id     int64
+	values map[string]interface{}
+}
+
+// A restore expresses the state of Restore.
+type restore struct {
+	s          *BackupService
+	onConflict string
+	response   *model.RestoreResponse
+	// ids maps the ids of the renumbered rows to their new ones by table.
+	ids map[string]map[int64]int64
+	// pending are the rows referring to ids not restored yet.
+	pending []*restoredRow
+}
+
+// Restore restores the backup read from r in one transaction. The rows whose
+// ids are taken are skipped, overwrite the existing rows or are renumbered by
+// onConflict. References are updated to the renumbered ids. The TODOs inserted
+// are logged as created and the ones overwritten as updated.
+func (s *BackupService) Restore(ctx context.Context, r io.Reader, onConflict string) (*model.RestoreResponse, error) {
+	st := &restore{
+		s:          s,
+		onConflict: onConflict,
+		response:   &model.RestoreResponse{Tables: make(map[string]*model.RestoreTableResult)},
+		ids:        make(map[string]map[int64]int64),
+	}
+	for _, t := range backupTables {
+		st.response.Tables[t.name] = &model.RestoreTableResult{}
+		st.ids[t.name] = make(map[int64]int64)
+	}
+
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		d := json.NewDecoder(r)
+		d.UseNumber()
+		if err := st.decode(ctx, d); err != nil {
+			return err
+		}
+		return st.resolve(ctx)
+	})
+	if err != nil {
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		if errors.As(err, &syntax) || errors.As(err, &typ) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, model.ErrValidation{Message: "backup is not valid JSON: " + err.Error()}
+		}
+		return nil, err
+	}
+
+	return st.response, nil
+}
+
+// decode restores the rows of the backup document as they are read.
+func (st *restore) decode(ctx context.Context, d *json.Decoder) error {
+	if err := expectDelim(d, '{'); err != nil {
+		return err
+	}
+
+	var (
+		format  string
+		version json.Number
+	)
+	for d.More() {
+		key, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "format":
+			err = d.Decode(&format)
+		case "version":
+			err = d.Decode(&version)
+		case "tables":
+			if format != model.BackupFormat {
+				return model.ErrValidation{Message: "backup must begin with format " + model.BackupFormat}
+			}
+			if v, verr := version.Int64(); verr != nil || v < 1 || v > model.BackupVersion {
+				return model.ErrValidation{Message: fmt.Sprintf("backup version %s is not supported", version)}
+			}
+			err = st.decodeTables(ctx, d)
+		default:
+			var skip json.RawMessage
+			err = d.Decode(&skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(d, '}')
+}
+
+func (st *restore) decodeTables(ctx context.Context, d *json.Decoder) error {
+	if err := expectDelim(d, '{'); err != nil {
+		return err
+	}
+	for d.More() {
+		key, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		var table *backupTable
+		for i := range backupTables {
+			if backupTables[i].name == key {
+				table = &backupTables[i]
+			}
+		}
+		if table == nil {
+			return model.ErrValidation{Message: fmt.Sprintf("backup has unknown table %v", key)}
+		}
+
+		types, err := st.s.columnTypes(ctx, table.name)
+		if err != nil {
+			return err
+		}
+
+		if err := expectDelim(d, '['); err != nil {
+			return err
+		}
+		for i := 0; d.More(); i++ {
+			var row map[string]interface{}
+			if err := d.Decode(&row); err != nil {
+				return err
+			}
+			values, err := convertRow(row, types)
+			if err == nil {
+				err = st.restoreRow(ctx, table, values)
+			}
+			if err != nil {
+				var validation model.ErrValidation
+				var sqliteErr sqlite3.Error
+				switch {
+				case errors.As(err, &validation):
+					validation.Message = fmt.Sprintf("%s[%d] %s", table.name, i, validation.Message)
+					return validation
+				case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint:
+					return model.ErrValidation{Message: fmt.Sprintf("%s[%d] violates a constraint: %v", table.name, i, err)}
+				}
+				return err
+			}
+		}
+		if err := expectDelim(d, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(d, '}')
+}
+
+// restoreRow inserts the row by the conflict strategy when its id is taken.
+func (st *restore) restoreRow(ctx context.Context, table *backupTable, values map[string]interface{}) error {
+	id, ok := values["id"].(int64)
+	if !ok {
+		return model.ErrValidation{Message: "id must be an integer"}
+	}
+	result := st.response.Tables[table.name]
+
+	var (
+		exists bool
+		prior  *model.TODO
+	)
+	err := connFrom(ctx, st.s.db).QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE id = ?)`, table.name), id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !exists:
+		result.Inserted++
+	case st.onConflict == model.RestoreOverwrite:
+		if table.events {
+			if prior, _, err = st.s.todos.GetTODO(ctx, id); err != nil {
+				return err
+			}
+		}
+		if _, err := connFrom(ctx, st.s.db).ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, table.name), id); err != nil {
+			return err
+		}
+		result.Overwritten++
+	case st.onConflict == model.RestoreRenumber:
+		delete(values, "id")
+		result.Renumbered++
+	default:
+		result.Skipped++
+		return nil
+	}
+
+	// references to the rows renumbered before this one are known
+	forward := false
+	for column, target := range table.references {
+		ref, ok := values[column].(int64)
+		if !ok {
+			continue
+		}
+		if newID, ok := st.ids[target][ref]; ok {
+			values[column] = newID
+		} else if st.onConflict == model.RestoreRenumber {
+			forward = true
+		}
+	}
+
+	newID, err := st.s.insert(ctx, table.name, values)
+	if err != nil {
+		return err
+	}
+	if table.events {
+		if err := st.s.recordRestored(ctx, prior, newID); err != nil {
+			return err
+		}
+	}
+	if newID != id {
+		st.ids[table.name][id] = newID
+		if result.IDs == nil {
+			result.IDs = make(map[string]int64)
+		}
+		result.IDs[strconv.FormatInt(id, 10)] = newID
+	}
+	if forward {
+		st.pending = append(st.pending, &restoredRow{table: table, id: newID, values: values})
+	}
+	return nil
+}
+
+// resolve updates the references of the pending rows to the rows renumbered
+// after them. The rows are inserted again rather than updated, which would
+// change their updated_at.
+func (st *restore) resolve(ctx context.Context) error {
+	for _, row := range st.pending {
+		changed := false
+		for column, target := range row.table.references {
+			ref, ok := row.values[column].(int64)
+			if !ok {
+				continue
+			}
+			if newID, ok := st.ids[target][ref]; ok {
+				row.values[column] = newID
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		if _, err := connFrom(ctx, st.s.db).ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, row.table.name), row.id); err != nil {
+			return err
+		}
+		row.values["id"] = row.id
+		if _, err := st.s.insert(ctx, row.table.name, row.values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRestored logs the event of the TODO of id restored in place of prior,
+// which is nil when it was inserted.
+func (s *BackupService) recordRestored(ctx context.Context, prior *model.TODO, id int64) error {
+	t, _, err := s.todos.GetTODO(ctx, id)
+	if err != nil {
+		return err
+	}
+	if prior == nil {
+		return s.todos.recordEvent(ctx, model.EventCreated, nil, t)
+	}
+	return s.todos.recordEvent(ctx, model.EventUpdated, prior, t)
+}
+
+// insert inserts the row into the table and returns its id.
+func (s *BackupService) insert(ctx context.Context, table string, values map[string]interface{}) (int64, error) {
+	columns := make([]string, 0, len(values))
+	for c := range values {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+
+	args := make([]interface{}, len(columns))
+	for i, c := range columns {
+		args[i] = values[c]
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s DEFAULT VALUES`, table)
+	if len(columns) > 0 {
+		query = fmt.Sprintf(`INSERT INTO %s(%s) VALUES(%s)`,
+			table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	}
+	res, err := connFrom(ctx, s.db).ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// columnTypes returns the declared types of the columns of the table.
+func (s *BackupService) columnTypes(ctx context.Context, table string) (map[string]string, error) {
+	rows, err := connFrom(ctx, s.db).QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			typ       string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		types[name] = strings.ToUpper(typ)
+	}
+	return types, rows.Err()
+}
+
+// convertRow converts the JSON values of the row into the ones of the columns.
+// Times are stored in the layout of DATETIME('now') in UTC.
+func convertRow(row map[string]interface{}, types map[string]string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(row))
+	for column, v := range row {
+		typ, ok := types[column]
+		if !ok {
+			return nil, model.ErrValidation{Message: fmt.Sprintf("has unknown column %s", column)}
+		}
+
+		switch v := v.(type) {
+		case nil:
+			values[column] = nil
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				values[column] = i
+			} else if f, err := v.Float64(); err == nil {
+				values[column] = f
+			} else {
+				return nil, model.ErrValidation{Message: fmt.Sprintf("%s is an invalid number", column)}
+			}
+		case string:
+			if typ != "DATETIME" {
+				values[column] = v
+				continue
+			}
+			t, err := parseBackupTime(v)
+			if err != nil {
+				return nil, model.ErrValidation{Message: fmt.Sprintf("%s is an invalid time", column)}
+			}
+			values[column] = t.UTC().Format(dateTimeLayout)
+		default:
+			return nil, model.ErrValidation{Message: fmt.Sprintf("%s must be a number, a string or null", column)}
+		}
+	}
+	return values, nil
+}
+
+func parseBackupTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateTimeLayout, s, time.UTC)
+}
+
+func expectDelim(d *json.Decoder, delim json.Delim) error {
+	token, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return model.ErrValidation{Message: fmt.Sprintf("backup has %v where %v is expected", token, delim)}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		onConflict string
+		result     model.RestoreTableResult
+		// subjects and parents are the ones of the TODOs after the restore by
+		// id
+		subjects map[int64]string
+		parents  map[int64]int64
+	}{
+		"Skip": {
+			onConflict: model.RestoreSkip,
+			result:     model.RestoreTableResult{Inserted: 1, Skipped: 1},
+			subjects:   map[int64]string{1: "existing", 2: "b"},
+			parents:    map[int64]int64{2: 1},
+		},
+		"Overwrite": {
+			onConflict: model.RestoreOverwrite,
+			result:     model.RestoreTableResult{Inserted: 1, Overwritten: 1},
+			subjects:   map[int64]string{1: "a", 2: "b"},
+			parents:    map[int64]int64{2: 1},
+		},
+		"Renumber": {
+			onConflict: model.RestoreRenumber,
+			result:     model.RestoreTableResult{Renumbered: 2, IDs: map[string]int64{"1": 2, "2": 3}},
+			subjects:   map[int64]string{1: "existing", 2: "a", 3: "b"},
+			parents:    map[int64]int64{3: 2},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			srcDB := newTestDB(t)
+			src := service.NewTODOService(srcDB)
+			a, err := src.CreateTODO(ctx, "a", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := src.CreateTODOInOutline(ctx, "b", "", model.TODOOutline{ParentID: a.ID}); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			var backup bytes.Buffer
+			if err := service.NewBackupService(srcDB, src).Dump(ctx, &backup); err != nil {
+				t.Fatal("failed to dump, err =", err)
+			}
+
+			dstDB := newTestDB(t)
+			dst := service.NewTODOService(dstDB)
+			if _, err := dst.CreateTODO(ctx, "existing", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			res, err := service.NewBackupService(dstDB, dst).Restore(ctx, &backup, c.onConflict)
+			if err != nil {
+				t.Fatal("failed to restore, err =", err)
+			}
+			if !reflect.DeepEqual(res.Tables["todos"], &c.result) {
+				t.Errorf("unexpected result, given = %+v, expected = %+v", res.Tables["todos"], c.result)
+			}
+
+			todos, err := dst.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			subjects := make(map[int64]string)
+			for _, td := range todos {
+				subjects[td.ID] = td.Subject
+			}
+			if !reflect.DeepEqual(subjects, c.subjects) {
+				t.Errorf("unexpected subjects, given = %v, expected = %v", subjects, c.subjects)
+			}
+			outlines, err := dst.ReadTODOOutlines(ctx)
+			if err != nil {
+				t.Fatal("failed to read outlines, err =", err)
+			}
+			parents := make(map[int64]int64)
+			for id, o := range outlines {
+				if o.ParentID != 0 {
+					parents[id] = o.ParentID
+				}
+			}
+			if !reflect.DeepEqual(parents, c.parents) {
+				t.Errorf("unexpected parents, given = %v, expected = %v", parents, c.parents)
+			}
+		})
+	}
+}
+
+func TestRestoreEvents(t *testing.T) {
+	t.Parallel()
+
+	const backup = `{"format":"` + model.BackupFormat + `","version":1,"tables":{"todos":[
+{"id":1,"subject":"restored","description":"","created_at":"2020-01-01T00:00:00Z","updated_at":"2020-01-01T00:00:00Z"},
+{"id":2,"subject":"new","description":"","created_at":"2020-01-01T00:00:00Z","updated_at":"2020-01-01T00:00:00Z"}]}}`
+
+	cases := map[string]struct {
+		onConflict string
+		// events are the types of the events logged by the restore
+		events []string
+		// prior is the subject of the TODO before the first event
+		prior string
+	}{
+		"Skip":      {onConflict: model.RestoreSkip, events: []string{model.EventCreated}},
+		"Overwrite": {onConflict: model.RestoreOverwrite, events: []string{model.EventUpdated, model.EventCreated}, prior: "existing"},
+		"Renumber":  {onConflict: model.RestoreRenumber, events: []string{model.EventCreated, model.EventCreated}},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			todoDB := newTestDB(t)
+			ts := service.NewTODOService(todoDB)
+			bs := service.NewBackupService(todoDB, ts)
+
+			if _, err := ts.CreateTODO(ctx, "existing", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			_, last, err := ts.EventLogBounds(ctx)
+			if err != nil {
+				t.Fatal("failed to read event log, err =", err)
+			}
+
+			if _, err := bs.Restore(ctx, strings.NewReader(backup), c.onConflict); err != nil {
+				t.Fatal("failed to restore, err =", err)
+			}
+
+			events, err := ts.ReadEvents(ctx, &model.ReadEventsRequest{}, last, 10)
+			if err != nil {
+				t.Fatal("failed to read events, err =", err)
+			}
+			if len(events) != len(c.events) {
+				t.Fatalf("unexpected events, given = %d, expected = %d", len(events), len(c.events))
+			}
+			for i, e := range events {
+				if e.Type != c.events[i] {
+					t.Errorf("unexpected event type, given = %s, expected = %s", e.Type, c.events[i])
+				}
+				if e.TODO == nil || e.TODO.Subject == "existing" {
+					t.Errorf("unexpected todo of event %d, given = %+v", e.ID, e.TODO)
+				}
+			}
+			if c.prior != "" && (events[0].Prior == nil || events[0].Prior.Subject != c.prior) {
+				t.Errorf("unexpected prior, given = %+v, expected = %s", events[0].Prior, c.prior)
+			}
+		})
+	}
+}
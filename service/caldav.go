@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// calDAVNameFmt and calDAVUIDFmt name the TODOs not created by CalDAV
+	// clients. The UIDs are the ones of the iCalendar export.
+	calDAVNameFmt = "todo-%d.ics"
+	calDAVUIDFmt  = "todo-%d@go-stations"
+
+	calDAVObjectColumns = `id, subject, description, version, created_at, updated_at, caldav_name, caldav_uid`
+)
+
+// ReadCalDAVObjects reads all TODOs on DB as CalDAV objects in the ascending
+// order of ids.
+func (s *TODOService) ReadCalDAVObjects(ctx context.Context) ([]*model.CalDAVObject, error) {
+	const read = `SELECT ` + calDAVObjectColumns + ` FROM todos ORDER BY id`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, read)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := make([]*model.CalDAVObject, 0)
+	for rows.Next() {
+		obj, err := scanCalDAVObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// GetCalDAVObject reads the TODO of the CalDAV object named name on DB.
+func (s *TODOService) GetCalDAVObject(ctx context.Context, name string) (*model.CalDAVObject, error) {
+	const (
+		readName = `SELECT ` + calDAVObjectColumns + ` FROM todos WHERE caldav_name = ?`
+		readID   = `SELECT ` + calDAVObjectColumns + ` FROM todos WHERE id = ? AND caldav_name IS NULL`
+	)
+
+	obj, err := scanCalDAVObject(s.conn(ctx).QueryRowContext(ctx, readName, name))
+	if err != sql.ErrNoRows {
+		return obj, err
+	}
+
+	id, ok := defaultCalDAVID(calDAVNameFmt, name)
+	if !ok {
+		return nil, model.ErrNotFound{}
+	}
+	obj, err = scanCalDAVObject(s.conn(ctx).QueryRowContext(ctx, readID, id))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotFound{}
+	}
+	return obj, err
+}
+
+// PutCalDAVObject updates the TODO of the CalDAV object named name on DB, or
+// creates it with uid when it does not exist, and reports whether it was
+// created. The TODO must exist at the version ifMatch unless it is nil, and
+// must not exist when ifNoneMatch is true.
+func (s *TODOService) PutCalDAVObject(ctx context.Context, name, uid, subject, description string, ifMatch *int64, ifNoneMatch bool) (*model.CalDAVObject, bool, error) {
+	const (
+		usedUID = `SELECT COUNT(*) FROM todos WHERE caldav_uid = ? OR (caldav_uid IS NULL AND id = ?)`
+		insert  = `INSERT INTO todos(subject, description, caldav_name, caldav_uid) VALUES(?, ?, ?, ?)`
+		confirm = `SELECT subject, description, version, created_at, updated_at FROM todos WHERE id = ?`
+	)
+
+	var (
+		obj     *model.CalDAVObject
+		created bool
+	)
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		existing, err := s.GetCalDAVObject(ctx, name)
+		switch {
+		case err == nil:
+			if ifNoneMatch {
+				return model.ErrPreconditionFailed{}
+			}
+			t, version, err := s.UpdateTODOIfMatch(ctx, existing.TODO.ID, ifMatch, subject, description)
+			if err != nil {
+				return err
+			}
+			existing.TODO, existing.Version = t, version
+			obj = existing
+			return nil
+		case !errors.As(err, &model.ErrNotFound{}):
+			return err
+		case ifMatch != nil:
+			return model.ErrPreconditionFailed{}
+		}
+
+		// the names after ids are kept for the TODOs created later
+		if _, ok := defaultCalDAVID(calDAVNameFmt, name); ok {
+			return model.ErrConflict{Message: fmt.Sprintf("resource name %q is reserved", name)}
+		}
+		id, _ := defaultCalDAVID(calDAVUIDFmt, uid)
+		var count int64
+		if err := s.conn(ctx).QueryRowContext(ctx, usedUID, uid, id).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return model.ErrConflict{Message: fmt.Sprintf("UID %q is used by another resource", uid)}
+		}
+
+		res, err := s.conn(ctx).ExecContext(ctx, insert, subject, description, name, uid)
+		if err != nil {
+			return err
+		}
+		t := &model.TODO{}
+		if t.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		obj = &model.CalDAVObject{TODO: t, Name: name, UID: uid}
+		err = s.conn(ctx).QueryRowContext(ctx, confirm, t.ID).Scan(&t.Subject, &t.Description, &obj.Version, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		created = true
+
+		return s.recordEvent(ctx, model.EventCreated, nil, t)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return obj, created, nil
+}
+
+// DeleteCalDAVObject deletes the TODO of the CalDAV object named name on DB
+// when its version equals ifMatch, or regardless of its version when ifMatch
+// is nil.
+func (s *TODOService) DeleteCalDAVObject(ctx context.Context, name string, ifMatch *int64) error {
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		obj, err := s.GetCalDAVObject(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		id := obj.TODO.ID
+		if ifMatch != nil {
+			return s.DeleteTODOIfMatch(ctx, []int64{id}, map[int64]int64{id: *ifMatch})
+		}
+		return s.DeleteTODO(ctx, []int64{id})
+	})
+}
+
+// defaultCalDAVID returns the id of the TODO which s is named after by format.
+func defaultCalDAVID(format, s string) (int64, bool) {
+	var id int64
+	if _, err := fmt.Sscanf(s, format, &id); err != nil || fmt.Sprintf(format, id) != s {
+		return 0, false
+	}
+	return id, true
+}
+
+func scanCalDAVObject(row scanner) (*model.CalDAVObject, error) {
+	var (
+		obj       = &model.CalDAVObject{TODO: &model.TODO{}}
+		name, uid sql.NullString
+	)
+	t := obj.TODO
+	if err := row.Scan(&t.ID, &t.Subject, &t.Description, &obj.Version, &t.CreatedAt, &t.UpdatedAt, &name, &uid); err != nil {
+		return nil, err
+	}
+
+	obj.Name, obj.UID = name.String, uid.String
+	if !name.Valid {
+		obj.Name = fmt.Sprintf(calDAVNameFmt, t.ID)
+	}
+	if !uid.Valid {
+		obj.UID = fmt.Sprintf(calDAVUIDFmt, t.ID)
+	}
+	return obj, nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// An eventNotifier wakes the subscribers of the event log when events are
+// committed to it.
+type eventNotifier struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newEventNotifier() *eventNotifier {
+	return &eventNotifier{
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (n *eventNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subs {
+		// a pending notification covers this one
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents returns a channel which receives a value when events are
+// committed to the event log, and the function that cancels the subscription.
+func (s *TODOService) SubscribeEvents() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	s.events.mu.Lock()
+	s.events.subs[ch] = struct{}{}
+	s.events.mu.Unlock()
+
+	return ch, func() {
+		s.events.mu.Lock()
+		delete(s.events.subs, ch)
+		s.events.mu.Unlock()
+	}
+}
+
+// ReadEvents reads the events after afterID matching req in the order they
+// were logged.
+func (s *TODOService) ReadEvents(ctx context.Context, req *model.ReadEventsRequest, afterID, size int64) ([]*model.TODOEvent, error) {
+	query := `SELECT id, type, todo_id, data, prior_data, created_at FROM todo_events WHERE id > ?`
+	args := []interface{}{afterID}
+	if len(req.Types) > 0 {
+		query += fmt.Sprintf(` AND type IN (?%s)`, strings.Repeat(", ?", len(req.Types)-1))
+		for _, t := range req.Types {
+			args = append(args, t)
+		}
+	}
+	if len(req.TODOIDs) > 0 {
+		query += fmt.Sprintf(` AND todo_id IN (?%s)`, strings.Repeat(", ?", len(req.TODOIDs)-1))
+		for _, id := range req.TODOIDs {
+			args = append(args, id)
+		}
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, size)
+
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*model.TODOEvent{}
+	for rows.Next() {
+		var (
+			e               model.TODOEvent
+			data, priorData sql.NullString
+			err             error
+		)
+		if err := rows.Scan(&e.ID, &e.Type, &e.TODOID, &data, &priorData, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if e.TODO, err = unmarshalEventTODO(data); err != nil {
+			return nil, err
+		}
+		if e.Prior, err = unmarshalEventTODO(priorData); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// EventLogBounds returns the id of the oldest event kept in the event log, and
+// the id of the last event logged. The oldest one follows the last one when
+// the log is empty.
+func (s *TODOService) EventLogBounds(ctx context.Context) (int64, int64, error) {
+	const (
+		last  = `SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todo_events'), 0)`
+		first = `SELECT COALESCE(MIN(id), ? + 1) FROM todo_events`
+	)
+
+	var firstID, lastID int64
+	err := RunInReadTx(ctx, s.db, func(ctx context.Context) error {
+		if err := s.conn(ctx).QueryRowContext(ctx, last).Scan(&lastID); err != nil {
+			return err
+		}
+		return s.conn(ctx).QueryRowContext(ctx, first, lastID).Scan(&firstID)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return firstID, lastID, nil
+}
+
+// recordEvent logs the event of the TODO, which is written with the change in
+// the transaction of ctx together with its webhook deliveries, and drops the
+// events older than the retention of the config. prior is the TODO before the
+// change, nil when it is created.
+func (s *TODOService) recordEvent(ctx context.Context, typ string, prior, t *model.TODO) error {
+	const prune = `DELETE FROM todo_events WHERE created_at < ?`
+
+	id, err := s.insertEvent(ctx, typ, t.ID, prior, t)
+	if err != nil {
+		return err
+	}
+	if err := s.enqueueDeliveries(ctx, id-1, 1); err != nil {
+		return err
+	}
+	if retention := s.config.EventRetention; retention > 0 {
+		if _, err := s.conn(ctx).ExecContext(ctx, prune, time.Now().Add(-retention).UTC().Format(dateTimeLayout)); err != nil {
+			return err
+		}
+	}
+
+	afterCommit(ctx, s.events.notify)
+	return nil
+}
+
+// recordDeleteEvents logs the deleted events of the existing TODOs of ids with
+// their webhook deliveries, before they are deleted in the transaction of ctx.
+func (s *TODOService) recordDeleteEvents(ctx context.Context, ids []interface{}) error {
+	const readFmt = `SELECT id, subject, description, created_at, updated_at FROM todos WHERE id IN (?%s) ORDER BY id`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, fmt.Sprintf(readFmt, strings.Repeat(", ?", len(ids)-1)), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var deleted []*model.TODO
+	for rows.Next() {
+		t := &model.TODO{}
+		if err := rows.Scan(&t.ID, &t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return err
+		}
+		deleted = append(deleted, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	var first int64
+	for i, t := range deleted {
+		id, err := s.insertEvent(ctx, model.EventDeleted, t.ID, t, nil)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = id
+		}
+	}
+	// the ids of the events inserted in a transaction are consecutive
+	if err := s.enqueueDeliveries(ctx, first-1, int64(len(deleted))); err != nil {
+		return err
+	}
+
+	afterCommit(ctx, s.events.notify)
+	return nil
+}
+
+// insertEvent inserts the event of the TODO of todoID changed from prior to t
+// into the event log, and returns its id.
+func (s *TODOService) insertEvent(ctx context.Context, typ string, todoID int64, prior, t *model.TODO) (int64, error) {
+	const insert = `INSERT INTO todo_events(type, todo_id, data, prior_data) VALUES(?, ?, ?, ?)`
+
+	data, err := marshalEventTODO(t)
+	if err != nil {
+		return 0, err
+	}
+	priorData, err := marshalEventTODO(prior)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.conn(ctx).ExecContext(ctx, insert, typ, todoID, data, priorData)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// marshalEventTODO returns the JSON of t stored in the event log, which is
+// NULL when t is nil.
+func marshalEventTODO(t *model.TODO) (sql.NullString, error) {
+	if t == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalEventTODO returns the TODO of the JSON stored in the event log,
+// which is nil when data is NULL.
+func unmarshalEventTODO(data sql.NullString) (*model.TODO, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	t := &model.TODO{}
+	if err := json.Unmarshal([]byte(data.String), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestEventRetention(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		retention time.Duration
+		seqs      []int64
+	}{
+		"Kept": {
+			seqs: []int64{1, 2, 3},
+		},
+		"Pruned": {
+			retention: time.Hour,
+			seqs:      []int64{2, 3},
+		},
+		"Within the retention": {
+			retention: 100 * 365 * 24 * time.Hour,
+			seqs:      []int64{1, 2, 3},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			todoDB := newTestDB(t)
+			s := service.NewTODOServiceWithConfig(todoDB, service.TODOServiceConfig{EventRetention: c.retention})
+
+			old, err := s.CreateTODO(ctx, "old", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := todoDB.Exec(`UPDATE todo_events SET created_at = '2000-01-01 00:00:00'`); err != nil {
+				t.Fatal("failed to age events, err =", err)
+			}
+			if _, err := s.UpdateTODO(ctx, old.ID, "new", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			if _, err := s.CreateTODO(ctx, "new", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			events, err := s.ReadEvents(ctx, &model.ReadEventsRequest{}, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read events, err =", err)
+			}
+			if len(events) != len(c.seqs) {
+				t.Fatalf("unexpected events, given = %d, expected = %d", len(events), len(c.seqs))
+			}
+			for i, e := range events {
+				if e.ID != c.seqs[i] {
+					t.Errorf("unexpected event, given = %d, expected = %d", e.ID, c.seqs[i])
+				}
+			}
+
+			first, last, err := s.EventLogBounds(ctx)
+			if err != nil {
+				t.Fatal("failed to read bounds, err =", err)
+			}
+			if first != c.seqs[0] || last != 3 {
+				t.Errorf("unexpected bounds, given = %d %d, expected = %d %d", first, last, c.seqs[0], 3)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// An IdempotencyService implements storage of responses by Idempotency-Key.
+type IdempotencyService struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewIdempotencyService returns new IdempotencyService keeping responses for ttl.
+func NewIdempotencyService(db *sql.DB, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		db:  db,
+		ttl: ttl,
+	}
+}
+
+// Begin reserves the key for the request identified by fingerprint. It returns
+// the stored response when the request has already been completed, or nil when
+// the caller should process the request and Complete or Abort the key.
+func (s *IdempotencyService) Begin(ctx context.Context, key, fingerprint string) (*model.IdempotentResponse, error) {
+	const (
+		expire  = `DELETE FROM idempotency_keys WHERE expires_at < ?`
+		reserve = `INSERT OR IGNORE INTO idempotency_keys(key, fingerprint, expires_at) VALUES(?, ?, ?)`
+		confirm = `SELECT fingerprint, status, header, body FROM idempotency_keys WHERE key = ?`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, expire, now); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, reserve, key, fingerprint, now.Add(s.ttl))
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if reserved == 1 {
+		return nil, tx.Commit()
+	}
+
+	var (
+		storedFingerprint string
+		header            string
+		resp              = &model.IdempotentResponse{}
+	)
+	row := tx.QueryRowContext(ctx, confirm, key)
+	if err := row.Scan(&storedFingerprint, &resp.StatusCode, &header, &resp.Body); err != nil {
+		return nil, err
+	}
+	if storedFingerprint != fingerprint {
+		return nil, model.ErrIdempotencyKeyReused{}
+	}
+	if resp.StatusCode == 0 {
+		return nil, model.ErrIdempotencyKeyInUse{}
+	}
+	if err := json.Unmarshal([]byte(header), &resp.Header); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Complete stores the response of the request reserved by Begin.
+func (s *IdempotencyService) Complete(ctx context.Context, key string, resp *model.IdempotentResponse) error {
+	const update = `UPDATE idempotency_keys SET status = ?, header = ?, body = ? WHERE key = ?`
+
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	body := resp.Body
+	if body == nil {
+		body = []byte{}
+	}
+
+	_, err = s.db.ExecContext(ctx, update, resp.StatusCode, string(header), body, key)
+	return err
+}
+
+// Abort releases the key reserved by Begin so that the request can be retried.
+func (s *IdempotencyService) Abort(ctx context.Context, key string) error {
+	const delete = `DELETE FROM idempotency_keys WHERE key = ? AND status = 0`
+
+	_, err := s.db.ExecContext(ctx, delete, key)
+	return err
+}
@@ -3,183 +3,712 @@ package service
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/TechBowl-japan/go-stations/model"
 )
 
 // A TODOService implements CRUD of TODO entities.
 type TODOService struct {
-	db *sql.DB
+	db     *sql.DB
+	config TODOServiceConfig
+	events *eventNotifier
 }
 
-// NewTODOService returns new TODOService.
+// TODOServiceConfig configures TODOService.
+type TODOServiceConfig struct {
+	// EventRetention is how long the event log, which is the change feed,
+	// keeps events. Every event is kept when it is zero.
+	EventRetention time.Duration
+}
+
+// NewTODOService returns new TODOService which keeps every event.
 func NewTODOService(db *sql.DB) *TODOService {
+	return NewTODOServiceWithConfig(db, TODOServiceConfig{})
+}
+
+// NewTODOServiceWithConfig returns new TODOService configured by config.
+func NewTODOServiceWithConfig(db *sql.DB, config TODOServiceConfig) *TODOService {
 	return &TODOService{
-		db: db,
+		db:     db,
+		config: config,
+		events: newEventNotifier(),
 	}
 }
 
 // CreateTODO creates a TODO on DB.
 func (s *TODOService) CreateTODO(ctx context.Context, subject, description string) (*model.TODO, error) {
+	t, _, err := s.CreateTODOWithVersion(ctx, subject, description)
+	return t, err
+}
+
+// CreateTODOWithVersion creates a TODO on DB and returns it with its version.
+func (s *TODOService) CreateTODOWithVersion(ctx context.Context, subject, description string) (*model.TODO, int64, error) {
 	const (
 		insert  = `INSERT INTO todos(subject, description) VALUES(?, ?)`
+		confirm = `SELECT subject, description, version, created_at, updated_at FROM todos WHERE id = ?`
+	)
+
+	var (
+		t       = &model.TODO{}
+		version int64
+	)
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		stmt, err := s.conn(ctx).PrepareContext(ctx, insert)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		res, err := stmt.ExecContext(ctx, subject, description)
+		if err != nil {
+			return err
+		}
+		if t.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		stmt, err = s.conn(ctx).PrepareContext(ctx, confirm)
+		if err != nil {
+			return err
+		}
+		err = stmt.QueryRowContext(ctx, t.ID).Scan(&t.Subject, &t.Description, &version, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.recordEvent(ctx, model.EventCreated, nil, t)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return t, version, nil
+}
+
+// dateTimeLayout is the layout of DATETIME('now'), which the times written by
+// the services follow so that they are ordered as text.
+const dateTimeLayout = "2006-01-02 15:04:05"
+
+// CreateTODOAt creates a TODO on DB with the times it was created and last
+// updated, such as the ones of an imported TODO.
+func (s *TODOService) CreateTODOAt(ctx context.Context, subject, description string, createdAt, updatedAt time.Time) (*model.TODO, error) {
+	const (
+		insert  = `INSERT INTO todos(subject, description, created_at, updated_at) VALUES(?, ?, ?, ?)`
 		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
 	)
 
-	stmt, err := s.db.PrepareContext(ctx, insert)
+	t := &model.TODO{}
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		stmt, err := s.conn(ctx).PrepareContext(ctx, insert)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		res, err := stmt.ExecContext(ctx, subject, description,
+			createdAt.UTC().Format(dateTimeLayout), updatedAt.UTC().Format(dateTimeLayout))
+		if err != nil {
+			return err
+		}
+		if t.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		err = s.conn(ctx).QueryRowContext(ctx, confirm, t.ID).Scan(&t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.recordEvent(ctx, model.EventCreated, nil, t)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
-	res, err := stmt.ExecContext(ctx, subject, description)
+
+	return t, nil
+}
+
+// CreateTODOInOutline creates a TODO on DB placed in the outline.
+func (s *TODOService) CreateTODOInOutline(ctx context.Context, subject, description string, outline model.TODOOutline) (*model.TODO, error) {
+	const (
+		insert  = `INSERT INTO todos(subject, description, group_name, parent_id) VALUES(?, ?, ?, ?)`
+		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
+	)
+
+	var parentID sql.NullInt64
+	if outline.ParentID != 0 {
+		parentID = sql.NullInt64{Int64: outline.ParentID, Valid: true}
+	}
+
+	t := &model.TODO{}
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		res, err := s.conn(ctx).ExecContext(ctx, insert, subject, description, outline.Group, parentID)
+		if err != nil {
+			return err
+		}
+		if t.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		err = s.conn(ctx).QueryRowContext(ctx, confirm, t.ID).Scan(&t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return s.recordEvent(ctx, model.EventCreated, nil, t)
+	})
 	if err != nil {
 		return nil, err
 	}
-	id, err := res.LastInsertId()
+
+	return t, nil
+}
+
+// ReadTODOOutlines reads the outlines of all TODOs on DB keyed by their ids.
+func (s *TODOService) ReadTODOOutlines(ctx context.Context) (map[int64]model.TODOOutline, error) {
+	const read = `SELECT id, group_name, parent_id FROM todos`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, read)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	t := &model.TODO{ID: id}
+	outlines := make(map[int64]model.TODOOutline)
+	for rows.Next() {
+		var (
+			id       int64
+			outline  model.TODOOutline
+			parentID sql.NullInt64
+		)
+		if err := rows.Scan(&id, &outline.Group, &parentID); err != nil {
+			return nil, err
+		}
+		outline.ParentID = parentID.Int64
+		outlines[id] = outline
+	}
 
-	stmt, err = s.db.PrepareContext(ctx, confirm)
+	return outlines, rows.Err()
+}
+
+// ReadTODOOutlinesOf reads the outlines of the TODOs of ids on DB keyed by
+// their ids.
+func (s *TODOService) ReadTODOOutlinesOf(ctx context.Context, ids []int64) (map[int64]model.TODOOutline, error) {
+	const readFmt = `SELECT id, group_name, parent_id FROM todos WHERE id IN (?%s)`
+
+	outlines := make(map[int64]model.TODOOutline)
+	if len(ids) == 0 {
+		return outlines, nil
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx, fmt.Sprintf(readFmt, strings.Repeat(", ?", len(ids)-1)), int64Args(ids)...)
 	if err != nil {
 		return nil, err
 	}
-	err = stmt.QueryRowContext(ctx, t.ID).Scan(&t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt)
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       int64
+			outline  model.TODOOutline
+			parentID sql.NullInt64
+		)
+		if err := rows.Scan(&id, &outline.Group, &parentID); err != nil {
+			return nil, err
+		}
+		outline.ParentID = parentID.Int64
+		outlines[id] = outline
+	}
+
+	return outlines, rows.Err()
+}
+
+// FindTODOs reads up to size TODOs matching filter on DB in the descending
+// order of ids, after prevID unless it is 0, and returns them with their
+// versions keyed by id. The query of filter ignores the case of ASCII letters
+// only.
+func (s *TODOService) FindTODOs(ctx context.Context, filter *model.TODOFilter, prevID, size int64) ([]*model.TODO, map[int64]int64, error) {
+	const readFmt = `SELECT id, version, subject, description, created_at, updated_at FROM todos WHERE %s ORDER BY id DESC LIMIT ?`
+
+	where, args := todoFilterClause(filter)
+	if prevID != 0 {
+		where += ` AND id < ?`
+		args = append(args, prevID)
+	}
+	args = append(args, size)
+
+	rows, err := s.conn(ctx).QueryContext(ctx, fmt.Sprintf(readFmt, where), args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
-	return t, nil
+	todos := make([]*model.TODO, 0)
+	versions := make(map[int64]int64)
+	for rows.Next() {
+		var (
+			t       model.TODO
+			version int64
+		)
+		if err := rows.Scan(&t.ID, &version, &t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, nil, err
+		}
+		todos = append(todos, &t)
+		versions[t.ID] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return todos, versions, nil
+}
+
+// CountTODOsMatching returns the number of TODOs matching filter on DB.
+func (s *TODOService) CountTODOsMatching(ctx context.Context, filter *model.TODOFilter) (int64, error) {
+	const countFmt = `SELECT COUNT(*) FROM todos WHERE %s`
+
+	where, args := todoFilterClause(filter)
+	var n int64
+	if err := s.conn(ctx).QueryRowContext(ctx, fmt.Sprintf(countFmt, where), args...).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReadTODOChildren reads the TODOs nested in the TODOs of parentIDs on DB in
+// the ascending order of ids, keyed by the ids of their parents, and returns
+// them with their versions keyed by id.
+func (s *TODOService) ReadTODOChildren(ctx context.Context, parentIDs []int64) (map[int64][]*model.TODO, map[int64]int64, error) {
+	const readFmt = `SELECT id, version, subject, description, created_at, updated_at, parent_id FROM todos
+		WHERE parent_id IN (?%s) ORDER BY id`
+
+	children := make(map[int64][]*model.TODO)
+	versions := make(map[int64]int64)
+	if len(parentIDs) == 0 {
+		return children, versions, nil
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx, fmt.Sprintf(readFmt, strings.Repeat(", ?", len(parentIDs)-1)), int64Args(parentIDs)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			t        model.TODO
+			version  int64
+			parentID int64
+		)
+		if err := rows.Scan(&t.ID, &version, &t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt, &parentID); err != nil {
+			return nil, nil, err
+		}
+		children[parentID] = append(children[parentID], &t)
+		versions[t.ID] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return children, versions, nil
+}
+
+// todoFilterClause returns the condition of the TODOs matching filter with its
+// arguments.
+func todoFilterClause(filter *model.TODOFilter) (string, []interface{}) {
+	where, args := "1 = 1", []interface{}{}
+	if filter == nil {
+		return where, args
+	}
+	if len(filter.IDs) > 0 {
+		where += fmt.Sprintf(` AND id IN (?%s)`, strings.Repeat(", ?", len(filter.IDs)-1))
+		args = append(args, int64Args(filter.IDs)...)
+	}
+	if filter.Query != "" {
+		where += ` AND (INSTR(LOWER(subject), LOWER(?)) > 0 OR INSTR(LOWER(description), LOWER(?)) > 0)`
+		args = append(args, filter.Query, filter.Query)
+	}
+	return where, args
+}
+
+func int64Args(values []int64) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
 }
 
 // ReadTODO reads TODOs on DB.
 func (s *TODOService) ReadTODO(ctx context.Context, prevID, size int64) ([]*model.TODO, error) {
+	todos, _, err := s.ReadTODOWithVersions(ctx, prevID, size)
+	return todos, err
+}
+
+// ReadTODOWithVersions reads TODOs on DB and returns them with their versions keyed by id.
+func (s *TODOService) ReadTODOWithVersions(ctx context.Context, prevID, size int64) ([]*model.TODO, map[int64]int64, error) {
+	return s.ReadTODOFields(ctx, prevID, size, nil)
+}
+
+// ReadTODOFields reads TODOs on DB querying only the columns of fields, which
+// are JSON names of model.TODO, and returns them with their versions keyed by
+// id. The id is always read, and every field is read when fields is empty.
+func (s *TODOService) ReadTODOFields(ctx context.Context, prevID, size int64, fields []string) ([]*model.TODO, map[int64]int64, error) {
 	const (
-		read       = `SELECT id, subject, description, created_at, updated_at FROM todos ORDER BY id DESC LIMIT ?`
-		readWithID = `SELECT id, subject, description, created_at, updated_at FROM todos WHERE id < ? ORDER BY id DESC LIMIT ?`
+		readFmt       = `SELECT id, version%s FROM todos ORDER BY id DESC LIMIT ?`
+		readWithIDFmt = `SELECT id, version%s FROM todos WHERE id < ? ORDER BY id DESC LIMIT ?`
 	)
 
+	if len(fields) == 0 {
+		fields = model.TODOFields
+	}
+	var columns string
+	for _, f := range fields {
+		switch f {
+		case "id":
+			continue
+		case "subject", "description", "created_at", "updated_at":
+			columns += ", " + f
+		default:
+			return nil, nil, fmt.Errorf("unknown field %q", f)
+		}
+	}
+
 	var rows *sql.Rows
 	if prevID == 0 {
-		stmt, err := s.db.PrepareContext(ctx, read)
+		stmt, err := s.conn(ctx).PrepareContext(ctx, fmt.Sprintf(readFmt, columns))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		defer stmt.Close()
 
 		rows, err = stmt.QueryContext(ctx, size)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
-		stmt, err := s.db.PrepareContext(ctx, readWithID)
+		stmt, err := s.conn(ctx).PrepareContext(ctx, fmt.Sprintf(readWithIDFmt, columns))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		defer stmt.Close()
 
 		rows, err = stmt.QueryContext(ctx, prevID, size)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
+	defer rows.Close()
 
 	todos := make([]*model.TODO, 0)
+	versions := make(map[int64]int64)
 	for rows.Next() {
 		todo := &model.TODO{}
+		var version int64
+
+		dest := []interface{}{&todo.ID, &version}
+		for _, f := range fields {
+			switch f {
+			case "subject":
+				dest = append(dest, &todo.Subject)
+			case "description":
+				dest = append(dest, &todo.Description)
+			case "created_at":
+				dest = append(dest, &todo.CreatedAt)
+			case "updated_at":
+				dest = append(dest, &todo.UpdatedAt)
+			}
+		}
 
-		err := rows.Scan(&todo.ID, &todo.Subject, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
-		if err != nil {
-			return nil, err
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
 		}
 
 		todos = append(todos, todo)
+		versions[todo.ID] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return todos, nil
+	return todos, versions, nil
 }
 
-// UpdateTODO updates the TODO on DB.
-func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
-	const (
-		update  = `UPDATE todos SET subject = ?, description = ? WHERE id = ?`
-		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
-	)
+// CountTODOs returns the number of TODOs on DB.
+func (s *TODOService) CountTODOs(ctx context.Context) (int64, error) {
+	const count = `SELECT COUNT(*) FROM todos`
 
-	stmt, err := s.db.PrepareContext(ctx, update)
-	if err != nil {
-		return nil, err
+	var n int64
+	if err := s.conn(ctx).QueryRowContext(ctx, count).Scan(&n); err != nil {
+		return 0, err
 	}
+	return n, nil
+}
 
-	defer stmt.Close()
+// GetTODO reads the TODO of id on DB with its version.
+func (s *TODOService) GetTODO(ctx context.Context, id int64) (*model.TODO, int64, error) {
+	const read = `SELECT subject, description, version, created_at, updated_at FROM todos WHERE id = ?`
 
-	res, err := stmt.ExecContext(ctx, subject, description, id)
-	if err != nil {
-		return nil, err
+	t := &model.TODO{ID: id}
+	var version int64
+	err := s.conn(ctx).QueryRowContext(ctx, read, id).Scan(&t.Subject, &t.Description, &version, &t.CreatedAt, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, 0, model.ErrNotFound{}
 	}
-	update_result, err := res.RowsAffected()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	if update_result == 0 {
-		return nil, &model.ErrNotFound{}
+
+	return t, version, nil
+}
+
+// LastModified returns the time the TODOs on DB were last created, updated or
+// deleted, which is zero when they have never been changed. The time of the
+// last event is included, because the TODOs imported or restored keep the
+// times they were last updated at their source.
+func (s *TODOService) LastModified(ctx context.Context) (time.Time, error) {
+	const lastModified = `SELECT MAX(t) FROM (SELECT MAX(updated_at) AS t FROM todos UNION ALL SELECT deleted_at FROM todos_deleted UNION ALL SELECT MAX(created_at) FROM todo_events)`
+
+	var t sql.NullString
+	if err := s.conn(ctx).QueryRowContext(ctx, lastModified).Scan(&t); err != nil {
+		return time.Time{}, err
 	}
+	if !t.Valid {
+		return time.Time{}, nil
+	}
+	return parseDateTime(t.String)
+}
+
+// UpdateTODO updates the TODO on DB.
+func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
+	const (
+		update  = `UPDATE todos SET subject = ?, description = ?, version = version + 1 WHERE id = ?`
+		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
+	)
 
 	t := &model.TODO{ID: id}
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		prior, _, err := s.GetTODO(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := s.conn(ctx).PrepareContext(ctx, update)
+		if err != nil {
+			return err
+		}
+
+		defer stmt.Close()
+
+		res, err := stmt.ExecContext(ctx, subject, description, id)
+		if err != nil {
+			return err
+		}
+		update_result, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if update_result == 0 {
+			return model.ErrNotFound{}
+		}
+
+		stmt, err = s.conn(ctx).PrepareContext(ctx, confirm)
+		if err != nil {
+			return err
+		}
+
+		row := stmt.QueryRowContext(ctx, id)
+		if err = row.Scan(&t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return err
+		}
 
-	stmt, err = s.db.PrepareContext(ctx, confirm)
+		return s.recordEvent(ctx, model.EventUpdated, prior, t)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	row := stmt.QueryRowContext(ctx, id)
-	if err = row.Scan(&t.Subject, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
-		return nil, err
+	return t, nil
+}
+
+// UpdateTODOIfMatch updates the TODO on DB when its version equals ifMatch, or
+// regardless of its version when ifMatch is nil, and returns its new version.
+func (s *TODOService) UpdateTODOIfMatch(ctx context.Context, id int64, ifMatch *int64, subject, description string) (*model.TODO, int64, error) {
+	const (
+		update        = `UPDATE todos SET subject = ?, description = ?, version = version + 1 WHERE id = ?`
+		updateVersion = `UPDATE todos SET subject = ?, description = ?, version = version + 1 WHERE id = ? AND version = ?`
+		confirm       = `SELECT subject, description, version, created_at, updated_at FROM todos WHERE id = ?`
+	)
+
+	var (
+		t       = &model.TODO{ID: id}
+		version int64
+	)
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		c := s.conn(ctx)
+
+		prior, _, err := s.GetTODO(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		var res sql.Result
+		if ifMatch == nil {
+			res, err = c.ExecContext(ctx, update, subject, description, id)
+		} else {
+			res, err = c.ExecContext(ctx, updateVersion, subject, description, id, *ifMatch)
+		}
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return model.ErrPreconditionFailed{}
+		}
+
+		row := c.QueryRowContext(ctx, confirm, id)
+		if err := row.Scan(&t.Subject, &t.Description, &version, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return err
+		}
+
+		return s.recordEvent(ctx, model.EventUpdated, prior, t)
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return t, nil
+	return t, version, nil
 }
 
 // DeleteTODO deletes TODOs on DB by ids.
 func (s *TODOService) DeleteTODO(ctx context.Context, ids []int64) error {
 	const deleteFmt = `DELETE FROM todos WHERE id IN (?%s)`
 	if len(ids) == 0 {
-		return errors.New("id not found")
+		return model.ErrValidation{Message: "ids required"}
 	}
 	delete := fmt.Sprintf(deleteFmt, strings.Repeat(", ?", len(ids)-1))
 
-	stmt, err := s.db.PrepareContext(ctx, delete)
-	if err != nil {
-		return err
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
 	}
 
-	defer stmt.Close()
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		if err := s.recordDeleteEvents(ctx, args); err != nil {
+			return err
+		}
+
+		stmt, err := s.conn(ctx).PrepareContext(ctx, delete)
+		if err != nil {
+			return err
+		}
+
+		defer stmt.Close()
+
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+
+		delete_rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if delete_rows == 0 {
+			return model.ErrNotFound{}
+		}
+
+		return nil
+	})
+}
+
+// DeleteTODOIfMatch deletes TODOs on DB by ids only when every TODO has the
+// version given in versions.
+func (s *TODOService) DeleteTODOIfMatch(ctx context.Context, ids []int64, versions map[int64]int64) error {
+	const (
+		selectFmt = `SELECT id, version FROM todos WHERE id IN (?%s)`
+		deleteFmt = `DELETE FROM todos WHERE id IN (?%s)`
+	)
+	if len(ids) == 0 {
+		return model.ErrValidation{Message: "ids required"}
+	}
+	placeholders := strings.Repeat(", ?", len(ids)-1)
 
 	args := []interface{}{}
 	for _, id := range ids {
 		args = append(args, id)
 	}
 
-	res, err := stmt.ExecContext(ctx, args...)
-	if err != nil {
-		return err
-	}
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		c := s.conn(ctx)
 
-	delete_rows, err := res.RowsAffected()
-	if err != nil {
+		rows, err := c.QueryContext(ctx, fmt.Sprintf(selectFmt, placeholders), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		found := 0
+		for rows.Next() {
+			var id, version int64
+			if err := rows.Scan(&id, &version); err != nil {
+				return err
+			}
+			if v, ok := versions[id]; !ok || v != version {
+				return model.ErrPreconditionFailed{}
+			}
+			found++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		if found == 0 {
+			return model.ErrNotFound{}
+		}
+
+		if err := s.recordDeleteEvents(ctx, args); err != nil {
+			return err
+		}
+
+		_, err = c.ExecContext(ctx, fmt.Sprintf(deleteFmt, placeholders), args...)
 		return err
-	}
+	})
+}
 
-	if delete_rows == 0 {
-		return model.ErrNotFound{}
+// RunInTx runs fn in a transaction shared by the services called with the context passed to fn.
+func (s *TODOService) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunInTx(ctx, s.db, fn)
+}
+
+// RunInReadTx runs fn in a read-only transaction shared by the services called with the context passed to fn.
+func (s *TODOService) RunInReadTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunInReadTx(ctx, s.db, fn)
+}
+
+// RunInSavepoint runs fn in a savepoint, so that only the changes made by fn are rolled back when it fails.
+func (s *TODOService) RunInSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunInSavepoint(ctx, s.db, fn)
+}
+
+// parseDateTime parses DATETIME values which the driver returns as text when
+// they are computed by SQL functions.
+func parseDateTime(s string) (time.Time, error) {
+	for _, layout := range []string{dateTimeLayout, "2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("invalid datetime %q", s)
+}
 
-	return nil
+func (s *TODOService) conn(ctx context.Context) conn {
+	return connFrom(ctx, s.db)
 }
@@ -0,0 +1,270 @@
+package service_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal("failed to create db, err =", err)
+	}
+	t.Cleanup(func() { todoDB.Close() })
+	return todoDB
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	const (
+		writers = 8
+		writes  = 20
+	)
+
+	cases := map[string]struct {
+		write func(ctx context.Context, s *service.TODOService, id int64, i int) error
+	}{
+		"UpdateTODO": {
+			write: func(ctx context.Context, s *service.TODOService, id int64, i int) error {
+				_, err := s.UpdateTODO(ctx, id, "updated", "")
+				return err
+			},
+		},
+		"UpdateTODOIfMatch": {
+			write: func(ctx context.Context, s *service.TODOService, id int64, i int) error {
+				version := int64(i)
+				_, _, err := s.UpdateTODOIfMatch(ctx, id, &version, "updated", "")
+				return err
+			},
+		},
+		"UpdateTODO and DeleteTODO": {
+			write: func(ctx context.Context, s *service.TODOService, id int64, i int) error {
+				if i == writes-1 {
+					return s.DeleteTODO(ctx, []int64{id})
+				}
+				_, err := s.UpdateTODO(ctx, id, "updated", "")
+				return err
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			s := service.NewTODOService(newTestDB(t))
+
+			var wg sync.WaitGroup
+			errs := make(chan error, writers*writes)
+			for w := 0; w < writers; w++ {
+				todo, err := s.CreateTODO(ctx, "subject", "")
+				if err != nil {
+					t.Fatal("failed to create todo, err =", err)
+				}
+				wg.Add(1)
+				go func(id int64) {
+					defer wg.Done()
+					for i := 0; i < writes; i++ {
+						if err := c.write(ctx, s, id, i); err != nil {
+							errs <- err
+						}
+					}
+				}(todo.ID)
+			}
+			wg.Wait()
+			close(errs)
+
+			failed := 0
+			for err := range errs {
+				if failed == 0 {
+					t.Error("failed to write, err =", err)
+				}
+				failed++
+			}
+			if failed > 0 {
+				t.Errorf("unexpected failures, given = %d, expected = 0", failed)
+			}
+		})
+	}
+}
+
+func TestReadTxDoesNotWait(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		// outer holds a transaction open while fn runs
+		outer func(ctx context.Context, s *service.TODOService, fn func() error) error
+	}{
+		"In a read": {
+			outer: func(ctx context.Context, s *service.TODOService, fn func() error) error {
+				return s.RunInReadTx(ctx, func(ctx context.Context) error {
+					if _, err := s.ReadTODO(ctx, 0, 10); err != nil {
+						return err
+					}
+					return fn()
+				})
+			},
+		},
+		"In a write": {
+			outer: func(ctx context.Context, s *service.TODOService, fn func() error) error {
+				return s.RunInTx(ctx, func(ctx context.Context) error {
+					if _, err := s.CreateTODO(ctx, "subject", ""); err != nil {
+						return err
+					}
+					return fn()
+				})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			s := service.NewTODOService(newTestDB(t))
+			if _, err := s.CreateTODO(ctx, "subject", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+
+			err := c.outer(ctx, s, func() error {
+				done := make(chan error, 1)
+				go func() {
+					done <- s.RunInReadTx(ctx, func(ctx context.Context) error {
+						_, err := s.ReadTODO(ctx, 0, 10)
+						return err
+					})
+				}()
+				select {
+				case err := <-done:
+					return err
+				case <-time.After(time.Second):
+					t.Error("unexpected wait, the read transaction is blocked")
+					return <-done
+				}
+			})
+			if err != nil {
+				t.Error("failed to read, err =", err)
+			}
+		})
+	}
+}
+
+func TestDeleteTODOWithoutIDs(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		delete func(ctx context.Context, s *service.TODOService) error
+	}{
+		"DeleteTODO": {
+			delete: func(ctx context.Context, s *service.TODOService) error {
+				return s.DeleteTODO(ctx, nil)
+			},
+		},
+		"DeleteTODOIfMatch": {
+			delete: func(ctx context.Context, s *service.TODOService) error {
+				return s.DeleteTODOIfMatch(ctx, []int64{}, map[int64]int64{})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := c.delete(context.Background(), service.NewTODOService(newTestDB(t)))
+			if !errors.As(err, &model.ErrValidation{}) {
+				t.Errorf("unexpected error, given = %v, expected = ErrValidation", err)
+			}
+		})
+	}
+}
+
+func TestUpdateTODOIfMatch(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		id int64
+		// ifMatch returns the version required from the current one, nil
+		// for none
+		ifMatch func(version int64) *int64
+		err     error
+	}{
+		"Current version": {
+			id:      1,
+			ifMatch: func(version int64) *int64 { return &version },
+		},
+		"No version": {
+			id:      1,
+			ifMatch: func(version int64) *int64 { return nil },
+		},
+		"Stale version": {
+			id:      1,
+			ifMatch: func(version int64) *int64 { version--; return &version },
+			err:     model.ErrPreconditionFailed{},
+		},
+		"Missing TODO": {
+			id:      2,
+			ifMatch: func(version int64) *int64 { return &version },
+			err:     model.ErrNotFound{},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			s := service.NewTODOService(newTestDB(t))
+			created, version, err := s.CreateTODOWithVersion(ctx, "a", "")
+			if err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			if _, err := s.UpdateTODO(ctx, created.ID, "b", ""); err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			_, versions, err := s.ReadTODOWithVersions(ctx, 0, 1)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			version = versions[created.ID]
+
+			updated, next, err := s.UpdateTODOIfMatch(ctx, c.id, c.ifMatch(version), "c", "")
+			if c.err != nil {
+				if err != c.err {
+					t.Errorf("unexpected error, given = %v, expected = %v", err, c.err)
+				}
+				if err := s.DeleteTODOIfMatch(ctx, []int64{c.id}, map[int64]int64{c.id: *c.ifMatch(version)}); err != c.err {
+					t.Errorf("unexpected error of the deletion, given = %v, expected = %v", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("failed to update todo, err =", err)
+			}
+			if updated.Subject != "c" || next != version+1 {
+				t.Errorf("unexpected update, given = %q %d, expected = %q %d", updated.Subject, next, "c", version+1)
+			}
+			if _, versions, err := s.ReadTODOWithVersions(ctx, 0, 1); err != nil || versions[c.id] != next {
+				t.Errorf("unexpected version, given = %d %v, expected = %d", versions[c.id], err, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+)
+
+type (
+	txKey          struct{}
+	afterCommitKey struct{}
+)
+
+// A conn is implemented by both *sql.DB and *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// connFrom returns the transaction started by RunInTx for ctx, or db outside of it.
+func connFrom(ctx context.Context, db *sql.DB) conn {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// RunInTx runs fn in a transaction of db. The services called with the context
+// passed to fn join the transaction, which is committed when fn returns nil and
+// rolled back otherwise. When ctx already has a transaction fn joins it.
+func RunInTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	return runInTx(ctx, db, nil, fn)
+}
+
+// RunInReadTx runs fn in a read-only transaction of db, which reads one
+// snapshot without taking the write lock, so that readers neither wait for nor
+// block each other. When ctx already has a transaction fn joins it.
+func RunInReadTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	return runInTx(ctx, db, &sql.TxOptions{ReadOnly: true}, fn)
+}
+
+func runInTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	// a panic in fn must not leak the transaction and its connection
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	var hooks []func()
+	ctx = context.WithValue(ctx, afterCommitKey{}, &hooks)
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		hook()
+	}
+	return nil
+}
+
+// afterCommit runs fn when the transaction of ctx is committed, or at once
+// outside of a transaction.
+func afterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(afterCommitKey{}).(*[]func()); ok {
+		*hooks = append(*hooks, fn)
+		return
+	}
+	fn()
+}
+
+var savepointSeq int64
+
+// RunInSavepoint runs fn in a savepoint of the transaction of ctx, so that only
+// the changes made by fn are rolled back when it returns an error.
+func RunInSavepoint(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	return RunInTx(ctx, db, func(ctx context.Context) error {
+		c := connFrom(ctx, db)
+		name := fmt.Sprintf("sp%d", atomic.AddInt64(&savepointSeq, 1))
+
+		if _, err := c.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+			return err
+		}
+
+		if err := fn(ctx); err != nil {
+			if _, rerr := c.ExecContext(ctx, "ROLLBACK TO "+name); rerr != nil {
+				return rerr
+			}
+			if _, rerr := c.ExecContext(ctx, "RELEASE "+name); rerr != nil {
+				return rerr
+			}
+			return err
+		}
+
+		_, err := c.ExecContext(ctx, "RELEASE "+name)
+		return err
+	})
+}
@@ -0,0 +1,69 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestRunInTxPanic(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		run func(ctx context.Context, s *service.TODOService, fn func(ctx context.Context) error) error
+	}{
+		"RunInTx": {
+			run: func(ctx context.Context, s *service.TODOService, fn func(ctx context.Context) error) error {
+				return s.RunInTx(ctx, fn)
+			},
+		},
+		"RunInSavepoint": {
+			run: func(ctx context.Context, s *service.TODOService, fn func(ctx context.Context) error) error {
+				return s.RunInSavepoint(ctx, fn)
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			todoDB := newTestDB(t)
+			// one connection, which a leaked transaction would keep
+			todoDB.SetMaxOpenConns(1)
+			s := service.NewTODOService(todoDB)
+
+			func() {
+				defer func() {
+					if p := recover(); p != "panic in fn" {
+						t.Errorf("unexpected recover, given = %v, expected = %v", p, "panic in fn")
+					}
+				}()
+				c.run(ctx, s, func(ctx context.Context) error {
+					if _, err := s.CreateTODO(ctx, "rolled back", ""); err != nil {
+						return err
+					}
+					panic("panic in fn")
+				})
+			}()
+
+			// the connection is free again, or the write times out waiting for it
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if _, err := s.CreateTODO(ctx, "committed", ""); err != nil {
+				t.Fatal("failed to create todo, err =", err)
+			}
+			todos, err := s.ReadTODO(ctx, 0, 10)
+			if err != nil {
+				t.Fatal("failed to read todos, err =", err)
+			}
+			if len(todos) != 1 || todos[0].Subject != "committed" {
+				t.Errorf("unexpected todos, given = %d, expected = only the one committed", len(todos))
+			}
+		})
+	}
+}
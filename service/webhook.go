@@ -0,0 +1,682 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// The headers of the requests of deliveries.
+const (
+	// WebhookDeliveryHeader carries the id of the delivery, which is kept by
+	// retries so that receivers can drop the duplicates.
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+	// WebhookEventHeader carries the type of the event.
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookTimestampHeader carries the Unix time the request was signed at.
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// WebhookSignatureHeader carries the signature made by SignWebhook.
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookBackoff     = 10 * time.Second
+	defaultWebhookMaxBackoff  = time.Hour
+	defaultWebhookMaxAttempts = 10
+
+	// webhookBatchSize is the number of deliveries sent at once.
+	webhookBatchSize = 16
+	// webhookRetention is how long the log keeps the finished deliveries.
+	webhookRetention = 7 * 24 * time.Hour
+	// webhookTimeLayout is the layout of the times deliveries are scheduled at,
+	// which has milliseconds unlike dateTimeLayout for short backoffs.
+	webhookTimeLayout = "2006-01-02 15:04:05.000"
+)
+
+// A WebhookConfig expresses how deliveries are sent and retried. The zero
+// values are replaced by the defaults.
+type WebhookConfig struct {
+	// Client sends the requests. The default one times out in 10 seconds and
+	// does not follow redirects.
+	Client *http.Client
+	// Backoff is the delay of the first retry, which is doubled by every failed
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of attempts after which a delivery fails.
+	MaxAttempts int
+}
+
+// A WebhookService implements the webhooks subscribed to the events of TODOs
+// and the durable queue of their deliveries.
+type WebhookService struct {
+	db     *sql.DB
+	todos  *TODOService
+	config WebhookConfig
+	wake   chan struct{}
+}
+
+// NewWebhookService returns new WebhookService delivering the events logged by
+// todos.
+func NewWebhookService(db *sql.DB, todos *TODOService, config WebhookConfig) *WebhookService {
+	if config.Client == nil {
+		config.Client = &http.Client{
+			Timeout: defaultWebhookTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultWebhookBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultWebhookMaxAttempts
+	}
+
+	return &WebhookService{
+		db:     db,
+		todos:  todos,
+		config: config,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// SignWebhook returns the signature of the request body sent at timestamp: the
+// HMAC-SHA256 of the timestamp, a dot and the body keyed by the secret, hex
+// encoded after "sha256=".
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhook creates a webhook on DB. A secret is generated when secret is
+// empty.
+func (s *WebhookService) CreateWebhook(ctx context.Context, url string, eventTypes []string, secret string) (*model.Webhook, error) {
+	const insert = `INSERT INTO webhooks(url, event_types, secret) VALUES(?, ?, ?)`
+
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = "whsec_" + hex.EncodeToString(b)
+	}
+
+	var w *model.Webhook
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		res, err := s.conn(ctx).ExecContext(ctx, insert, url, strings.Join(eventTypes, ","), secret)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		w, err = s.readWebhook(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Secret = secret
+	return w, nil
+}
+
+// ReadWebhooks reads every webhook on DB.
+func (s *WebhookService) ReadWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	const read = `SELECT id, url, event_types, active, created_at, updated_at FROM webhooks ORDER BY id`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, read)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*model.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook updates the webhook on DB. The secret is kept when secret is
+// empty, and the webhook is enabled or disabled unless active is nil.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id int64, url string, eventTypes []string, secret string, active *bool) (*model.Webhook, error) {
+	const update = `UPDATE webhooks SET url = ?, event_types = ?,
+		secret = CASE WHEN ? = '' THEN secret ELSE ? END, active = COALESCE(?, active),
+		updated_at = DATETIME('now') WHERE id = ?`
+
+	var w *model.Webhook
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		res, err := s.conn(ctx).ExecContext(ctx, update, url, strings.Join(eventTypes, ","), secret, secret, active, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return model.ErrNotFound{}
+		}
+
+		// the deliveries held while it was disabled are sent
+		afterCommit(ctx, s.notify)
+
+		w, err = s.readWebhook(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Secret = secret
+	return w, nil
+}
+
+// DeleteWebhook deletes the webhooks on DB by ids with their deliveries.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, ids []int64) error {
+	const (
+		deleteAttemptsFmt   = `DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id IN (?%s))`
+		deleteDeliveriesFmt = `DELETE FROM webhook_deliveries WHERE webhook_id IN (?%s)`
+		deleteFmt           = `DELETE FROM webhooks WHERE id IN (?%s)`
+	)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.Repeat(", ?", len(ids)-1)
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		for _, f := range []string{deleteAttemptsFmt, deleteDeliveriesFmt} {
+			if _, err := s.conn(ctx).ExecContext(ctx, fmt.Sprintf(f, placeholders), args...); err != nil {
+				return err
+			}
+		}
+
+		res, err := s.conn(ctx).ExecContext(ctx, fmt.Sprintf(deleteFmt, placeholders), args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return model.ErrNotFound{}
+		}
+		return nil
+	})
+}
+
+// ReadDeliveries reads the deliveries with their attempts in the descending
+// order of ids, those of the webhook unless webhookID is 0 and of the status
+// unless it is empty.
+func (s *WebhookService) ReadDeliveries(ctx context.Context, webhookID int64, status string, prevID, size int64) ([]*model.WebhookDelivery, error) {
+	query := `SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, redelivery_of, created_at
+		FROM webhook_deliveries WHERE 1 = 1`
+	var args []interface{}
+	if webhookID != 0 {
+		query += ` AND webhook_id = ?`
+		args = append(args, webhookID)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if prevID != 0 {
+		query += ` AND id < ?`
+		args = append(args, prevID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, size)
+
+	deliveries := []*model.WebhookDelivery{}
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, d)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return s.readAttempts(ctx, deliveries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues a new delivery of the event of the delivery of id to the
+// same webhook, which must be enabled.
+func (s *WebhookService) Redeliver(ctx context.Context, id int64) (*model.WebhookDelivery, error) {
+	const (
+		find   = `SELECT w.active FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id WHERE d.id = ?`
+		insert = `INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, next_attempt_at, redelivery_of)
+			SELECT webhook_id, event_id, event_type, payload, ?, id FROM webhook_deliveries WHERE id = ?`
+		read = `SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, redelivery_of, created_at
+			FROM webhook_deliveries WHERE id = ?`
+	)
+
+	var d *model.WebhookDelivery
+	err := RunInTx(ctx, s.db, func(ctx context.Context) error {
+		var active bool
+		switch err := s.conn(ctx).QueryRowContext(ctx, find, id).Scan(&active); {
+		case err == sql.ErrNoRows:
+			return model.ErrNotFound{}
+		case err != nil:
+			return err
+		case !active:
+			return model.ErrConflict{Message: "the webhook of the delivery is disabled"}
+		}
+
+		res, err := s.conn(ctx).ExecContext(ctx, insert, time.Now().UTC().Format(webhookTimeLayout), id)
+		if err != nil {
+			return err
+		}
+		newID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if d, err = scanDelivery(s.conn(ctx).QueryRowContext(ctx, read, newID)); err != nil {
+			return err
+		}
+		afterCommit(ctx, s.notify)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Run sends the pending deliveries until ctx is done, waking up when events are
+// logged and when retries are due. A delivery interrupted by ctx stays pending
+// and is sent again by the next run, so that every event is delivered at least
+// once.
+func (s *WebhookService) Run(ctx context.Context) {
+	notified, cancel := s.todos.SubscribeEvents()
+	defer cancel()
+
+	var pruned time.Time
+	for {
+		if time.Since(pruned) > time.Hour {
+			if err := s.prune(ctx); err != nil && ctx.Err() == nil {
+				log.Println(err)
+			}
+			pruned = time.Now()
+		}
+
+		next, err := s.deliverDue(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			next = time.Now().Add(s.config.Backoff)
+		}
+
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-notified:
+		case <-s.wake:
+		case <-due:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// notify wakes Run up for the deliveries queued outside of the event log.
+func (s *WebhookService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// A pendingDelivery expresses a delivery being sent.
+type pendingDelivery struct {
+	id        int64
+	eventType string
+	payload   string
+	attempts  int
+	url       string
+	secret    string
+}
+
+// deliverDue sends the deliveries due by now, and returns when the next one is
+// due, or the zero time when none is pending.
+func (s *WebhookService) deliverDue(ctx context.Context) (time.Time, error) {
+	const next = `SELECT d.next_attempt_at FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND w.active = 1 ORDER BY d.next_attempt_at LIMIT 1`
+
+	for {
+		batch, err := s.dueDeliveries(ctx)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			wg.Add(1)
+			go func(d *pendingDelivery) {
+				defer wg.Done()
+				if err := s.deliver(ctx, d); err != nil && ctx.Err() == nil {
+					log.Println(err)
+				}
+			}(d)
+		}
+		wg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			return time.Time{}, err
+		}
+		if len(batch) < webhookBatchSize {
+			break
+		}
+	}
+
+	var t sql.NullTime
+	if err := s.conn(ctx).QueryRowContext(ctx, next).Scan(&t); err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return t.Time, nil
+}
+
+func (s *WebhookService) dueDeliveries(ctx context.Context) ([]*pendingDelivery, error) {
+	const due = `SELECT d.id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND w.active = 1 AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at, d.id LIMIT ?`
+
+	rows, err := s.conn(ctx).QueryContext(ctx, due, time.Now().UTC().Format(webhookTimeLayout), webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []*pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.eventType, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			return nil, err
+		}
+		batch = append(batch, &d)
+	}
+	return batch, rows.Err()
+}
+
+// deliver sends the delivery once and records the attempt.
+func (s *WebhookService) deliver(ctx context.Context, d *pendingDelivery) error {
+	attempt := &model.WebhookAttempt{}
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, strings.NewReader(d.payload))
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		timestamp := start.Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "go-stations-webhook/1")
+		req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.id, 10))
+		req.Header.Set(WebhookEventHeader, d.eventType)
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(d.secret, timestamp, []byte(d.payload)))
+
+		res, err := s.config.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			attempt.Error = err.Error()
+		} else {
+			// drained so that the connection is reused
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+			attempt.StatusCode = res.StatusCode
+		}
+	}
+	attempt.DurationMS = time.Since(start).Milliseconds()
+
+	// recorded even when ctx is done so that acknowledged deliveries are not
+	// sent again
+	return s.recordAttempt(context.Background(), d, attempt)
+}
+
+// recordAttempt logs the attempt, and schedules the retry of the delivery
+// unless it succeeded or ran out of attempts.
+func (s *WebhookService) recordAttempt(ctx context.Context, d *pendingDelivery, attempt *model.WebhookAttempt) error {
+	const (
+		insert = `INSERT INTO webhook_attempts(delivery_id, status_code, error, duration_ms, created_at) VALUES(?, ?, ?, ?, ?)`
+		update = `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ? WHERE id = ?`
+	)
+
+	now := time.Now().UTC()
+	attempts := d.attempts + 1
+	status, next := model.DeliverySucceeded, interface{}(nil)
+	if attempt.StatusCode < 200 || attempt.StatusCode > 299 {
+		status = model.DeliveryFailed
+		if attempts < s.config.MaxAttempts {
+			status, next = model.DeliveryPending, now.Add(s.backoff(attempts)).Format(webhookTimeLayout)
+		}
+	}
+
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		_, err := s.conn(ctx).ExecContext(ctx, insert, d.id, attempt.StatusCode, attempt.Error, attempt.DurationMS, now.Format(webhookTimeLayout))
+		if err != nil {
+			return err
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, update, status, attempts, next, d.id)
+		return err
+	})
+}
+
+// backoff returns the delay of the retry after the failed attempts.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	d := s.config.Backoff
+	for i := 1; i < attempts && d < s.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.config.MaxBackoff {
+		d = s.config.MaxBackoff
+	}
+	return d
+}
+
+// prune drops the finished deliveries older than webhookRetention.
+func (s *WebhookService) prune(ctx context.Context) error {
+	const (
+		deleteAttempts = `DELETE FROM webhook_attempts WHERE delivery_id IN
+			(SELECT id FROM webhook_deliveries WHERE status <> 'pending' AND created_at < ?)`
+		deleteDeliveries = `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < ?`
+	)
+
+	before := time.Now().Add(-webhookRetention).UTC().Format(dateTimeLayout)
+	return RunInTx(ctx, s.db, func(ctx context.Context) error {
+		if _, err := s.conn(ctx).ExecContext(ctx, deleteAttempts, before); err != nil {
+			return err
+		}
+		_, err := s.conn(ctx).ExecContext(ctx, deleteDeliveries, before)
+		return err
+	})
+}
+
+func (s *WebhookService) readWebhook(ctx context.Context, id int64) (*model.Webhook, error) {
+	const read = `SELECT id, url, event_types, active, created_at, updated_at FROM webhooks WHERE id = ?`
+
+	w, err := scanWebhook(s.conn(ctx).QueryRowContext(ctx, read, id))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotFound{}
+	}
+	return w, err
+}
+
+// readAttempts fills the logs of the deliveries.
+func (s *WebhookService) readAttempts(ctx context.Context, deliveries []*model.WebhookDelivery) error {
+	const readFmt = `SELECT delivery_id, status_code, error, duration_ms, created_at FROM webhook_attempts
+		WHERE delivery_id IN (?%s) ORDER BY id`
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*model.WebhookDelivery, len(deliveries))
+	args := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		byID[d.ID] = d
+		args[i] = d.ID
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx, fmt.Sprintf(readFmt, strings.Repeat(", ?", len(deliveries)-1)), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id int64
+			a  model.WebhookAttempt
+		)
+		if err := rows.Scan(&id, &a.StatusCode, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+			return err
+		}
+		byID[id].Log = append(byID[id].Log, &a)
+	}
+	return rows.Err()
+}
+
+func (s *WebhookService) conn(ctx context.Context) conn {
+	return connFrom(ctx, s.db)
+}
+
+// A scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row scanner) (*model.Webhook, error) {
+	var (
+		w          model.Webhook
+		eventTypes string
+	)
+	if err := row.Scan(&w.ID, &w.URL, &eventTypes, &w.Active, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	w.EventTypes = []string{}
+	if eventTypes != "" {
+		w.EventTypes = strings.Split(eventTypes, ",")
+	}
+	return &w, nil
+}
+
+func scanDelivery(row scanner) (*model.WebhookDelivery, error) {
+	var (
+		d            model.WebhookDelivery
+		payload      string
+		next         sql.NullTime
+		redeliveryOf sql.NullInt64
+	)
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &next, &redeliveryOf, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if next.Valid {
+		d.NextAttemptAt = &next.Time
+	}
+	if redeliveryOf.Valid {
+		d.RedeliveryOf = &redeliveryOf.Int64
+	}
+	d.Log = []*model.WebhookAttempt{}
+	return &d, nil
+}
+
+// enqueueDeliveries queues the deliveries of the n events logged after afterID
+// to the enabled webhooks subscribed to them, in the transaction of ctx so that
+// they are committed together with the changes.
+func (s *TODOService) enqueueDeliveries(ctx context.Context, afterID, n int64) error {
+	const (
+		enabled = `SELECT EXISTS(SELECT 1 FROM webhooks WHERE active = 1)`
+		insert  = `INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, next_attempt_at)
+			SELECT id, ?, ?, ?, ? FROM webhooks
+			WHERE active = 1 AND (event_types = '' OR INSTR(',' || event_types || ',', ?) > 0)`
+	)
+
+	var exists bool
+	if err := s.conn(ctx).QueryRowContext(ctx, enabled).Scan(&exists); err != nil || !exists {
+		return err
+	}
+
+	events, err := s.ReadEvents(ctx, &model.ReadEventsRequest{}, afterID, n)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(webhookTimeLayout)
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := s.conn(ctx).ExecContext(ctx, insert, e.ID, e.Type, string(payload), now, ","+e.Type+","); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "webhook_test.db"))
+	if err != nil {
+		t.Fatal("failed to create db, err =", err)
+	}
+	t.Cleanup(func() { todoDB.Close() })
+
+	const secret = "s3cret"
+	received := make(chan *http.Request, 10)
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(service.WebhookTimestampHeader), 10, 64)
+		if r.Header.Get(service.WebhookSignatureHeader) != service.SignWebhook(secret, timestamp, body) {
+			t.Error("invalid signature of delivery", r.Header.Get(service.WebhookDeliveryHeader))
+		}
+
+		// the first attempt fails to be retried
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		received <- r
+	}))
+	t.Cleanup(receiver.Close)
+
+	ts := service.NewTODOService(todoDB)
+	ws := service.NewWebhookService(todoDB, ts, service.WebhookConfig{Backoff: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	w, err := ws.CreateWebhook(ctx, receiver.URL, []string{model.EventCreated}, secret)
+	if err != nil {
+		t.Fatal("failed to create webhook, err =", err)
+	}
+	todo, err := ts.CreateTODO(ctx, "subject", "")
+	if err != nil {
+		t.Fatal("failed to create todo, err =", err)
+	}
+	// updated events are not subscribed
+	if _, err := ts.UpdateTODO(ctx, todo.ID, "updated", ""); err != nil {
+		t.Fatal("failed to update todo, err =", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-received:
+			if got := r.Header.Get(service.WebhookEventHeader); got != model.EventCreated {
+				t.Errorf("unexpected event, given = %s, expected = %s", got, model.EventCreated)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("delivery timed out")
+		}
+	}
+
+	var deliveries []*model.WebhookDelivery
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		deliveries, err = ws.ReadDeliveries(ctx, w.ID, "", 0, 10)
+		if err != nil {
+			t.Fatal("failed to read deliveries, err =", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != model.DeliveryPending {
+			break
+		}
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("unexpected deliveries, given = %d, expected = 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != model.DeliverySucceeded || d.Attempts != 2 || len(d.Log) != 2 {
+		t.Errorf("unexpected delivery, given = %s after %d attempts, expected = succeeded after 2", d.Status, d.Attempts)
+	}
+	if len(d.Log) == 2 && (d.Log[0].StatusCode != http.StatusInternalServerError || d.Log[1].StatusCode != http.StatusOK) {
+		t.Errorf("unexpected log, given = %d, %d", d.Log[0].StatusCode, d.Log[1].StatusCode)
+	}
+
+	var event model.TODOEvent
+	if err := json.Unmarshal(d.Payload, &event); err != nil || event.TODOID != todo.ID {
+		t.Errorf("unexpected payload %s, err = %v", d.Payload, err)
+	}
+
+	redelivery, err := ws.Redeliver(ctx, d.ID)
+	if err != nil {
+		t.Fatal("failed to redeliver, err =", err)
+	}
+	if redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != d.ID {
+		t.Error("redelivery does not refer to the delivery")
+	}
+	select {
+	case r := <-received:
+		if got := r.Header.Get(service.WebhookDeliveryHeader); got != strconv.FormatInt(redelivery.ID, 10) {
+			t.Errorf("unexpected delivery, given = %s, expected = %d", got, redelivery.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("redelivery timed out")
+	}
+}